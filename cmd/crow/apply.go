@@ -5,27 +5,41 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"time"
 
 	"github.com/qrv0/crow/internal/cawsf"
-	"github.com/qrv0/crow/internal/fileformat"
 )
 
 func cmdApply() {
 	fs := flag.NewFlagSet("apply", flag.ExitOnError)
 	in := fs.String("in", "", "input .cawsf")
 	scope := fs.Int("scope", -1, "scope id to apply")
-	xlen := fs.Int("xlen", 0, "length of input vector (must match cols)")
+	name := fs.String("name", "", "tensor name to apply, instead of --scope")
+	xlen := fs.Int("xlen", 0, "length of input vector (must match cols, or rows with --transpose)")
+	transpose := fs.Bool("transpose", false, "compute y = W^T*x instead of W*x")
+	repeat := fs.Int("repeat", 1, "apply the scope this many times")
+	cacheMB := fs.Int("cache-mb", 0, "keep decoded shards in a cache of this many MiB (0 = decode every time)")
+	evict := fs.String("evict", "lru", "cache eviction: lru, lfu or cost")
+	threads := fs.Int("threads", 0, "kernel worker goroutines (0 = GOMAXPROCS)")
 	fs.Parse(os.Args[2:])
-	if *in == "" || *scope < 0 || *xlen <= 0 {
-		fmt.Println("usage: crow apply --in model.cawsf --scope N --xlen COLS")
+	if *in == "" || (*scope < 0 && *name == "") || *xlen <= 0 {
+		fmt.Println("usage: crow apply --in model.cawsf (--scope N | --name TENSOR) --xlen COLS [--transpose] [--repeat 1] [--cache-mb 0] [--evict lru|lfu|cost] [--threads 0]")
 		os.Exit(1)
 	}
-	r, err := fileformat.OpenCAWSF(*in)
-	if err != nil { fmt.Fprintf(os.Stderr, "apply: open error: %v\n", err); os.Exit(1) }
-	defer r.Close()
-	bank, _ := r.SectionUncompressed(fileformat.TypeShardBank)
-	codebooks, _ := r.SectionUncompressed(fileformat.TypeCodebooks)
-	pool, _ := cawsf.ParseCodebookPool(codebooks)
+	cawsf.SetWorkers(*threads)
+	var cache *cawsf.ShardCache
+	if *cacheMB > 0 {
+		ev, err := cawsf.ParseEviction(*evict)
+		if err != nil { fmt.Fprintf(os.Stderr, "apply: %v\n", err); os.Exit(1) }
+		cache = cawsf.NewShardCache(int64(*cacheMB)<<20, ev)
+	}
+	m, err := cawsf.OpenModel(*in, cache)
+	if err != nil { fmt.Fprintf(os.Stderr, "apply: %v\n", err); os.Exit(1) }
+	if *name != "" {
+		sc, ok := m.ScopeByName(*name)
+		if !ok { fmt.Fprintf(os.Stderr, "apply: no tensor named %q\n", *name); os.Exit(1) }
+		*scope = int(sc.ID)
+	}
 	// build a simple deterministic x vector of length xlen
 	x := make([]float32, *xlen)
 	for i := range x {
@@ -33,12 +47,27 @@ func cmdApply() {
 		u := uint32(2166136261 + i*16777619)
 		x[i] = math.Float32frombits(u)
 	}
-	y, rows, cols, err := cawsf.MultiplyScopeWithPool(bank, pool, uint16(*scope), x)
-	if err != nil { fmt.Fprintf(os.Stderr, "apply: compute error: %v\n", err); os.Exit(1) }
-	if cols != *xlen { fmt.Printf("warning: xlen=%d but cols=%d\n", *xlen, cols) }
-	fmt.Printf("y (rows=%d):\n", rows)
+	var y []float32
+	var rows, cols int
+	start := time.Now()
+	for i := 0; i < max(*repeat, 1); i++ {
+		if *transpose {
+			y, rows, cols, err = m.MultiplyTransposed(uint16(*scope), x)
+		} else {
+			y, rows, cols, err = m.Multiply(uint16(*scope), x)
+		}
+		if err != nil { fmt.Fprintf(os.Stderr, "apply: compute error: %v\n", err); os.Exit(1) }
+	}
+	if *repeat > 1 { fmt.Printf("%d applies in %v\n", *repeat, time.Since(start)) }
+	if cache != nil {
+		st := cache.Stats()
+		fmt.Printf("cache: %d hits, %d misses, %d evictions, %d shards, %d/%d bytes\n", st.Hits, st.Misses, st.Evictions, st.Entries, st.Bytes, st.Budget)
+	}
+	out := rows
+	if *transpose { out = cols }
+	fmt.Printf("y (len=%d, W is %dx%d):\n", out, rows, cols)
 	// print first 16
 	n := 16
-	if rows < n { n = rows }
+	if out < n { n = out }
 	for i := 0; i < n; i++ { fmt.Printf("  y[%d]=%.6f\n", i, y[i]) }
 }
@@ -13,6 +13,7 @@ import (
 
 	"github.com/qrv0/crow/internal/convert"
 	"github.com/qrv0/crow/internal/fileformat"
+	"github.com/qrv0/crow/internal/quant"
 	"github.com/qrv0/crow/internal/safetensors"
 
 	xxh3 "github.com/zeebo/xxh3"
@@ -77,12 +78,44 @@ func cmdConvert() {
     outPath := fs.String("out", "", "output .cawsf")
     rank := fs.Int("rank", 64, "low-rank")
     outlierQ := fs.Float64("outlier-q", 0.999, "outlier quantile")
+    outlierStrategy := fs.String("outlier-strategy", convert.OutliersQuantile, "S outlier selection: quantile, row-topk, col-topk, threshold or budget")
+    outlierK := fs.Int("outlier-k", 4, "outliers per row/column for row-topk/col-topk")
+    outlierTh := fs.Float64("outlier-threshold", 0, "minimum |residue| for the threshold strategy")
+    outlierBudget := fs.Int("outlier-budget", 0, "outliers per layer for the budget strategy")
+    codec := fs.String("codec", convert.CodecPQ, "R codec: pq, rvq (residual PQ, row layout), int4 or int8 (group-wise scalar)")
+    rvqStages := fs.Int("rvq-stages", 2, "residual stages for --codec rvq")
+    intGroup := fs.Int("int-group", 64, "columns per scale group for --codec int4/int8")
+    intZP := fs.Bool("int-zp", false, "asymmetric int4/int8 groups with a zero-point")
+    seed := fs.Int64("seed", 1234, "k-means seed")
+    rotation := fs.String("rotation", "", "rotate R blocks before PQ: opq (learned) or hadamard (randomized, power-of-two --pq-d)")
+    kmBatch := fs.Int("kmeans-batch", 0, "k-means mini-batch size (0 = auto for large layers, -1 = always full batch)")
     pqm := fs.Int("pq-m", 8, "PQ m")
     pqk := fs.Int("pq-k", 256, "PQ k")
+    pqFP16 := fs.Bool("pq-fp16", false, "store R codebooks as fp16 (row layout)")
+    pqGroup := fs.String("pq-group", "", "share R codebooks across layers: role (one per tensor role, e.g. q_proj) or a group name; grouped layers stay in memory until their codebook is trained")
+    pqd := fs.Int("pq-d", 128, "PQ sub-vector (R block) dimension")
+    sEnc := fs.String("s-enc", convert.SEncCSR, "S outlier encoding: csr (fp16 values), csr-bf16 or triplet (legacy)")
+    rLayout := fs.String("r-layout", convert.LayoutRow, "R block layout: row (blocks tile each row) or flat (legacy)")
     maxLayers := fs.Int("max-layers", 0, "optional: process only first N 2D layers (0=all)")
     maxElems := fs.Int("max-elems", 0, "optional: skip 2D layers with more than N elements (0=no limit)")
+    policyPath := fs.String("policy", "", "optional: JSON policy with per-tensor rules")
+    plan := fs.Bool("plan", false, "dry run: print expected shard sizes and cost from the safetensors header only")
+    planGFlops := fs.Float64("plan-gflops", 2, "assumed sustained GFLOP/s for --plan time estimates")
     fs.Parse(os.Args[2:])
-	if *inPath == "" || *outPath == "" { fmt.Println("usage: crow convert --model x.safetensors --out y.cawsf"); os.Exit(1) }
+	if *inPath == "" || (*outPath == "" && !*plan) { fmt.Println("usage: crow convert --model x.safetensors --out y.cawsf [--policy policy.json] [--plan]"); os.Exit(1) }
+	var pol *convert.Policy
+	var polRaw []byte
+	if *policyPath != "" {
+		b, err := os.ReadFile(*policyPath)
+		if err != nil { fmt.Fprintf(os.Stderr, "convert: read policy: %v\n", err); os.Exit(1) }
+		if pol, err = convert.ParsePolicy(b); err != nil { fmt.Fprintf(os.Stderr, "convert: %v\n", err); os.Exit(1) }
+		polRaw = b
+	}
+    baseCfg := convert.Config{Rank: *rank, OutlierStrategy: *outlierStrategy, OutlierQuantile: *outlierQ, OutlierK: *outlierK, OutlierThreshold: *outlierTh, OutlierBudget: *outlierBudget, PQm: *pqm, PQk: *pqk, PQd: *pqd, PQfp16: *pqFP16, PQGroup: *pqGroup, RLayout: *rLayout, SEncoding: *sEnc, Codec: *codec, RVQStages: *rvqStages, IntGroup: *intGroup, IntZeroPoint: *intZP, Seed: *seed, KMeansBatch: *kmBatch, Rotation: *rotation}
+	if *plan {
+		if err := planConvert(*inPath, pol, baseCfg, *maxLayers, *maxElems, *planGFlops); err != nil { fmt.Fprintf(os.Stderr, "convert: plan: %v\n", err); os.Exit(1) }
+		return
+	}
 	st, err := safetensors.Open(*inPath)
 	if err != nil { fmt.Fprintf(os.Stderr, "convert: open safetensors: %v\n", err); os.Exit(1) }
     // Build layer specs (2D tensors)
@@ -94,9 +127,11 @@ func cmdConvert() {
 	}
 	// try to capture tokenizer reference and hf config near the model path
 	dir := filepath.Dir(*inPath)
-	if tok, err := os.ReadFile(filepath.Join(dir, "tokenizer.json")); err == nil && len(tok) > 0 {
+	tokJSON, err := os.ReadFile(filepath.Join(dir, "tokenizer.json"))
+	if err == nil && len(tokJSON) > 0 {
 		meta["tokenizer"] = "local"
 	} else {
+		tokJSON = nil
 		meta["tokenizer"] = "gpt2"
 	}
 	if cfgBytes, err := os.ReadFile(filepath.Join(dir, "config.json")); err == nil {
@@ -105,6 +140,9 @@ func cmdConvert() {
 			meta["hf_config"] = cfg
 		}
 	}
+	if polRaw != nil {
+		meta["policy"] = json.RawMessage(polRaw)
+	}
 	if tcfgBytes, err := os.ReadFile(filepath.Join(dir, "tokenizer_config.json")); err == nil {
 		var tcfg map[string]any
 		if json.Unmarshal(tcfgBytes, &tcfg) == nil {
@@ -117,27 +155,62 @@ func cmdConvert() {
 	for name := range st.Tensors { names = append(names, name) }
 	sort.Strings(names)
     processed := 0
+    var skipped []string
+    groups := map[string][]*convert.Decomposed{}
+    var groupKeys []string
     for _, name := range names {
         t := st.Tensors[name]
-        if len(t.Meta.Shape) != 2 { continue }
-        rows, cols := int(t.Meta.Shape[0]), int(t.Meta.Shape[1])
+        rows, cols, ok := tensorMatrix(t.Meta.Shape)
+        if !ok { continue }
+        vector := len(t.Meta.Shape) == 1
         nelem := rows*cols
-        if *maxElems > 0 && nelem > *maxElems { continue }
-        if *maxLayers > 0 && processed >= *maxLayers { break }
+        if !vector && *maxElems > 0 && nelem > *maxElems { continue }
+        if !vector && *maxLayers > 0 && processed >= *maxLayers { break }
+        dec := pol.Resolve(name, baseCfg)
+        if dec.Skip { skipped = append(skipped, name); continue }
+        if vector { dec.Config = convert.Config{StoreRaw: true} }
         // decode tensor data to float32 considering dtype
         data := bytesToF32WithDtype(t.Data, t.Meta.Dtype, nelem)
         spec := convert.LayerSpec{Name: name, Rows: rows, Cols: cols, Data: data, Scope: scope}
-        cfg := convert.Config{Rank: *rank, OutlierQuantile: *outlierQ, PQm: *pqm, PQk: *pqk}
-        shs, err := convert.ConvertLayer(spec, cfg)
-        if err != nil { fmt.Fprintf(os.Stderr, "convert: layer %s error: %v\n", name, err); os.Exit(1) }
-        for _, s := range shs {
-            shardBlobs = append(shardBlobs, packShard(s.Type, s.Scope, s.Data))
+        applied := dec.Config.Describe()
+        if dec.Rule >= 0 { applied["rule"] = dec.Rule }
+        shape := []int{rows, cols}
+        if vector { shape = []int{cols} }
+        layer := map[string]any{"scope_id": scope, "name": name, "shape": shape, "policy": applied}
+        if key := convert.GroupKey(name, rows, cols, dec.Config); key != "" {
+            // R is encoded once the whole group has been seen
+            d, err := convert.Decompose(spec, dec.Config)
+            if err != nil { fmt.Fprintf(os.Stderr, "convert: layer %s error: %v\n", name, err); os.Exit(1) }
+            if _, ok := groups[key]; !ok { groupKeys = append(groupKeys, key) }
+            groups[key] = append(groups[key], d)
+            layer["codebook_group"] = key
+            for k, v := range d.Meta { layer[k] = v }
+        } else {
+            res, err := convert.ConvertLayerWithMeta(spec, dec.Config)
+            if err != nil { fmt.Fprintf(os.Stderr, "convert: layer %s error: %v\n", name, err); os.Exit(1) }
+            for _, s := range res.Shards {
+                shardBlobs = append(shardBlobs, packShard(s.Type, s.Scope, s.Data))
+            }
+            for k, v := range res.Meta { layer[k] = v }
         }
-        layers = append(layers, map[string]any{"scope_id": scope, "name": name, "shape": []int{rows, cols}})
+        layers = append(layers, layer)
         scope++
-        processed++
+        if !vector { processed++ }
     }
+	// one k-means per group over pooled samples; identical embedded codebooks
+	// then collapse into a single CODEBOOKS entry below
+	for _, key := range groupKeys {
+		members := groups[key]
+		pq, err := convert.TrainGroupPQ(members)
+		if err != nil { fmt.Fprintf(os.Stderr, "convert: codebook group %s: %v\n", key, err); os.Exit(1) }
+		for _, d := range members {
+			res, err := d.Encode(pq)
+			if err != nil { fmt.Fprintf(os.Stderr, "convert: layer %s error: %v\n", d.Spec.Name, err); os.Exit(1) }
+			for _, s := range res.Shards { shardBlobs = append(shardBlobs, packShard(s.Type, s.Scope, s.Data)) }
+		}
+	}
 	meta["layers"] = layers
+	if len(skipped) > 0 { meta["skipped_layers"] = skipped }
 	// Extract codebooks from R shards and rewrite R payloads to reference shared codebooks
 	rewritten, codebooks := rewriteRShardsWithSharedCodebooks(shardBlobs)
 	bankBytes := bytesJoin(rewritten)
@@ -148,6 +221,7 @@ func cmdConvert() {
 	chk[fmt.Sprint(fileformat.TypeCodebooks)] = rollingXXH3Index(codebooks, 1<<20)
 	chk[fmt.Sprint(fileformat.TypeShardBank)] = rollingXXH3Index(bankBytes, 1<<20)
 	chk[fmt.Sprint(fileformat.TypeRouting)] = rollingXXH3Index(routing, 1<<20)
+	if tokJSON != nil { chk[fmt.Sprint(fileformat.TypeTokenizer)] = rollingXXH3Index(tokJSON, 1<<20) }
 	meta["checksum_index"] = chk
 	// META as JSON
 	metaBytes, _ := json.Marshal(meta)
@@ -160,6 +234,7 @@ func cmdConvert() {
     writer.AddSection(fileformat.TypeShardBank, bankBytes, fileformat.FlagCompLZ4)
     // ROUTING is small; keep raw for simplicity
     writer.AddSection(fileformat.TypeRouting, routing, 0)
+	if tokJSON != nil { writer.AddSection(fileformat.TypeTokenizer, tokJSON, fileformat.FlagCompZSTD) }
 	if err := writer.Write(*outPath); err != nil { fmt.Fprintf(os.Stderr, "convert: write %s error: %v\n", *outPath, err); os.Exit(1) }
 	fmt.Println("Converted:", *outPath)
 }
@@ -183,7 +258,7 @@ func bytesToF32WithDtype(b []byte, dtype string, nelem int) []float32 {
 		for i := 0; i < nelem; i++ {
 			if 2*i+2 > len(b) { break }
 			h := uint16(b[2*i]) | uint16(b[2*i+1])<<8
-			out[i] = fp16to32(h)
+			out[i] = quant.FP16ToFloat32(h)
 		}
 		return out
 	case "BF16", "bfloat16":
@@ -201,27 +276,17 @@ func bytesToF32WithDtype(b []byte, dtype string, nelem int) []float32 {
 	}
 }
 
-func fp16to32(h uint16) float32 {
-	s := uint32(h>>15) & 0x1
-	e := uint32(h>>10) & 0x1F
-	m := uint32(h) & 0x3FF
-	var f uint32
-	if e == 0 {
-		if m == 0 { f = s << 31 } else {
-			// subnormal
-			e2 := uint32(127 - 15 + 1)
-			m2 := m << 13
-			for (m2 & (1<<23)) == 0 { m2 <<= 1; e2-- }
-			m2 &= (1<<23)-1
-			f = (s<<31) | (e2<<23) | m2
-		}
-	} else if e == 0x1F {
-		f = (s<<31) | (0xFF<<23) | (m<<13)
-	} else {
-		e2 := (e) - 15 + 127
-		f = (s<<31) | (e2<<23) | (m<<13)
+// tensorMatrix returns the matrix shape a tensor is converted as: 2-D
+// tensors as they are, 1-D ones (norm weights, biases) as a single row,
+// which convert stores raw.
+func tensorMatrix(shape []int64) (rows, cols int, ok bool) {
+	switch len(shape) {
+	case 1:
+		return 1, int(shape[0]), true
+	case 2:
+		return int(shape[0]), int(shape[1]), true
 	}
-	return math.Float32frombits(f)
+	return 0, 0, false
 }
 
 func packShard(t uint8, scope uint16, payload []byte) []byte {
@@ -238,37 +303,45 @@ func packShard(t uint8, scope uint16, payload []byte) []byte {
 // rewriteRShardsWithSharedCodebooks scans R-shards and builds a shared CODEBOOKS section.
 // It also rewrites R payloads to drop embedded codebooks, storing only header and codes
 // and an extra field codebook_id:uint16 referring to the shared pool.
+// Flat-layout shards (type 1) keep their rows..n header followed by codebook_id; row-aligned
+// shards (type 4) set the shared-codebook flag and put codebook_id after their 16-byte header.
 func rewriteRShardsWithSharedCodebooks(shards [][]byte) (rewritten [][]byte, codebooks []byte) {
-	var rInfos []struct{ idx int; scope uint16; rows, cols, d, m, k, n int; cb []byte; codes []byte }
+	type rInfo struct{ idx int; hdr []byte; d, m, k int; cb []byte; codes []byte }
+	var rInfos []rInfo
 	// We'll collect R info, and keep slots for rewritten blobs by index to preserve order.
 	rewritten = make([][]byte, len(shards))
-	isR := make([]bool, len(shards))
 	for i, blob := range shards {
 		if len(blob) < 12 { continue }
 		h := blob[:12]
-		if h[0] != 1 { // non-R: keep as-is (order preserved)
+		if h[0] != convert.TypeR && h[0] != convert.TypeRRow { // non-R: keep as-is (order preserved)
 			rewritten[i] = blob
 			continue
 		}
-		isR[i] = true
 		csize := int(binary.LittleEndian.Uint32(h[8:12]))
 		payload := blob[12:12+csize]
-		if len(payload) < 18 { // rows, cols, d, m, k, n
+		// flat: rows, cols, d, m, k, n (18 bytes); row-aligned: rows, cols, d, m, k, bits, flags (16 bytes) + rotation
+		hlen := 18
+		if h[0] == convert.TypeRRow {
+			// a rotation block, if any, stays with the header
+			n, err := convert.RRowPrefixLen(payload)
+			if err != nil { rewritten[i] = blob; continue }
+			hlen = n
+		}
+		if len(payload) < hlen {
 			rewritten[i] = blob
 			continue
 		}
-		rows := int(binary.LittleEndian.Uint32(payload[0:4]))
-		cols := int(binary.LittleEndian.Uint32(payload[4:8]))
+		if h[0] == convert.TypeRRow && payload[15]&convert.RFlagSharedCB != 0 { rewritten[i] = blob; continue } // already shared
 		d := int(binary.LittleEndian.Uint16(payload[8:10]))
 		m := int(binary.LittleEndian.Uint16(payload[10:12]))
 		k := int(binary.LittleEndian.Uint16(payload[12:14]))
-		n := int(binary.LittleEndian.Uint32(payload[14:18]))
 		dsub := d / m
-		cbSz := m * k * dsub * 4
-		if 18+cbSz > len(payload) { rewritten[i] = blob; continue }
-		cb := payload[18 : 18+cbSz]
-		codes := payload[18+cbSz:]
-		rInfos = append(rInfos, struct{ idx int; scope uint16; rows, cols, d, m, k, n int; cb []byte; codes []byte }{idx: i, scope: uint16(h[1]) | uint16(h[2])<<8, rows: rows, cols: cols, d: d, m: m, k: k, n: n, cb: cb, codes: codes})
+		// fp16 codebooks keep their width in CODEBOOKS, told apart by entry size
+		elem := 4
+		if h[0] == convert.TypeRRow && payload[15]&convert.RFlagCBFP16 != 0 { elem = 2 }
+		cbSz := m * k * dsub * elem
+		if hlen+cbSz > len(payload) { rewritten[i] = blob; continue }
+		rInfos = append(rInfos, rInfo{idx: i, hdr: payload[:hlen], d: d, m: m, k: k, cb: payload[hlen : hlen+cbSz], codes: payload[hlen+cbSz:]})
 	}
 	// dedup codebooks by xxh3 hash
 	type entry struct { key uint64; data []byte; id int; d, m, k int }
@@ -276,20 +349,18 @@ func rewriteRShardsWithSharedCodebooks(shards [][]byte) (rewritten [][]byte, cod
 	for _, ri := range rInfos {
 		key := xxh3.Hash(ri.cb)
 		found := -1
-		for j, e := range pool { if e.key == key && bytes.Equal(e.data, ri.cb) { found = j; break } }
+		for j, e := range pool {
+			if e.key == key && e.d == ri.d && e.m == ri.m && e.k == ri.k && bytes.Equal(e.data, ri.cb) { found = j; break }
+		}
 		if found < 0 {
 			pool = append(pool, entry{key: key, data: append([]byte(nil), ri.cb...), id: len(pool), d: ri.d, m: ri.m, k: ri.k})
 			found = len(pool)-1
 		}
 		// rebuild R payload without codebook, add codebook_id
 		pb := new(bytes.Buffer)
-		// header rows, cols, d, m, k, n
-		binary.Write(pb, binary.LittleEndian, uint32(ri.rows))
-		binary.Write(pb, binary.LittleEndian, uint32(ri.cols))
-		binary.Write(pb, binary.LittleEndian, uint16(ri.d))
-		binary.Write(pb, binary.LittleEndian, uint16(ri.m))
-		binary.Write(pb, binary.LittleEndian, uint16(ri.k))
-		binary.Write(pb, binary.LittleEndian, uint32(ri.n))
+		hdr := append([]byte(nil), ri.hdr...)
+		if shards[ri.idx][0] == convert.TypeRRow { hdr[15] = hdr[15]&^convert.RFlagCBFP16 | convert.RFlagSharedCB }
+		pb.Write(hdr)
 		// add codebook_id
 		binary.Write(pb, binary.LittleEndian, uint16(found))
 		// append codes only
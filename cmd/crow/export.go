@@ -7,9 +7,10 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/qrv0/crow/internal/cawsf"
-	"github.com/qrv0/crow/internal/fileformat"
 )
 
 // Export CAWSF -> dense .f32 blobs per-layer (row-major).
@@ -18,22 +19,29 @@ func cmdExport() {
 	inPath := fs.String("in", "", "input .cawsf")
 	outDir := fs.String("out", "", "output dir (.f32 blobs)")
 	scope := fs.Int("scope", -1, "export only this scope (optional)")
+	rowSpec := fs.String("rows", "", "with --scope: export only rows a:b (half-open), decoding just those")
 	fs.Parse(os.Args[2:])
-	if *inPath == "" || *outDir == "" { fmt.Println("usage: crow export --in file.cawsf --out dir [--scope N]"); os.Exit(1) }
-	r, err := fileformat.OpenCAWSF(*inPath)
-	if err != nil { fmt.Fprintf(os.Stderr, "export: open error: %v\n", err); os.Exit(1) }
-	defer r.Close()
-	bank, err := r.SectionUncompressed(fileformat.TypeShardBank)
-	if err != nil { fmt.Fprintf(os.Stderr, "export: read shard bank error: %v\n", err); os.Exit(1) }
-    codebooks, _ := r.SectionUncompressed(fileformat.TypeCodebooks)
-	pool, _ := cawsf.ParseCodebookPool(codebooks)
+	if *inPath == "" || *outDir == "" || (*rowSpec != "" && *scope < 0) { fmt.Println("usage: crow export --in file.cawsf --out dir [--scope N [--rows a:b]]"); os.Exit(1) }
+	m, err := cawsf.OpenModel(*inPath, nil)
+	if err != nil { fmt.Fprintf(os.Stderr, "export: %v\n", err); os.Exit(1) }
 	if err := os.MkdirAll(*outDir, 0o755); err != nil { fmt.Fprintf(os.Stderr, "export: mkdir error: %v\n", err); os.Exit(1) }
-	idx, _ := cawsf.IndexShardBank(bank)
-	scopes := map[uint16]struct{}{}
-	for _, rec := range idx.Records { scopes[rec.Hdr.Scope] = struct{}{} }
-	for sc := range scopes {
-		if *scope >= 0 && int(sc) != *scope { continue }
-		rows, cols, data, err := cawsf.ReconstructForScopeWithPool(bank, pool, sc)
+	scopes := m.Scopes()
+	if *scope >= 0 {
+		if _, ok := m.Scope(uint16(*scope)); !ok { fmt.Fprintf(os.Stderr, "export: scope %d not found\n", *scope); os.Exit(1) }
+		scopes = []uint16{uint16(*scope)}
+	}
+	if *rowSpec != "" {
+		r0, r1, err := parseRowRange(*rowSpec)
+		if err != nil { fmt.Fprintf(os.Stderr, "export: %v\n", err); os.Exit(1) }
+		cols, data, err := m.ReconstructRange(uint16(*scope), r0, r1)
+		if err != nil { fmt.Fprintf(os.Stderr, "export: %v\n", err); os.Exit(1) }
+		out := filepath.Join(*outDir, fmt.Sprintf("scope_%d_rows_%d-%d_%dx%d.f32", *scope, r0, r1, r1-r0, cols))
+		if err := os.WriteFile(out, f32ToBytes(data), 0o644); err != nil { fmt.Fprintf(os.Stderr, "export: write %s error: %v\n", out, err); os.Exit(1) }
+		fmt.Println("wrote", out)
+		return
+	}
+	for _, sc := range scopes {
+		rows, cols, data, err := m.Reconstruct(sc)
 		if err != nil { fmt.Println("scope", sc, "error:", err); continue }
 		out := filepath.Join(*outDir, fmt.Sprintf("scope_%d_%dx%d.f32", sc, rows, cols))
 		if err := os.WriteFile(out, f32ToBytes(data), 0o644); err != nil { fmt.Fprintf(os.Stderr, "export: write %s error: %v\n", out, err); os.Exit(1) }
@@ -41,6 +49,15 @@ func cmdExport() {
 	}
 }
 
+// parseRowRange parses "a:b" into the half-open row range [a, b).
+func parseRowRange(s string) (int, int, error) {
+	a, b, ok := strings.Cut(s, ":")
+	r0, err0 := strconv.Atoi(a)
+	r1, err1 := strconv.Atoi(b)
+	if !ok || err0 != nil || err1 != nil || r0 < 0 || r1 < r0 { return 0, 0, fmt.Errorf("bad --rows %q, want a:b with 0 <= a <= b", s) }
+	return r0, r1, nil
+}
+
 func f32ToBytes(a []float32) []byte {
 	b := make([]byte, 4*len(a))
 	for i, v := range a {
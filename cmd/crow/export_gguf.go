@@ -1,12 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
-	"sort"
 	"strings"
 
 	"github.com/qrv0/crow/internal/cawsf"
@@ -26,27 +25,35 @@ func cmdExportGGUF() {
 		fmt.Println("usage: crow export-gguf --in model.cawsf --out model.gguf [--family crow-generic]")
 		os.Exit(1)
 	}
-	r, err := fileformat.OpenCAWSF(*inPath)
+	m, err := cawsf.OpenModel(*inPath, nil)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "export-gguf: open error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "export-gguf: %v\n", err)
 		os.Exit(1)
 	}
-	defer r.Close()
-	metaBytes, _ := r.SectionUncompressed(fileformat.TypeMeta)
-	var meta map[string]any
-	json.Unmarshal(metaBytes, &meta)
-	bank, err := r.SectionUncompressed(fileformat.TypeShardBank)
+	f, err := os.Create(*outPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "export-gguf: read shard bank error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "export-gguf: create %s error: %v\n", *outPath, err)
 		os.Exit(1)
 	}
-	codebooks, _ := r.SectionUncompressed(fileformat.TypeCodebooks)
-	pool, _ := cawsf.ParseCodebookPool(codebooks)
-	idx, _ := cawsf.IndexShardBank(bank)
-	scopes := map[uint16]struct{}{}
-	for _, rec := range idx.Records {
-		scopes[rec.Hdr.Scope] = struct{}{}
+	defer f.Close()
+	// a scope that fails to reconstruct is left out of the file
+	skip := func(sc uint16, err error) error {
+		fmt.Fprintln(os.Stderr, "export-gguf: skipping", err)
+		return nil
 	}
+	if err := exportGGUF(m, *family, f, skip, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "export-gguf: write error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Exported:", *outPath)
+}
+
+// exportGGUF writes m as a GGUF with f32 tensors. A scope that fails to
+// reconstruct is passed to onError, which may return nil to leave it out;
+// with a nil onError the export fails. progress, if set, is called after
+// each scope is reconstructed.
+func exportGGUF(m *cawsf.Model, family string, out io.Writer, onError func(scope uint16, err error) error, progress func(done, total int)) error {
+	meta := m.Meta
 	// Build GGUF
 	gw := fileformat.NewGGUFWriter()
 	// Minimal metadata
@@ -57,7 +64,7 @@ func cmdExportGGUF() {
 	gw.AddKV(fileformat.GGUFKV{Key: "general.name", Type: fileformat.GGUFTypeString, Value: modelName})
 	gw.AddKV(fileformat.GGUFKV{Key: "general.file_type", Type: fileformat.GGUFTypeUint32, Value: uint32(0)})
 	// architecture mapping
-	arch := *family
+	arch := family
 	if hf, ok := meta["hf_config"].(map[string]any); ok {
 		if mt, ok := hf["model_type"].(string); ok {
 			switch mt {
@@ -109,26 +116,17 @@ func cmdExportGGUF() {
 		addGGUFArchMeta(arch, hf, gw)
 	}
 	// Serialize tensors ordered by scope id for stability with canonical names when possible
-	type pair struct {
-		sc   uint16
-		name string
-	}
-	var order []pair
-	for sc := range scopes {
-		order = append(order, pair{sc: sc, name: lookupTensorName(meta, int(sc))})
-	}
-	sort.Slice(order, func(i, j int) bool { return order[i].sc < order[j].sc })
-	for _, p := range order {
-		rows, cols, data, err := cawsf.ReconstructForScopeWithPool(bank, pool, p.sc)
+	scopes := m.Scopes()
+	for i, sc := range scopes {
+		if progress != nil { progress(i, len(scopes)) }
+		rows, cols, data, err := m.Reconstruct(sc)
 		if err != nil {
-			fmt.Println("scope", p.sc, "error:", err)
+			err = fmt.Errorf("scope %d: %w", sc, err)
+			if onError == nil { return err }
+			if err := onError(sc, err); err != nil { return err }
 			continue
 		}
-		name := p.name
-		if name == "" {
-			name = fmt.Sprintf("scope_%d", p.sc)
-		}
-		name = canonicalTensorName(arch, name)
+		name := canonicalTensorName(arch, m.Name(sc))
 		if arch == "qwen2" {
 			// Qwen2 usa weight col-major em alguns leitores; se necessário, transpor
 			// Por segurança, preservamos row-major aqui; leitores devem lidar com ggml dims/ordem.
@@ -140,18 +138,8 @@ func cmdExportGGUF() {
 			Data: f32ToBytes(data),
 		})
 	}
-	// Write GGUF to file
-	f, err := os.Create(*outPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "export-gguf: create %s error: %v\n", *outPath, err)
-		os.Exit(1)
-	}
-	defer f.Close()
-	if err := gw.Write(f); err != nil {
-		fmt.Fprintf(os.Stderr, "export-gguf: write error: %v\n", err)
-		os.Exit(1)
-	}
-	fmt.Println("Exported:", *outPath)
+	if progress != nil { progress(len(scopes), len(scopes)) }
+	return gw.Write(out)
 }
 
 // keep a simple sanitization helper if names need normalization in future
@@ -159,23 +147,6 @@ func sanitizeName(s string) string {
 	return strings.ReplaceAll(strings.ReplaceAll(s, "/", "_"), ".", "_")
 }
 
-func lookupTensorName(meta map[string]any, scope int) string {
-	layers, ok := meta["layers"].([]any)
-	if !ok {
-		return fmt.Sprintf("scope_%d", scope)
-	}
-	for _, v := range layers {
-		m, _ := v.(map[string]any)
-		sid, _ := m["scope_id"].(float64)
-		if int(sid) == scope {
-			if n, ok := m["name"].(string); ok {
-				return n
-			}
-		}
-	}
-	return fmt.Sprintf("scope_%d", scope)
-}
-
 // expose gguf type id for string without importing constants here
 func ggufTypeString() uint32  { return 8 }
 func ggufTypeUint32() uint32  { return 4 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qrv0/crow/internal/cawsf"
+	"github.com/qrv0/crow/internal/infer"
+	"github.com/qrv0/crow/internal/sampling"
+	"github.com/qrv0/crow/internal/tokenizer"
+)
+
+// cmdGenerate runs the native engine on a .cawsf, extending a prompt given
+// as token ids, or as text when the file stores a tokenizer. Decoding is
+// greedy unless a temperature is set.
+func cmdGenerate() {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	in := fs.String("in", "", "input .cawsf (converted with its config.json alongside)")
+	tokens := fs.String("tokens", "", "prompt token ids, comma separated")
+	promptText := fs.String("prompt", "", "prompt text, encoded with the file's tokenizer")
+	n := fs.Int("n", 16, "tokens to generate")
+	cacheMB := fs.Int("cache-mb", 0, "keep decoded shards between steps in a cache of this many MiB (0 = decode every step)")
+	threads := fs.Int("threads", 0, "kernel worker goroutines (0 = GOMAXPROCS)")
+	prefetch := fs.Int("prefetch", 0, "decode the next N layers' shards in the background (needs --cache-mb)")
+	prefetchWorkers := fs.Int("prefetch-workers", 2, "background decode goroutines for --prefetch")
+	sampleOpts := samplingFlags(fs, sampling.Options{MirostatTau: 5, MirostatEta: 0.1})
+	fs.Parse(os.Args[2:])
+	if *in == "" || (*tokens == "") == (*promptText == "") {
+		fmt.Println("usage: crow generate --in model.cawsf (--tokens 1,2,3 | --prompt TEXT) [--n 16] [--cache-mb 0] [--prefetch 0] [--threads 0] [--temperature 0] [--top-k 0] [--seed 0] ...")
+		os.Exit(1)
+	}
+	opts, err := sampleOpts()
+	if err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+	if *prefetch > 0 && *cacheMB <= 0 { fmt.Fprintln(os.Stderr, "generate: --prefetch needs --cache-mb"); os.Exit(1) }
+	cawsf.SetWorkers(*threads)
+	var cache *cawsf.ShardCache
+	if *cacheMB > 0 { cache = cawsf.NewShardCache(int64(*cacheMB)<<20, nil) }
+	m, err := cawsf.OpenModel(*in, cache)
+	if err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+	var tk *tokenizer.Tokenizer
+	if m.TokenizerJSON != nil {
+		if tk, err = tokenizer.Parse(m.TokenizerJSON); err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+	}
+	var prompt []int
+	if *promptText != "" {
+		if tk == nil { fmt.Fprintf(os.Stderr, "generate: %s has no tokenizer; pass --tokens\n", *in); os.Exit(1) }
+		prompt = tk.Encode(*promptText, true)
+	} else if prompt, err = parseIDs(*tokens); err != nil {
+		fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1)
+	}
+	e, err := infer.New(m)
+	if err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+	var pf *cawsf.Prefetcher
+	if *prefetch > 0 {
+		if pf, err = cawsf.NewPrefetcher(context.Background(), m.Runtime, *prefetchWorkers); err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+		defer pf.Close()
+		e.Prefetch(pf, *prefetch)
+	}
+	start := time.Now()
+	logits, err := e.Forward(prompt)
+	if err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+	prefill := time.Since(start)
+	start = time.Now()
+	sampler := opts.Chain()
+	for _, id := range prompt { sampler.Accept(id) }
+	var out []int
+	for i := 0; i < *n; i++ {
+		next, err := sampler.Sample(logits)
+		if err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+		out = append(out, next)
+		if i == *n-1 { break }
+		if logits, err = e.Forward([]int{next}); err != nil { fmt.Fprintf(os.Stderr, "generate: %v\n", err); os.Exit(1) }
+	}
+	if tk != nil {
+		fmt.Println(tk.Decode(out, true))
+	} else {
+		s := make([]string, len(out))
+		for i, id := range out { s[i] = strconv.Itoa(id) }
+		fmt.Println(strings.Join(s, ","))
+	}
+	fmt.Fprintf(os.Stderr, "prefill %d tokens in %v, %d tokens in %v\n", len(prompt), prefill, *n, time.Since(start))
+	if pf != nil { printPrefetchStats(os.Stderr, pf, cache) }
+}
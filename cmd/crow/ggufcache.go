@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qrv0/crow/internal/cawsf"
+	"github.com/qrv0/crow/internal/fileformat"
+	xxh3 "github.com/zeebo/xxh3"
+)
+
+// ggufCacheVersion is part of every cache key; bump it when exportGGUF's
+// output changes so older entries stop matching.
+const ggufCacheVersion = 1
+
+var cacheDir = filepath.Join(crowHome, "cache")
+
+// ggufCacheKey identifies what exportGGUF would produce from the .cawsf at
+// path: a 128-bit xxh3 over the cache version, the family, META and, for
+// every other section, the chunk hashes convert recorded in
+// META.checksum_index, so a multi-GB bank is not read again just to find
+// its key. Sections without an index entry fall back to hashing their
+// stored bytes. Renaming or copying the file keeps the key.
+func ggufCacheKey(path, family string) (string, error) {
+	r, err := fileformat.OpenCAWSF(path)
+	if err != nil { return "", err }
+	defer r.Close()
+	var idx map[string]any
+	metaBytes, err := r.SectionUncompressed(fileformat.TypeMeta)
+	if err == nil {
+		var meta map[string]any
+		if json.Unmarshal(metaBytes, &meta) == nil { idx, _ = meta["checksum_index"].(map[string]any) }
+	}
+	h := xxh3.New()
+	fmt.Fprintf(h, "crow-gguf/%d/%s\x00", ggufCacheVersion, family)
+	for i, e := range r.TOC {
+		var rec [16]byte
+		binary.LittleEndian.PutUint32(rec[0:], e.TypeID)
+		if m, ok := idx[fmt.Sprint(e.TypeID)].(map[string]any); ok && e.TypeID != fileformat.TypeMeta {
+			chunk, _ := m["chunk_size"].(float64)
+			if hashes := parseHashes(m); chunk > 0 && hashes != nil {
+				// no TOC flags look like this, so it cannot match the fallback
+				binary.LittleEndian.PutUint32(rec[4:], ^uint32(0))
+				binary.LittleEndian.PutUint64(rec[8:], uint64(chunk))
+				h.Write(rec[:])
+				for _, x := range hashes { binary.Write(h, binary.LittleEndian, x) }
+				continue
+			}
+		}
+		sec := xxh3.New()
+		if _, err := io.Copy(sec, r.SectionReader(i)); err != nil { return "", fmt.Errorf("hash section %d: %w", e.TypeID, err) }
+		sum := sec.Sum128()
+		binary.LittleEndian.PutUint32(rec[4:], e.Flags)
+		binary.LittleEndian.PutUint64(rec[8:], e.Size)
+		h.Write(rec[:])
+		binary.Write(h, binary.LittleEndian, [2]uint64{sum.Hi, sum.Lo})
+	}
+	sum := h.Sum128()
+	return fmt.Sprintf("%016x%016x", sum.Hi, sum.Lo), nil
+}
+
+// materializeGGUF returns a GGUF export of the .cawsf at path from the
+// cache under dir, exporting it first if needed, then trims the cache to
+// limit bytes (0 = no limit). Reuse bumps the entry's mtime, which is what
+// eviction orders by.
+func materializeGGUF(path, family, dir string, limit int64, progress io.Writer) (string, error) {
+	key, err := ggufCacheKey(path, family)
+	if err != nil { return "", err }
+	out := filepath.Join(dir, key+".gguf")
+	if _, err := os.Stat(out); err == nil {
+		now := time.Now()
+		os.Chtimes(out, now, now)
+		return out, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil { return "", err }
+	m, err := cawsf.OpenModel(path, nil)
+	if err != nil { return "", err }
+	tmp, err := os.CreateTemp(dir, key+".*.tmp")
+	if err != nil { return "", err }
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	name := filepath.Base(path)
+	err = exportGGUF(m, family, tmp, nil, func(done, total int) {
+		if progress != nil { fmt.Fprintf(progress, "\rmaterializing %s: %d/%d tensors", name, done, total) }
+	})
+	if progress != nil { fmt.Fprintln(progress) }
+	if cerr := tmp.Close(); err == nil { err = cerr }
+	if err == nil { err = os.Chmod(tmp.Name(), 0o644) }
+	if err != nil { return "", fmt.Errorf("materialize %s: %w", path, err) }
+	// rename last, so an interrupted export never looks like a cache hit
+	if err := os.Rename(tmp.Name(), out); err != nil { return "", err }
+	if err := evictGGUFCache(dir, limit, out); err != nil { return "", err }
+	return out, nil
+}
+
+// evictGGUFCache deletes the least recently used .gguf entries in dir
+// until they total at most limit bytes; keep is never deleted.
+func evictGGUFCache(dir string, limit int64, keep string) error {
+	if limit <= 0 { return nil }
+	ents, err := os.ReadDir(dir)
+	if err != nil { return err }
+	var files []os.FileInfo
+	total := int64(0)
+	for _, e := range ents {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".gguf") { continue }
+		fi, err := e.Info()
+		if err != nil { continue }
+		files = append(files, fi)
+		total += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, fi := range files {
+		if total <= limit { break }
+		p := filepath.Join(dir, fi.Name())
+		if p == keep { continue }
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) { return err }
+		total -= fi.Size()
+	}
+	return nil
+}
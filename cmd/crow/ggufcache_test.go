@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qrv0/crow/internal/convert"
+	"github.com/qrv0/crow/internal/fileformat"
+)
+
+// writeRawModel writes a .cawsf with n raw 4x8 scopes whose values depend on seed.
+func writeRawModel(t *testing.T, path string, n int, seed float32) {
+	t.Helper()
+	var bank []byte
+	var layers []map[string]any
+	for sc := 0; sc < n; sc++ {
+		data := make([]float32, 4*8)
+		for i := range data { data[i] = seed + float32(sc*len(data)+i)/64 }
+		spec := convert.LayerSpec{Name: "w" + string(rune('a'+sc)), Rows: 4, Cols: 8, Data: data, Scope: uint16(sc)}
+		res, err := convert.ConvertLayerWithMeta(spec, convert.Config{StoreRaw: true})
+		if err != nil { t.Fatal(err) }
+		for _, s := range res.Shards { bank = append(bank, packShard(s.Type, s.Scope, s.Data)...) }
+		layers = append(layers, map[string]any{"scope_id": sc, "name": spec.Name, "shape": []int{4, 8}})
+	}
+	meta, _ := json.Marshal(map[string]any{"layers": layers})
+	w := fileformat.NewWriter()
+	w.AddSection(fileformat.TypeMeta, meta, 0)
+	w.AddSection(fileformat.TypeShardBank, bank, fileformat.FlagCompLZ4)
+	if err := w.Write(path); err != nil { t.Fatal(err) }
+}
+
+func TestMaterializeGGUF(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	a := filepath.Join(dir, "a.cawsf")
+	writeRawModel(t, a, 2, 0)
+
+	var progress bytes.Buffer
+	out, err := materializeGGUF(a, "crow-generic", cache, 0, &progress)
+	if err != nil { t.Fatal(err) }
+	if !strings.Contains(progress.String(), "2/2 tensors") { t.Errorf("progress %q", progress.String()) }
+	if info, err := fileformat.InspectGGUF(out); err != nil || string(info.Magic[:]) != "GGUF" { t.Fatalf("not a GGUF: %v", err) }
+	first, _ := os.ReadFile(out)
+
+	// a copy under another name hits the same entry without exporting
+	b := filepath.Join(dir, "b.cawsf")
+	raw, _ := os.ReadFile(a)
+	os.WriteFile(b, raw, 0o644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(out, old, old)
+	progress.Reset()
+	again, err := materializeGGUF(b, "crow-generic", cache, 0, &progress)
+	if err != nil { t.Fatal(err) }
+	if again != out || progress.Len() != 0 { t.Fatalf("copy was re-exported: %s vs %s, progress %q", again, out, progress.String()) }
+	if fi, _ := os.Stat(out); !fi.ModTime().After(old) { t.Errorf("cache hit did not bump mtime") }
+	if second, _ := os.ReadFile(out); !bytes.Equal(first, second) { t.Errorf("entry rewritten") }
+
+	// other weights or another family are other entries
+	c := filepath.Join(dir, "c.cawsf")
+	writeRawModel(t, c, 2, 1)
+	kc, _ := ggufCacheKey(c, "crow-generic")
+	ka, _ := ggufCacheKey(a, "crow-generic")
+	kf, _ := ggufCacheKey(a, "llama")
+	if kc == ka || kf == ka { t.Fatalf("keys collide: %s %s %s", ka, kc, kf) }
+
+	// with room for one entry, adding c evicts a's
+	outC, err := materializeGGUF(c, "crow-generic", cache, int64(len(first)), nil)
+	if err != nil { t.Fatal(err) }
+	if _, err := os.Stat(out); !os.IsNotExist(err) { t.Errorf("least recently used entry kept: %v", err) }
+	if _, err := os.Stat(outC); err != nil { t.Errorf("new entry evicted: %v", err) }
+	if ents, _ := os.ReadDir(cache); len(ents) != 1 { t.Errorf("cache holds %d files, want 1", len(ents)) }
+}
+
+func TestMaterializeGGUFFailureNotCached(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	// scope 1 holds two shards of different shapes, so it cannot be
+	// reconstructed
+	var bank []byte
+	for sc, rows := range []int{4, 4, 2} {
+		spec := convert.LayerSpec{Name: "w", Rows: rows, Cols: 8, Data: make([]float32, rows*8), Scope: uint16(min(sc, 1))}
+		res, err := convert.ConvertLayerWithMeta(spec, convert.Config{StoreRaw: true})
+		if err != nil { t.Fatal(err) }
+		for _, s := range res.Shards { bank = append(bank, packShard(s.Type, s.Scope, s.Data)...) }
+	}
+	w := fileformat.NewWriter()
+	w.AddSection(fileformat.TypeShardBank, bank, fileformat.FlagCompLZ4)
+	path := filepath.Join(dir, "bad.cawsf")
+	if err := w.Write(path); err != nil { t.Fatal(err) }
+	if _, err := materializeGGUF(path, "crow-generic", cache, 0, nil); err == nil || !strings.Contains(err.Error(), "scope 1") { t.Fatalf("materialize = %v, want a scope 1 error", err) }
+	if ents, _ := os.ReadDir(cache); len(ents) != 0 { t.Fatalf("cache holds %d files after a failed export", len(ents)) }
+}
+
+func TestGGUFCacheKeyChecksumIndex(t *testing.T) {
+	dir := t.TempDir()
+	bank := make([]byte, 64)
+	write := func(name string, bank []byte, idx map[string]any) string {
+		meta, _ := json.Marshal(map[string]any{"checksum_index": idx})
+		w := fileformat.NewWriter()
+		w.AddSection(fileformat.TypeMeta, meta, 0)
+		w.AddSection(fileformat.TypeShardBank, bank, 0)
+		path := filepath.Join(dir, name)
+		if err := w.Write(path); err != nil { t.Fatal(err) }
+		return path
+	}
+	sec := fmt.Sprint(fileformat.TypeShardBank)
+	idx := map[string]any{sec: rollingXXH3Index(bank, 16)}
+	a := write("a.cawsf", bank, idx)
+	// the recorded hashes stand in for the bank, which is not read
+	other := append([]byte(nil), bank...)
+	other[0] = 1
+	b := write("b.cawsf", other, idx)
+	c := write("c.cawsf", other, map[string]any{sec: rollingXXH3Index(other, 16)})
+	// without an entry the bank itself is hashed
+	d := write("d.cawsf", other, map[string]any{})
+	e := write("e.cawsf", bank, map[string]any{})
+	key := func(path string) string {
+		k, err := ggufCacheKey(path, "crow-generic")
+		if err != nil { t.Fatal(err) }
+		return k
+	}
+	if key(a) != key(b) { t.Errorf("same checksum_index, different keys") }
+	if key(a) == key(c) { t.Errorf("different checksum_index, same key") }
+	if key(d) == key(e) { t.Errorf("unindexed banks differ, same key") }
+}
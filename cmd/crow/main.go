@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 
 	"github.com/qrv0/crow/internal/downloader"
+	"github.com/qrv0/crow/internal/runner"
+	"github.com/qrv0/crow/internal/sampling"
 )
 
 func main() {
@@ -38,6 +40,12 @@ func main() {
 		cmdApply()
 	case "verify":
 		cmdVerify()
+	case "quant":
+		cmdQuant()
+	case "generate":
+		cmdGenerate()
+	case "tokenize":
+		cmdTokenize()
 	default:
 		usage()
 		os.Exit(1)
@@ -51,12 +59,15 @@ func usage() {
 	fmt.Println("  list                        list models in ~/.crow/models")
 	fmt.Println("  pull  <url>                 download model file to ~/.crow/models")
     fmt.Println("  inspect <file.{cawsf,gguf}> inspect model file")
-    fmt.Println("  run    <file.gguf> [-p prompt] [--ctx 4096] [--gpu-layers N]")
+    fmt.Println("  run    <file.{gguf,cawsf}> [-p prompt] [--ctx 4096] [--gpu-layers N]")
     fmt.Println("  route  --in <file.cawsf> -p 'prompt' [--k 8] [--budget X]")
-    fmt.Println("  apply  --in <file.cawsf> --scope N --xlen COLS")
-    fmt.Println("  export --in <file.cawsf> --out <dir>            export reconstructed f32 blobs per scope")
+    fmt.Println("  apply  --in <file.cawsf> (--scope N | --name TENSOR) --xlen COLS")
+    fmt.Println("  export --in <file.cawsf> --out <dir> [--scope N [--rows a:b]]  export reconstructed f32 blobs per scope")
     fmt.Println("  export-gguf --in <file.cawsf> --out <file.gguf> export GGUF with f32 tensors")
     fmt.Println("  verify --in <file.cawsf>              verify checksums")
+    fmt.Println("  generate --in <file.cawsf> (--tokens 1,2,3 | --prompt TEXT) [--n 16] [--prefetch N]  run the native engine, greedy")
+    fmt.Println("  tokenize --in <file.cawsf|tokenizer.json> (--text TEXT | --ids 1,2,3)  encode or decode")
+    fmt.Println("  quant  train|encode|eval --model <f.safetensors> --tensor NAME  tune PQ on one tensor")
 }
 
 var (
@@ -131,20 +142,27 @@ func cmdRun() {
 	prompt := fs.String("p", "Hello from crow", "prompt")
 	ctxSize := fs.Int("ctx", 4096, "context size")
 	gpuLayers := fs.Int("gpu-layers", 0, "GPU layers (llama.cpp)")
-	temp := fs.Float64("temperature", 0.8, "sampling temperature")
-	topk := fs.Int("top-k", 50, "top-k")
-	topp := fs.Float64("top-p", 0.95, "top-p")
-	repeat := fs.Float64("repeat-penalty", 1.1, "repeat penalty")
+	family := fs.String("family", "crow-generic", ".cawsf: model family tag for the materialized GGUF")
+	cacheGB := fs.Float64("cache-max-gb", 20, ".cawsf: size limit of the GGUF cache in ~/.crow/cache (0 = unlimited)")
+	sampleOpts := samplingFlags(fs, sampling.Options{Temperature: 0.8, TopK: 50, TopP: 0.95, RepeatPenalty: 1.1, MirostatTau: 5, MirostatEta: 0.1})
 	fs.Parse(os.Args[2:])
 	if fs.NArg() < 1 {
-		fmt.Println("usage: crow run <file.gguf> [-p prompt] [--ctx 4096] [--gpu-layers N] [--temperature 0.8] [--top-k 50] [--top-p 0.95] [--repeat-penalty 1.1]")
+		fmt.Println("usage: crow run <file.gguf|file.cawsf> [-p prompt] [--ctx 4096] [--gpu-layers N] [--cache-max-gb 20] [--temperature 0.8] [--top-k 50] [--top-p 0.95] [--repeat-penalty 1.1] [--seed N] ...")
 		os.Exit(1)
 	}
+	opts, err := sampleOpts()
+	if err != nil { log.Fatal(err) }
 	modelPath := fs.Arg(0)
-	if filepath.Ext(modelPath) != ".gguf" {
-		log.Fatal("run currently supports only GGUF; use inspect/convert for CAWSF")
+	switch filepath.Ext(modelPath) {
+	case ".gguf":
+	case ".cawsf":
+		// llama.cpp reads GGUF: export once into the cache, reuse afterwards
+		if !runner.Available() { log.Fatal("run: llama support not built. Rebuild with -tags llama.") }
+		if modelPath, err = materializeGGUF(modelPath, *family, cacheDir, int64(*cacheGB*(1<<30)), os.Stderr); err != nil { log.Fatal(err) }
+	default:
+		log.Fatal("run supports .gguf and .cawsf files")
 	}
-	if err := runGGUFWithSampling(modelPath, *prompt, *ctxSize, *gpuLayers, *temp, *topk, *topp, *repeat); err != nil {
+	if err := runGGUFWithSampling(modelPath, *prompt, *ctxSize, *gpuLayers, opts); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/qrv0/crow/internal/convert"
+	"github.com/qrv0/crow/internal/safetensors"
+)
+
+// planConvert prints the expected shard sizes and compute cost of a conversion
+// using only the safetensors header. Layer selection mirrors cmdConvert.
+func planConvert(inPath string, pol *convert.Policy, base convert.Config, maxLayers, maxElems int, gflops float64) error {
+	hdr, err := safetensors.ReadHeader(inPath)
+	if err != nil { return err }
+	names := make([]string, 0, len(hdr))
+	for name := range hdr { names = append(names, name) }
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "tensor\tshape\tcodec\tL\tD\tR codes\tcodebook\tS\ttotal\tsource\test time\t")
+	var plans []convert.LayerPlan
+	var srcBytes, outBytes int64
+	var flops float64
+	shards := 0
+	processed := 0
+	seenGroups := map[string]bool{}
+	for _, name := range names {
+		tm := hdr[name]
+		rows, cols, ok := tensorMatrix(tm.Shape)
+		if !ok { continue }
+		vector := len(tm.Shape) == 1
+		if !vector && maxElems > 0 && rows*cols > maxElems { continue }
+		if !vector && maxLayers > 0 && processed >= maxLayers { break }
+		dec := pol.Resolve(name, base)
+		if vector && !dec.Skip { dec.Config = convert.Config{StoreRaw: true} }
+		if dec.Skip {
+			fmt.Fprintf(tw, "%s\t%dx%d\tskip\t\t\t\t\t\t\t\t\t\n", name, rows, cols)
+			continue
+		}
+		p := convert.PlanLayer(name, rows, cols, dec.Config)
+		// group members after the first reuse its CODEBOOKS entry
+		if key := convert.GroupKey(name, rows, cols, dec.Config); key != "" {
+			if seenGroups[key] { p.CodebookBytes = 0 }
+			seenGroups[key] = true
+		}
+		plans = append(plans, p)
+		codec, _ := dec.Config.Describe()["codec"].(string)
+		fmt.Fprintf(tw, "%s\t%dx%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name, rows, cols, codec,
+			humanBytes(p.LBytes), humanBytes(p.DBytes), humanBytes(p.RCodeBytes), humanBytes(p.CodebookBytes),
+			sCol(p), humanBytes(p.Total()), humanBytes(tm.Size()), humanSeconds(p.Flops()/(gflops*1e9)))
+		srcBytes += tm.Size()
+		outBytes += p.Total()
+		flops += p.Flops()
+		if dec.Config.StoreRaw { shards++ } else { shards += 4 }
+		if !vector { processed++ }
+	}
+	tw.Flush()
+	// ROUTING: dim, n, then per shard id, cost and a 64-dim key
+	routing := int64(6 + shards*(4+4+64*4))
+	outBytes += routing
+	fmt.Printf("\nlayers: %d  shards: %d  routing: %s\n", len(plans), shards, humanBytes(routing))
+	fmt.Printf("source: %s  output: %s (before section compression)\n", humanBytes(srcBytes), humanBytes(outBytes))
+	for _, p := range plans {
+		if p.SUnknown { fmt.Println("note: S sizes marked ? depend on the data (threshold strategy) and are not included"); break }
+	}
+	if outBytes > 0 {
+		fmt.Printf("compression ratio: %.2fx\n", float64(srcBytes)/float64(outBytes))
+	}
+	fmt.Printf("estimated compute: %.1f GFLOP, ~%s at %.1f GFLOP/s\n", flops/1e9, humanSeconds(flops/(gflops*1e9)), gflops)
+	return nil
+}
+
+func sCol(p convert.LayerPlan) string {
+	if p.SUnknown { return "?" }
+	return humanBytes(p.SBytes)
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit { return fmt.Sprintf("%dB", n) }
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func humanSeconds(s float64) string {
+	switch {
+	case s < 1:
+		return fmt.Sprintf("%.0fms", s*1000)
+	case s < 120:
+		return fmt.Sprintf("%.1fs", s)
+	case s < 7200:
+		return fmt.Sprintf("%.1fm", s/60)
+	default:
+		return fmt.Sprintf("%.1fh", s/3600)
+	}
+}
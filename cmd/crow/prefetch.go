@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/qrv0/crow/internal/cawsf"
+)
+
+// printPrefetchStats reports what pf decoded and how much of that decode
+// time callers of cache were spared.
+func printPrefetchStats(w io.Writer, pf *cawsf.Prefetcher, cache *cawsf.ShardCache) {
+	ps, cs := pf.Stats(), cache.Stats()
+	fmt.Fprintf(w, "prefetch: %d queued, %d decoded, %d skipped, %d failed, %d canceled, %v decoding\n", ps.Queued, ps.Decoded, ps.Skipped, ps.Failed, ps.Canceled, ps.Decode)
+	fmt.Fprintf(w, "prefetch: %d of %d used (%d waited %v), %d evicted unused, %v of decode hidden\n", cs.PrefetchHits, cs.Prefetched, cs.PrefetchWaits, cs.Waited, cs.PrefetchUnused, cs.Hidden)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/qrv0/crow/internal/convert"
+	"github.com/qrv0/crow/internal/fileformat"
+	"github.com/qrv0/crow/internal/quant"
+	"github.com/qrv0/crow/internal/safetensors"
+)
+
+// cmdQuant tunes PQ on single tensors without running a conversion:
+//
+//	train   fit one codebook per (m, k) and write them as a CODEBOOKS section
+//	encode  quantize a tensor with an existing codebook into an R payload
+//	eval    report the reconstruction error of existing codebooks on a tensor
+func cmdQuant() {
+	if len(os.Args) < 3 {
+		quantUsage()
+		os.Exit(1)
+	}
+	var err error
+	switch os.Args[2] {
+	case "train":
+		err = quantTrain(os.Args[3:])
+	case "encode":
+		err = quantEncode(os.Args[3:])
+	case "eval":
+		err = quantEval(os.Args[3:])
+	default:
+		quantUsage()
+		os.Exit(1)
+	}
+	if err != nil { fmt.Fprintf(os.Stderr, "quant: %v\n", err); os.Exit(1) }
+}
+
+func quantUsage() {
+	fmt.Println("usage: crow quant train  --model f.safetensors --tensor NAME [--m 8,16] [--k 256,1024] [--d 128] [--out cb.bin]")
+	fmt.Println("       crow quant encode --model f.safetensors --tensor NAME --codebooks cb.bin|model.cawsf [--id 0] --out r.bin")
+	fmt.Println("       crow quant eval   --model f.safetensors --tensor NAME --codebooks cb.bin|model.cawsf")
+}
+
+// quantSource is the tensor selection shared by the quant subcommands.
+type quantSource struct {
+	model, tensor *string
+	residue       *bool
+	rank          *int
+	outlierQ      *float64
+}
+
+func addQuantSource(fs *flag.FlagSet) quantSource {
+	return quantSource{
+		model:    fs.String("model", "", "path to .safetensors"),
+		tensor:   fs.String("tensor", "", "2D tensor name"),
+		residue:  fs.Bool("residue", false, "quantize the R left after removing L, D and S instead of the raw weights"),
+		rank:     fs.Int("rank", 64, "low-rank for --residue"),
+		outlierQ: fs.Float64("outlier-q", 0.999, "outlier quantile for --residue"),
+	}
+}
+
+// load returns the selected matrix, raw or as its NDSQ residue.
+func (s quantSource) load() (data []float32, rows, cols int, err error) {
+	if *s.model == "" || *s.tensor == "" { return nil, 0, 0, fmt.Errorf("--model and --tensor are required") }
+	st, err := safetensors.Open(*s.model)
+	if err != nil { return nil, 0, 0, err }
+	t, ok := st.Tensors[*s.tensor]
+	if !ok { return nil, 0, 0, fmt.Errorf("tensor %q not found", *s.tensor) }
+	if len(t.Meta.Shape) != 2 { return nil, 0, 0, fmt.Errorf("tensor %q is not 2D", *s.tensor) }
+	rows, cols = int(t.Meta.Shape[0]), int(t.Meta.Shape[1])
+	data = bytesToF32WithDtype(t.Data, t.Meta.Dtype, rows*cols)
+	if !*s.residue { return data, rows, cols, nil }
+	d, err := convert.Decompose(convert.LayerSpec{Name: *s.tensor, Rows: rows, Cols: cols, Data: data}, convert.Config{Rank: *s.rank, OutlierQuantile: *s.outlierQ})
+	if err != nil { return nil, 0, 0, err }
+	return d.Residue(), rows, cols, nil
+}
+
+func parseInts(s string) ([]int, error) {
+	var out []int
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || v <= 0 { return nil, fmt.Errorf("bad list value %q", f) }
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// variance is the per-element variance of blocks, to put MSE in scale.
+func variance(blocks [][]float32) float64 {
+	var s, s2 float64
+	n := 0
+	for _, b := range blocks {
+		for _, v := range b { s += float64(v); s2 += float64(v) * float64(v); n++ }
+	}
+	if n == 0 { return 0 }
+	mean := s / float64(n)
+	return s2/float64(n) - mean*mean
+}
+
+func quantTrain(args []string) error {
+	fs := flag.NewFlagSet("quant train", flag.ExitOnError)
+	src := addQuantSource(fs)
+	ms := fs.String("m", "8", "comma-separated sub-quantizer counts to try")
+	ks := fs.String("k", "256", "comma-separated codebook sizes to try")
+	d := fs.Int("d", 128, "block size; must be divisible by every m")
+	fp16 := fs.Bool("fp16", false, "store codebooks as fp16")
+	iters := fs.Int("iters", 25, "k-means iterations")
+	seed := fs.Int64("seed", 1234, "k-means seed")
+	out := fs.String("out", "", "write the trained codebooks as a CODEBOOKS section (ids in table order)")
+	fs.Parse(args)
+	mList, err := parseInts(*ms)
+	if err != nil { return err }
+	kList, err := parseInts(*ks)
+	if err != nil { return err }
+	data, rows, cols, err := src.load()
+	if err != nil { return err }
+	blocks := convert.RowBlocks(data, rows, cols, *d)
+	vr := variance(blocks)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "id\tm\tk\tbits/weight\tmse\trel mse\t")
+	var pqs []*quant.PQ
+	for _, m := range mList {
+		if *d%m != 0 { return fmt.Errorf("d=%d is not divisible by m=%d", *d, m) }
+		for _, k := range kList {
+			pq := quant.TrainPQWith(blocks, m, k, quant.KMeansOptions{Iters: *iters, Seed: *seed})
+			mse := pq.MSE(blocks)
+			rel := 0.0
+			if vr > 0 { rel = mse / vr }
+			bpw := float64(m*quant.CodeBits(pq.K)) / float64(*d)
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%.3f\t%.4g\t%.4f\t\n", len(pqs), m, pq.K, bpw, mse, rel)
+			pqs = append(pqs, pq)
+		}
+	}
+	tw.Flush()
+	if *out == "" { return nil }
+	if err := os.WriteFile(*out, quant.MarshalCodebooks(pqs, *fp16), 0o644); err != nil { return err }
+	fmt.Println("Wrote:", *out)
+	return nil
+}
+
+// loadCodebooks reads a standalone CODEBOOKS file or the CODEBOOKS section
+// of a .cawsf model.
+func loadCodebooks(path string) (map[uint16]*quant.PQ, error) {
+	if strings.HasSuffix(path, ".cawsf") {
+		r, err := fileformat.OpenCAWSF(path)
+		if err != nil { return nil, err }
+		defer r.Close()
+		b, err := r.SectionUncompressed(fileformat.TypeCodebooks)
+		if err != nil { return nil, err }
+		return quant.UnmarshalCodebooks(b)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	return quant.UnmarshalCodebooks(b)
+}
+
+func quantEncode(args []string) error {
+	fs := flag.NewFlagSet("quant encode", flag.ExitOnError)
+	src := addQuantSource(fs)
+	cbPath := fs.String("codebooks", "", "CODEBOOKS file from quant train, or a .cawsf model")
+	id := fs.Int("id", 0, "codebook entry id")
+	out := fs.String("out", "", "output R payload")
+	fs.Parse(args)
+	if *cbPath == "" || *out == "" { return fmt.Errorf("--codebooks and --out are required") }
+	pqs, err := loadCodebooks(*cbPath)
+	if err != nil { return err }
+	pq, ok := pqs[uint16(*id)]
+	if !ok { return fmt.Errorf("codebook id %d not found", *id) }
+	data, rows, cols, err := src.load()
+	if err != nil { return err }
+	d := pq.M * pq.Dsub
+	blocks := convert.RowBlocks(data, rows, cols, d)
+	bits := quant.CodeBits(pq.K)
+	// a row-aligned R payload (type 4) that references the codebook by id
+	p := binary.LittleEndian.AppendUint32(nil, uint32(rows))
+	p = binary.LittleEndian.AppendUint32(p, uint32(cols))
+	p = binary.LittleEndian.AppendUint16(p, uint16(d))
+	p = binary.LittleEndian.AppendUint16(p, uint16(pq.M))
+	p = binary.LittleEndian.AppendUint16(p, uint16(pq.K))
+	p = append(p, byte(bits), convert.RFlagSharedCB)
+	p = binary.LittleEndian.AppendUint16(p, uint16(*id))
+	p = append(p, quant.PackCodes(pq.Encode(blocks), bits)...)
+	if err := os.WriteFile(*out, p, 0o644); err != nil { return err }
+	fmt.Printf("Wrote: %s (%d blocks, %d-bit codes, mse %.4g)\n", *out, len(blocks), bits, pq.MSE(blocks))
+	return nil
+}
+
+func quantEval(args []string) error {
+	fs := flag.NewFlagSet("quant eval", flag.ExitOnError)
+	src := addQuantSource(fs)
+	cbPath := fs.String("codebooks", "", "CODEBOOKS file from quant train, or a .cawsf model")
+	fs.Parse(args)
+	if *cbPath == "" { return fmt.Errorf("--codebooks is required") }
+	pqs, err := loadCodebooks(*cbPath)
+	if err != nil { return err }
+	data, rows, cols, err := src.load()
+	if err != nil { return err }
+	ids := make([]int, 0, len(pqs))
+	for id := range pqs { ids = append(ids, int(id)) }
+	sort.Ints(ids)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "id\td\tm\tk\tmse\trel mse\t")
+	for _, id := range ids {
+		pq := pqs[uint16(id)]
+		blocks := convert.RowBlocks(data, rows, cols, pq.M*pq.Dsub)
+		mse := pq.MSE(blocks)
+		rel := 0.0
+		if vr := variance(blocks); vr > 0 { rel = mse / vr }
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%.4g\t%.4f\t\n", id, pq.M*pq.Dsub, pq.M, pq.K, mse, rel)
+	}
+	return tw.Flush()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"flag"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/qrv0/crow/internal/cawsf"
 	"github.com/qrv0/crow/internal/fileformat"
 	xxh3 "github.com/zeebo/xxh3"
 )
@@ -18,8 +20,9 @@ func cmdRoute() {
 	prompt := fs.String("p", "", "prompt text")
 	k := fs.Int("k", 8, "top-k shards to select")
 	budget := fs.Float64("budget", 0, "optional budget to respect (0 = ignore)")
+	prefetchMB := fs.Int("prefetch-mb", 0, "decode the selected shards into a cache of this many MiB in the background and report it")
 	fs.Parse(os.Args[2:])
-	if *in == "" || *prompt == "" { fmt.Println("usage: crow route --in model.cawsf -p 'prompt' [--k 8] [--budget 0]"); os.Exit(1) }
+	if *in == "" || *prompt == "" { fmt.Println("usage: crow route --in model.cawsf -p 'prompt' [--k 8] [--budget 0] [--prefetch-mb 0]"); os.Exit(1) }
 	r, err := fileformat.OpenCAWSF(*in)
 	if err != nil { fmt.Fprintf(os.Stderr, "route: open error: %v\n", err); os.Exit(1) }
 	defer r.Close()
@@ -42,6 +45,16 @@ func cmdRoute() {
 	for i, sid := range selected {
 		fmt.Printf("%2d: shard_id=%d cost=%.3f\n", i, sid, costs[order[i]])
 	}
+	if *prefetchMB > 0 {
+		cache := cawsf.NewShardCache(int64(*prefetchMB)<<20, nil)
+		m, err := cawsf.OpenModel(*in, cache)
+		if err != nil { fmt.Fprintf(os.Stderr, "route: %v\n", err); os.Exit(1) }
+		pf, err := cawsf.NewPrefetcher(context.Background(), m.Runtime, 0)
+		if err != nil { fmt.Fprintf(os.Stderr, "route: %v\n", err); os.Exit(1) }
+		if err := pf.Shards(selected...); err != nil { fmt.Fprintf(os.Stderr, "route: %v\n", err); os.Exit(1) }
+		if err := pf.Wait(); err != nil { fmt.Fprintf(os.Stderr, "route: %v\n", err); os.Exit(1) }
+		printPrefetchStats(os.Stdout, pf, cache)
+	}
 }
 
 func parseRouting(data []byte) (dim int, n int, shardIDs []uint32, costs []float32, keys [][]float32, err error) {
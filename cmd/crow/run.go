@@ -28,7 +28,7 @@ func runGGUF(path, prompt string, ctx, gpuLayers int) error {
 	return nil
 }
 
-func runGGUFWithSampling(path, prompt string, ctx, gpuLayers int, temperature float64, topk int, topp float64, repeatPenalty float64) error {
+func runGGUFWithSampling(path, prompt string, ctx, gpuLayers int, opts runner.SampleOptions) error {
 	r, err := runner.New(path, runner.RunOptions{CtxSize: ctx, GPULayers: gpuLayers})
 	if err != nil {
 		if strings.Contains(err.Error(), "llama runner unavailable") {
@@ -36,7 +36,6 @@ func runGGUFWithSampling(path, prompt string, ctx, gpuLayers int, temperature fl
 		}
 		return err
 	}
-	opts := runner.SampleOptions{Temperature: temperature, TopK: topk, TopP: topp, RepeatPenalty: repeatPenalty}
 	resp, err := r.Generate(prompt, opts)
 	if err != nil {
 		if strings.Contains(err.Error(), "llama runner unavailable") {
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/qrv0/crow/internal/sampling"
+)
+
+// samplingFlags registers the sampler flags on fs with d as defaults; the
+// returned function reads them back after fs.Parse.
+func samplingFlags(fs *flag.FlagSet, d sampling.Options) func() (sampling.Options, error) {
+	o := d
+	fs.Float64Var(&o.Temperature, "temperature", d.Temperature, "sampling temperature (0 = greedy)")
+	fs.IntVar(&o.TopK, "top-k", d.TopK, "keep the k most likely tokens (0 = off)")
+	fs.Float64Var(&o.TopP, "top-p", d.TopP, "nucleus sampling threshold (1 = off)")
+	fs.Float64Var(&o.MinP, "min-p", d.MinP, "drop tokens below this fraction of the top probability (0 = off)")
+	fs.Float64Var(&o.TypicalP, "typical-p", d.TypicalP, "locally typical sampling threshold (0 = off)")
+	fs.Float64Var(&o.RepeatPenalty, "repeat-penalty", d.RepeatPenalty, "repeat penalty (1 = off)")
+	fs.IntVar(&o.RepeatLastN, "repeat-last-n", d.RepeatLastN, "tokens of history the penalties look at (0 = all)")
+	fs.Float64Var(&o.FrequencyPenalty, "frequency-penalty", d.FrequencyPenalty, "subtracted per earlier occurrence")
+	fs.Float64Var(&o.PresencePenalty, "presence-penalty", d.PresencePenalty, "subtracted once if the token occurred")
+	fs.IntVar(&o.Mirostat, "mirostat", d.Mirostat, "2 for Mirostat v2 (replaces top-k/p, min-p and typical-p)")
+	fs.Float64Var(&o.MirostatTau, "mirostat-tau", d.MirostatTau, "Mirostat target surprise")
+	fs.Float64Var(&o.MirostatEta, "mirostat-eta", d.MirostatEta, "Mirostat learning rate")
+	fs.Uint64Var(&o.Seed, "seed", d.Seed, "sampling seed")
+	bias := fs.String("logit-bias", "", "per-token logit bias, e.g. 2=-inf,15043=1.5")
+	return func() (sampling.Options, error) {
+		// llama.cpp also has Mirostat v1, which the Go chain does not, so the
+		// two runners would sample differently
+		if o.Mirostat != 0 && o.Mirostat != 2 { return o, fmt.Errorf("unsupported --mirostat %d (0 or 2)", o.Mirostat) }
+		if *bias == "" { return o, nil }
+		o.LogitBias = map[int]float32{}
+		for _, f := range strings.Split(*bias, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(f), "=")
+			id, err := strconv.Atoi(k)
+			if !ok || err != nil { return o, fmt.Errorf("bad logit bias %q (want id=value)", f) }
+			b, err := strconv.ParseFloat(v, 32)
+			if err != nil { return o, fmt.Errorf("bad logit bias %q: %v", f, err) }
+			o.LogitBias[id] = float32(b)
+		}
+		return o, nil
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/qrv0/crow/internal/sampling"
+)
+
+func TestSamplingFlags(t *testing.T) {
+	parse := func(d sampling.Options, args ...string) (sampling.Options, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		read := samplingFlags(fs, d)
+		if err := fs.Parse(args); err != nil { t.Fatal(err) }
+		return read()
+	}
+	// defaults come from d, Mirostat's included
+	o, err := parse(sampling.Options{TopK: 50, MirostatTau: 4, MirostatEta: 0.2}, "--mirostat", "2", "--logit-bias", "2=-inf,15043=1.5")
+	if err != nil { t.Fatal(err) }
+	if o.TopK != 50 || o.Mirostat != 2 || o.MirostatTau != 4 || o.MirostatEta != 0.2 { t.Errorf("options %+v", o) }
+	if !math.IsInf(float64(o.LogitBias[2]), -1) || o.LogitBias[15043] != 1.5 { t.Errorf("logit bias %v", o.LogitBias) }
+	// Mirostat v1 only exists on the llama.cpp side
+	for _, m := range []string{"1", "3", "-1"} {
+		if _, err := parse(sampling.Options{}, "--mirostat", m); err == nil { t.Errorf("--mirostat %s accepted", m) }
+	}
+	if _, err := parse(sampling.Options{}, "--logit-bias", "x=1"); err == nil { t.Errorf("bad logit bias accepted") }
+}
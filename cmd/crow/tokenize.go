@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qrv0/crow/internal/fileformat"
+	"github.com/qrv0/crow/internal/tokenizer"
+)
+
+// cmdTokenize encodes text to ids or decodes ids to text with the tokenizer
+// stored in a .cawsf, or with a tokenizer.json directly.
+func cmdTokenize() {
+	fs := flag.NewFlagSet("tokenize", flag.ExitOnError)
+	in := fs.String("in", "", "input .cawsf or tokenizer.json")
+	text := fs.String("text", "", "text to encode")
+	ids := fs.String("ids", "", "token ids to decode, comma separated")
+	addSpecial := fs.Bool("add-special", true, "encode: add the post-processor's tokens (e.g. BOS)")
+	skipSpecial := fs.Bool("skip-special", true, "decode: drop special tokens")
+	show := fs.Bool("show", false, "encode: print one id and its token string per line")
+	fs.Parse(os.Args[2:])
+	if *in == "" || (*text == "") == (*ids == "") {
+		fmt.Println("usage: crow tokenize --in model.cawsf|tokenizer.json (--text TEXT | --ids 1,2,3) [--add-special=true] [--skip-special=true] [--show]")
+		os.Exit(1)
+	}
+	tk, err := loadTokenizer(*in)
+	if err != nil { fmt.Fprintf(os.Stderr, "tokenize: %v\n", err); os.Exit(1) }
+	if *ids != "" {
+		list, err := parseIDs(*ids)
+		if err != nil { fmt.Fprintf(os.Stderr, "tokenize: %v\n", err); os.Exit(1) }
+		fmt.Println(tk.Decode(list, *skipSpecial))
+		return
+	}
+	out := tk.Encode(*text, *addSpecial)
+	if *show {
+		for _, id := range out { fmt.Printf("%d\t%q\n", id, tk.Token(id)) }
+		return
+	}
+	s := make([]string, len(out))
+	for i, id := range out { s[i] = strconv.Itoa(id) }
+	fmt.Println(strings.Join(s, ","))
+}
+
+// loadTokenizer reads a tokenizer.json, or the TOKENIZER section of a .cawsf
+// without loading its shard bank.
+func loadTokenizer(path string) (*tokenizer.Tokenizer, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".json" { return tokenizer.Load(path) }
+	r, err := fileformat.OpenCAWSF(path)
+	if err != nil { return nil, err }
+	defer r.Close()
+	b, err := r.SectionUncompressed(fileformat.TypeTokenizer)
+	if err != nil { return nil, fmt.Errorf("%s has no tokenizer (convert with tokenizer.json next to the checkpoint)", path) }
+	return tokenizer.Parse(b)
+}
+
+func parseIDs(s string) ([]int, error) {
+	var out []int
+	for _, f := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil { return nil, fmt.Errorf("bad token id %q", f) }
+		out = append(out, id)
+	}
+	return out, nil
+}
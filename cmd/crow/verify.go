@@ -24,7 +24,10 @@ func cmdVerify() {
     idx, ok := meta["checksum_index"].(map[string]any)
 	if !ok { fmt.Println("no checksum_index in META"); os.Exit(2) }
 	okAll := true
-	for _, sec := range []uint32{fileformat.TypeCodebooks, fileformat.TypeShardBank, fileformat.TypeRouting} {
+	secs := []uint32{fileformat.TypeCodebooks, fileformat.TypeShardBank, fileformat.TypeRouting}
+	// TOKENIZER is only present when convert found a tokenizer.json
+	if _, ok := idx[fmt.Sprint(fileformat.TypeTokenizer)]; ok { secs = append(secs, fileformat.TypeTokenizer) }
+	for _, sec := range secs {
 		name := fmt.Sprint(sec)
 		m, mok := idx[name].(map[string]any)
 		if !mok { fmt.Printf("missing checksum for section %s\n", name); okAll = false; continue }
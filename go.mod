@@ -3,11 +3,12 @@ module github.com/qrv0/crow
 go 1.22.0
 
 require (
+	github.com/dlclark/regexp2 v1.11.5
 	github.com/go-skynet/go-llama.cpp v0.0.0-20240314183750-6a8041ef6b46
 	github.com/klauspost/compress v1.17.9
+	github.com/klauspost/cpuid/v2 v2.0.9
 	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/zeebo/xxh3 v1.0.2
+	golang.org/x/text v0.12.0
 	gonum.org/v1/gonum v0.14.0
 )
-
-require github.com/klauspost/cpuid/v2 v2.0.9 // indirect
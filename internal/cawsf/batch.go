@@ -0,0 +1,145 @@
+package cawsf
+
+import (
+	"fmt"
+	"os"
+)
+
+// batchTileRows is how many weight rows are decoded to f32 at a time; each
+// input vector is then reused across the whole tile while it is in cache.
+const batchTileRows = 16
+
+// MultiplyScopeBatch computes Y = X*W^T for n input vectors at once: X is
+// n x cols and Y n x rows, both row-major, so row v of Y is W times row v of
+// X. Each shard is decoded once for the whole batch.
+func MultiplyScopeBatch(bank []byte, pool *CodebookPool, scope uint16, X []float32, n int) ([]float32, int, int, error) {
+	rt, err := NewRuntime(bank, pool, nil)
+	if err != nil { return nil, 0, 0, err }
+	return rt.MultiplyBatch(scope, X, n)
+}
+
+// MultiplyBatch is Multiply over n input vectors; see MultiplyScopeBatch.
+func (rt *Runtime) MultiplyBatch(scope uint16, X []float32, n int) ([]float32, int, int, error) {
+	shards, err := rt.shards(scope)
+	if err != nil { return nil, 0, 0, err }
+	rows, cols, err := scopeShape(scope, shards)
+	if err != nil { return nil, 0, 0, err }
+	if n < 0 || len(X) != n*cols { return nil, 0, 0, fmt.Errorf("input length %d != %d x cols %d", len(X), n, cols) }
+	Y := make([]float32, n*rows)
+	gpu := os.Getenv("CROW_CUDA") == "1" && gpuAvailable()
+	for _, sh := range shards {
+		if d, ok := sh.(*denseFP16); ok && gpu && d.gpuBatch(Y, X, n) { continue }
+		if err := sh.applyBatch(Y, X, n); err != nil { return nil, 0, 0, err }
+	}
+	return Y, rows, cols, nil
+}
+
+// batchTiles runs the blocked kernel shared by shards that can decode a range
+// of rows: addRows(r0, r1, dst) must add rows [r0, r1) into the zeroed dst.
+func batchTiles(rows, cols int, addRows func(r0, r1 int, dst []float32), Y, X []float32, n int) {
+	tiles := (rows + batchTileRows - 1) / batchTileRows
+	parallelRows(tiles, rows*cols*n, func(lo, hi int) {
+		w := make([]float32, batchTileRows*cols)
+		for t := lo; t < hi; t++ {
+			r0 := t * batchTileRows
+			r1 := min(r0+batchTileRows, rows)
+			tile := w[:(r1-r0)*cols]
+			clear(tile)
+			addRows(r0, r1, tile)
+			for v := 0; v < n; v++ {
+				x := X[v*cols : (v+1)*cols]
+				y := Y[v*rows : (v+1)*rows]
+				for i := r0; i < r1; i++ {
+					wr := tile[(i-r0)*cols : (i-r0+1)*cols]
+					s := float32(0)
+					for j, xv := range x { s += wr[j] * xv }
+					y[i] += s
+				}
+			}
+		}
+	})
+}
+
+func (d *denseFP16) applyBatch(Y, X []float32, n int) error {
+	batchTiles(d.rows, d.cols, d.addRows, Y, X, n)
+	return nil
+}
+
+// gpuBatch widens the weights once and runs one GPU matvec per input.
+func (d *denseFP16) gpuBatch(Y, X []float32, n int) bool {
+	w := d.f32()
+	for v := 0; v < n; v++ {
+		if !gpuMat(Y[v*d.rows:(v+1)*d.rows], w, d.rows, d.cols, X[v*d.cols:(v+1)*d.cols]) {
+			if v == 0 { return false }
+			// keep the inputs already done on the GPU, finish on the CPU
+			batchTiles(d.rows, d.cols, d.addRows, Y[v*d.rows:], X[v*d.cols:], n-v)
+			return true
+		}
+	}
+	return true
+}
+
+// applyBatch on the legacy flat layout decodes per input; there is no row
+// structure to tile.
+func (r *flatR) applyBatch(Y, X []float32, n int) error {
+	for v := 0; v < n; v++ {
+		if err := r.apply(Y[v*r.rows:(v+1)*r.rows], X[v*r.cols:(v+1)*r.cols]); err != nil { return err }
+	}
+	return nil
+}
+
+// applyBatch reuses one lookup table per input across all rows when there
+// are enough rows to pay for it (see matVecAdd), else decodes row tiles.
+func (r *rowPQ) applyBatch(Y, X []float32, n int) error {
+	if r.rows < r.k {
+		batchTiles(r.rows, r.cols, r.addRows, Y, X, n)
+		return nil
+	}
+	for v := 0; v < n; v++ { r.matVecAdd(Y[v*r.rows:(v+1)*r.rows], X[v*r.cols:(v+1)*r.cols]) }
+	return nil
+}
+
+func (s rvqShard) applyBatch(Y, X []float32, n int) error {
+	for _, st := range s {
+		if err := st.applyBatch(Y, X, n); err != nil { return err }
+	}
+	return nil
+}
+
+func (r *intR) applyBatch(Y, X []float32, n int) error {
+	batchTiles(r.rows, r.cols, r.addRows, Y, X, n)
+	return nil
+}
+
+// applyBatch on triplets reads the payload directly for each input; there
+// is nothing to decode.
+func (s *tripletS) applyBatch(Y, X []float32, n int) error {
+	for v := 0; v < n; v++ { applySAddOptimized(Y[v*s.rows:(v+1)*s.rows], s.rows, s.cols, s.payload, X[v*s.cols:(v+1)*s.cols]) }
+	return nil
+}
+
+// applyBatch decodes each row's varint columns once and applies the row to
+// every input.
+func (s *csrS) applyBatch(Y, X []float32, n int) error {
+	var cols []int
+	var vals []float32
+	row := -1
+	flush := func() {
+		for v := 0; v < n; v++ {
+			x := X[v*s.cols : (v+1)*s.cols]
+			acc := float32(0)
+			for i, c := range cols { acc += vals[i] * x[c] }
+			Y[v*s.rows+row] += acc
+		}
+		cols, vals = cols[:0], vals[:0]
+	}
+	err := s.each(func(r, c int, v float32) {
+		if r != row && len(cols) > 0 { flush() }
+		row = r
+		cols = append(cols, c)
+		vals = append(vals, v)
+	})
+	if err != nil { return err }
+	if len(cols) > 0 { flush() }
+	return nil
+}
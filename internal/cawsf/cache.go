@@ -0,0 +1,223 @@
+package cawsf
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EntryStats describes one cached shard to an Eviction policy.
+type EntryStats struct {
+	Scope   uint16
+	Size    int64         // decoded bytes held
+	Hits    uint64        // uses, counting the load (by a caller or a prefetch)
+	LastUse uint64        // cache clock at the last use; larger is more recent
+	Cost    time.Duration // time it took to decode
+}
+
+// Eviction scores cache entries; when over budget the cache drops the
+// unpinned entry with the lowest score, the least recently used on ties.
+type Eviction func(e EntryStats) float64
+
+var (
+	// EvictLRU drops the least recently used shard.
+	EvictLRU Eviction = func(e EntryStats) float64 { return float64(e.LastUse) }
+	// EvictLFU drops the least frequently used shard.
+	EvictLFU Eviction = func(e EntryStats) float64 { return float64(e.Hits) }
+	// EvictCost drops the shard whose decode time saved so far is smallest
+	// per byte held, so cheap large shards go before expensive small ones.
+	EvictCost Eviction = func(e EntryStats) float64 {
+		return float64(e.Cost) * float64(e.Hits) / float64(max(e.Size, 1))
+	}
+)
+
+// ParseEviction maps "lru", "lfu" or "cost" to its policy.
+func ParseEviction(name string) (Eviction, error) {
+	switch name {
+	case "", "lru":
+		return EvictLRU, nil
+	case "lfu":
+		return EvictLFU, nil
+	case "cost":
+		return EvictCost, nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q (want lru, lfu or cost)", name)
+}
+
+// CacheStats are cumulative counters plus the current occupancy.
+type CacheStats struct {
+	Hits, Misses, Evictions uint64
+	Entries                 int
+	Bytes, PinnedBytes      int64
+	Budget                  int64
+
+	// Shards a Prefetcher loaded, how many of them a caller then used
+	// (PrefetchWaits of those had to wait for the load to finish) and how
+	// many were evicted unused.
+	Prefetched, PrefetchHits, PrefetchWaits, PrefetchUnused uint64
+	// Hidden is the decode time of used prefetched shards that callers did
+	// not wait for; Waited is the time they did wait.
+	Hidden, Waited time.Duration
+}
+
+// ShardCache keeps decoded shards of one shard bank up to a byte budget.
+// Shards of pinned scopes are never evicted and may push Bytes past the
+// budget; a single unpinned shard larger than the budget is not cached. It is
+// safe for concurrent use.
+type ShardCache struct {
+	mu      sync.Mutex
+	budget  int64
+	evict   Eviction
+	clock   uint64
+	entries map[int]*cacheEntry // by record offset in the bank
+	loading map[int]chan struct{} // prefetches in flight, closed when done
+	pinned  map[uint16]bool
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	sh         shard
+	prefetched bool // loaded by a Prefetcher and not used yet
+	EntryStats
+}
+
+// NewShardCache returns a cache holding up to budget decoded bytes; a nil
+// evict means EvictLRU.
+func NewShardCache(budget int64, evict Eviction) *ShardCache {
+	if evict == nil { evict = EvictLRU }
+	return &ShardCache{budget: budget, evict: evict, entries: map[int]*cacheEntry{}, loading: map[int]chan struct{}{}, pinned: map[uint16]bool{}}
+}
+
+// Pin keeps the shards of scope resident once loaded.
+func (c *ShardCache) Pin(scope uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned[scope] = true
+	c.recount()
+}
+
+// Unpin makes scope evictable again; the budget is enforced on the next load.
+func (c *ShardCache) Unpin(scope uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pinned, scope)
+	c.recount()
+}
+
+// Stats returns a snapshot of the counters.
+func (c *ShardCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = len(c.entries)
+	s.Budget = c.budget
+	return s
+}
+
+func (c *ShardCache) recount() {
+	c.stats.PinnedBytes = 0
+	for _, e := range c.entries {
+		if c.pinned[e.Scope] { c.stats.PinnedBytes += e.Size }
+	}
+}
+
+// get returns the cached shard for key. A shard a prefetch is still loading
+// is waited for rather than reported missing.
+func (c *ShardCache) get(key int) (shard, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	var waited time.Duration
+	if done, loading := c.loading[key]; !ok && loading {
+		c.mu.Unlock()
+		start := time.Now()
+		<-done
+		waited = time.Since(start)
+		c.mu.Lock()
+		e, ok = c.entries[key]
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.clock++
+	e.Hits++
+	e.LastUse = c.clock
+	c.stats.Hits++
+	if e.prefetched {
+		e.prefetched = false
+		c.stats.PrefetchHits++
+		c.stats.Hidden += max(e.Cost-waited, 0)
+		if waited > 0 {
+			c.stats.PrefetchWaits++
+			c.stats.Waited += waited
+		}
+	}
+	return e.sh, true
+}
+
+func (c *ShardCache) put(key int, scope uint16, sh shard, cost time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, scope, sh, cost, false)
+}
+
+// reserve marks key as being prefetched; false if it is cached or already
+// loading. The caller must end the reservation with finish.
+func (c *ShardCache) reserve(key int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok { return false }
+	if _, ok := c.loading[key]; ok { return false }
+	c.loading[key] = make(chan struct{})
+	return true
+}
+
+// finish stores a prefetched shard, or with a nil sh gives up on it, and
+// wakes the callers waiting for key.
+func (c *ShardCache) finish(key int, scope uint16, sh shard, cost time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sh != nil && c.store(key, scope, sh, cost, true) { c.stats.Prefetched++ }
+	close(c.loading[key])
+	delete(c.loading, key)
+}
+
+// store adds a shard and evicts down to the budget; false if the shard was
+// not kept.
+func (c *ShardCache) store(key int, scope uint16, sh shard, cost time.Duration, prefetched bool) bool {
+	if _, ok := c.entries[key]; ok { return false } // decoded concurrently
+	size := sh.size()
+	if size > c.budget && !c.pinned[scope] { return false }
+	c.clock++
+	c.entries[key] = &cacheEntry{sh: sh, prefetched: prefetched, EntryStats: EntryStats{Scope: scope, Size: size, Hits: 1, LastUse: c.clock, Cost: cost}}
+	c.stats.Bytes += size
+	if c.pinned[scope] { c.stats.PinnedBytes += size }
+	for c.stats.Bytes > c.budget {
+		victim := c.victim(key)
+		if victim < 0 { break }
+		v := c.entries[victim]
+		c.stats.Bytes -= v.Size
+		c.stats.Evictions++
+		if v.prefetched && victim != key { c.stats.PrefetchUnused++ }
+		delete(c.entries, victim)
+		if victim == key { return false }
+	}
+	return true
+}
+
+// victim picks the entry to evict, preferring any entry but the one just
+// loaded; -1 if everything is pinned.
+func (c *ShardCache) victim(loaded int) int {
+	best, bestScore := -1, 0.0
+	var bestUse uint64
+	for k, e := range c.entries {
+		if k == loaded || c.pinned[e.Scope] { continue }
+		s := c.evict(e.EntryStats)
+		if best < 0 || s < bestScore || (s == bestScore && e.LastUse < bestUse) {
+			best, bestScore, bestUse = k, s, e.LastUse
+		}
+	}
+	if best < 0 && !c.pinned[c.entries[loaded].Scope] { return loaded }
+	return best
+}
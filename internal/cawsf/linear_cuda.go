@@ -7,6 +7,7 @@ import "github.com/qrv0/crow/internal/gpu"
 func init() {
     gpu_Available    = gpu.Available
     gpu_MatVecF32    = gpu.MatVecF32
+    gpu_MatVecTF32   = gpu.MatVecTF32
     gpu_RPQMatVecF32 = gpu.RPQMatVecF32
     gpu_SparseAddF32 = gpu.SparseAddF32
 }
@@ -0,0 +1,342 @@
+package cawsf
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/qrv0/crow/internal/convert"
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// convertBank runs the converter on a random matrix and returns the packed
+// shard bank for scope 0 together with the source weights.
+func convertBank(t testing.TB, rows, cols int, cfg convert.Config) ([]byte, []float32) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(3))
+	w := make([]float32, rows*cols)
+	for i := range w { w[i] = float32(rng.NormFloat64()) }
+	shards, err := convert.ConvertLayer(convert.LayerSpec{Name: "w", Rows: rows, Cols: cols, Data: w}, cfg)
+	if err != nil { t.Fatalf("convert: %v", err) }
+	var bank []byte
+	for _, s := range shards { bank = append(bank, pack(s.Type, s.Scope, s.Data)...) }
+	return bank, w
+}
+
+func randVec(n int, seed int64) []float32 {
+	rng := rand.New(rand.NewSource(seed))
+	x := make([]float32, n)
+	for i := range x { x[i] = float32(rng.NormFloat64()) }
+	return x
+}
+
+func denseMatVec(w []float32, rows, cols int, x []float32) []float32 {
+	y := make([]float32, rows)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ { y[r] += w[r*cols+c] * x[c] }
+	}
+	return y
+}
+
+// checkMultiplyMatchesReconstruct verifies that the streaming y = W*x agrees
+// with multiplying the densely reconstructed matrix.
+func checkMultiplyMatchesReconstruct(t *testing.T, bank []byte, pool *CodebookPool, rows, cols int) {
+	t.Helper()
+	r, c, w, err := ReconstructForScopeWithPool(bank, pool, 0)
+	if err != nil { t.Fatalf("reconstruct: %v", err) }
+	if r != rows || c != cols { t.Fatalf("shape %dx%d want %dx%d", r, c, rows, cols) }
+	x := randVec(cols, 11)
+	want := denseMatVec(w, rows, cols, x)
+	got, _, _, err := MultiplyScopeWithPool(bank, pool, 0, x)
+	if err != nil { t.Fatalf("multiply: %v", err) }
+	for i := range want {
+		if absf(got[i]-want[i]) > 1e-3*(1+absf(want[i])) { t.Fatalf("y[%d] got %f want %f", i, got[i], want[i]) }
+	}
+}
+
+func TestRowAlignedRMatchesReconstruct(t *testing.T) {
+	// cols not a multiple of d exercises the per-row padded block
+	rows, cols := 12, 37
+	for _, layout := range []string{convert.LayoutRow, convert.LayoutFlat} {
+		cfg := convert.Config{Rank: 2, OutlierQuantile: 0.99, PQm: 4, PQk: 8, PQd: 16, RLayout: layout}
+		bank, _ := convertBank(t, rows, cols, cfg)
+		checkMultiplyMatchesReconstruct(t, bank, nil, rows, cols)
+	}
+}
+
+func TestRowAlignedRSharedCodebook(t *testing.T) {
+	// rows=2, cols=3, d=2, m=1, k=2: each row is two blocks, the second padded
+	pool := &CodebookPool{Entries: map[uint16]CodebookEntry{7: {ID: 7, D: 2, M: 1, K: 2, Data: []float32{1, 2, 3, 4}}}}
+	p := []byte{2, 0, 0, 0, 3, 0, 0, 0, 2, 0, 1, 0, 2, 0, 8, rFlagSharedCB, 7, 0}
+	p = append(p, 0, 1, 1, 0) // row 0: [1 2 | 3 _], row 1: [3 4 | 1 _]
+	bank := pack(shRRow, 0, p)
+	_, _, w, err := ReconstructForScopeWithPool(bank, pool, 0)
+	if err != nil { t.Fatalf("reconstruct: %v", err) }
+	want := []float32{1, 2, 3, 3, 4, 1}
+	for i := range want {
+		if w[i] != want[i] { t.Fatalf("w=%v want %v", w, want) }
+	}
+	checkMultiplyMatchesReconstruct(t, bank, pool, 2, 3)
+}
+
+func TestRotatedRMatchesReconstruct(t *testing.T) {
+	// cols not a multiple of d: padding columns carry rotated weight
+	rows, cols := 12, 37
+	for _, rot := range []string{convert.RotationHadamard, convert.RotationOPQ} {
+		cfg := convert.Config{Rank: 1, OutlierQuantile: 0.99, PQm: 4, PQk: 8, PQd: 16, Rotation: rot}
+		bank, _ := convertBank(t, rows, cols, cfg)
+		idx, _ := IndexShardBank(bank)
+		for _, rec := range idx.Records {
+			if rec.Hdr.Type != shRRow { continue }
+			r, err := parseRowPQ(bank[rec.Offset:rec.Offset+int(rec.Hdr.Csize)], nil)
+			if err != nil { t.Fatalf("%s: %v", rot, err) }
+			if r.rot == nil { t.Fatalf("%s: no rotation stored", rot) }
+		}
+		checkMultiplyMatchesReconstruct(t, bank, nil, rows, cols)
+	}
+}
+
+func TestRotatedRReusesScratch(t *testing.T) {
+	rowPQOf := func(cfg convert.Config) *rowPQ {
+		bank, _ := convertBank(t, 12, 37, cfg)
+		idx, _ := IndexShardBank(bank)
+		for _, rec := range idx.Records {
+			if rec.Hdr.Type != shRRow { continue }
+			r, err := parseRowPQ(bank[rec.Offset:rec.Offset+int(rec.Hdr.Csize)], nil)
+			if err != nil { t.Fatal(err) }
+			return r
+		}
+		t.Fatal("no row R shard")
+		return nil
+	}
+	cfg := convert.Config{Rank: 1, OutlierQuantile: 0.99, PQm: 4, PQk: 8, PQd: 16}
+	plain := rowPQOf(cfg)
+	cfg.Rotation = convert.RotationHadamard
+	rotated := rowPQOf(cfg)
+	x, y := randVec(37, 3), make([]float32, 12)
+	withWorkers(t, 1, func() {
+		base := testing.AllocsPerRun(20, func() { plain.matVecAdd(y, x) })
+		if n := testing.AllocsPerRun(20, func() { rotated.matVecAdd(y, x) }); n > base { t.Errorf("rotated matVecAdd allocates %v times per call, unrotated %v", n, base) }
+	})
+}
+
+func TestSEncodingsAgree(t *testing.T) {
+	rows, cols := 20, 300
+	var dense [][]float32
+	for _, enc := range []string{convert.SEncTriplet, convert.SEncCSR, convert.SEncCSRBF16} {
+		cfg := convert.Config{Rank: 1, OutlierQuantile: 0.9, PQm: 4, PQk: 4, PQd: 16, SEncoding: enc}
+		bank, _ := convertBank(t, rows, cols, cfg)
+		checkMultiplyMatchesReconstruct(t, bank, nil, rows, cols)
+		_, _, w, err := ReconstructForScope(bank, 0)
+		if err != nil { t.Fatalf("%s: %v", enc, err) }
+		dense = append(dense, w)
+	}
+	// same decomposition, so only the value precision differs
+	for i := range dense[0] {
+		if absf(dense[1][i]-dense[0][i]) > 2e-3*(1+absf(dense[0][i])) { t.Fatalf("csr fp16 [%d] %f vs %f", i, dense[1][i], dense[0][i]) }
+		if absf(dense[2][i]-dense[0][i]) > 2e-2*(1+absf(dense[0][i])) { t.Fatalf("csr bf16 [%d] %f vs %f", i, dense[2][i], dense[0][i]) }
+	}
+}
+
+func TestSCSRApplyDoesNotAllocate(t *testing.T) {
+	rows, cols := 16, 64
+	bank, _ := convertBank(t, rows, cols, convert.Config{Rank: 1, OutlierQuantile: 0.8, PQm: 4, PQk: 4, PQd: 16})
+	idx, _ := IndexShardBank(bank)
+	var payload []byte
+	for _, rec := range idx.Records {
+		if rec.Hdr.Type == shSCSR { payload = bank[rec.Offset : rec.Offset+int(rec.Hdr.Csize)] }
+	}
+	if payload == nil { t.Fatalf("no CSR shard") }
+	x := randVec(cols, 1)
+	y := make([]float32, rows)
+	allocs := testing.AllocsPerRun(20, func() {
+		sp, err := parseSCSR(payload)
+		if err != nil { t.Fatal(err) }
+		if err := sp.matVecAdd(y, x); err != nil { t.Fatal(err) }
+	})
+	if allocs != 0 { t.Fatalf("CSR apply allocated %v times", allocs) }
+}
+
+func TestRCodeWidths(t *testing.T) {
+	rows, cols := 40, 64
+	cases := []struct {
+		layout string
+		k      int
+		fp16   bool
+		bits   int
+	}{
+		{convert.LayoutRow, 12, false, 4},
+		{convert.LayoutRow, 300, false, 12},
+		{convert.LayoutRow, 300, true, 12},
+		{convert.LayoutFlat, 300, false, 12},
+	}
+	for _, tc := range cases {
+		cfg := convert.Config{Rank: 1, OutlierQuantile: 1, PQm: 2, PQk: tc.k, PQd: 8, PQfp16: tc.fp16, RLayout: tc.layout}
+		bank, w := convertBank(t, rows, cols, cfg)
+		idx, _ := IndexShardBank(bank)
+		var maxCode int
+		for _, rec := range idx.Records {
+			if rec.Hdr.Type != shRRow { continue }
+			r, err := parseRowPQ(bank[rec.Offset:rec.Offset+int(rec.Hdr.Csize)], nil)
+			if err != nil { t.Fatalf("%+v: %v", tc, err) }
+			if r.bits != tc.bits { t.Fatalf("%+v: bits %d", tc, r.bits) }
+			for i := 0; i < r.rows*r.bpr*r.m; i++ { maxCode = max(maxCode, quant.Code(r.codes, r.bits, i)) }
+		}
+		if tc.layout == convert.LayoutRow && tc.k > 256 && maxCode < 256 { t.Fatalf("%+v: no code above 255", tc) }
+		_, _, got, err := ReconstructForScopeWithPool(bank, nil, 0)
+		if err != nil { t.Fatalf("%+v: %v", tc, err) }
+		var e, n float64
+		for i := range w { d := float64(got[i] - w[i]); e += d * d; n += float64(w[i]) * float64(w[i]) }
+		// 300 centroids for 1280 two-dim sub-vectors should leave little residue
+		if tc.k > 256 && e/n > 0.1 { t.Fatalf("%+v: relative error %f", tc, e/n) }
+		checkMultiplyMatchesReconstruct(t, bank, nil, rows, cols)
+	}
+}
+
+func TestCodebookPoolFP16(t *testing.T) {
+	// one entry, d=2 m=1 k=2 stored as fp16: size 8 instead of 16
+	b := []byte{1, 0, 3, 0, 2, 0, 1, 0, 2, 0, 8, 0, 0, 0}
+	for _, v := range []uint16{0x3c00, 0x4000, 0xc200, 0x3800} { b = append(b, byte(v), byte(v>>8)) }
+	pool, err := ParseCodebookPool(b)
+	if err != nil { t.Fatal(err) }
+	e := pool.Entries[3]
+	want := []float32{1, 2, -3, 0.5}
+	if !e.FP16 || len(e.Data) != 4 { t.Fatalf("entry %+v", e) }
+	for i := range want {
+		if e.Data[i] != want[i] { t.Fatalf("data %v want %v", e.Data, want) }
+	}
+}
+
+func TestCodebookPoolReadsQuantEntries(t *testing.T) {
+	pq := quant.TrainPQ([][]float32{{1, 2, 3, 4}, {5, 6, 7, 8}, {0, 1, 0, 1}}, 2, 2, 5, 1)
+	pool, err := ParseCodebookPool(quant.MarshalCodebooks([]*quant.PQ{pq}, false))
+	if err != nil { t.Fatal(err) }
+	e := pool.Entries[0]
+	if e.D != 4 || e.M != 2 || e.K != 2 || len(e.Data) != 8 { t.Fatalf("entry %+v", e) }
+	for i, v := range append(append([]float32(nil), pq.Codebooks[0]...), pq.Codebooks[1]...) {
+		if e.Data[i] != v { t.Fatalf("data %v want %v", e.Data, pq.Codebooks) }
+	}
+}
+
+func TestRVQStagesReduceError(t *testing.T) {
+	rows, cols := 24, 48
+	for _, fp16 := range []bool{false, true} {
+		var prev float64
+		for _, stages := range []int{1, 2, 3} {
+			cfg := convert.Config{Rank: 1, OutlierQuantile: 1, PQm: 4, PQk: 16, PQd: 8, PQfp16: fp16, Codec: convert.CodecRVQ, RVQStages: stages}
+			bank, w := convertBank(t, rows, cols, cfg)
+			checkMultiplyMatchesReconstruct(t, bank, nil, rows, cols)
+			_, _, got, err := ReconstructForScopeWithPool(bank, nil, 0)
+			if err != nil { t.Fatal(err) }
+			var e float64
+			for i := range w { d := float64(got[i] - w[i]); e += d * d }
+			if stages > 1 && e >= prev { t.Fatalf("fp16=%v %d stages: error %f not below %f", fp16, stages, e, prev) }
+			prev = e
+		}
+	}
+}
+
+func TestIntRMatchesReconstruct(t *testing.T) {
+	// odd cols leave a partial group and a half-filled int4 byte per row
+	rows, cols := 10, 37
+	for _, codec := range []string{convert.CodecInt4, convert.CodecInt8} {
+		for _, zp := range []bool{false, true} {
+			cfg := convert.Config{Rank: 1, OutlierQuantile: 1, Codec: codec, IntGroup: 8, IntZeroPoint: zp}
+			bank, w := convertBank(t, rows, cols, cfg)
+			checkMultiplyMatchesReconstruct(t, bank, nil, rows, cols)
+			_, _, got, err := ReconstructForScopeWithPool(bank, nil, 0)
+			if err != nil { t.Fatal(err) }
+			var e, n float64
+			for i := range w { d := float64(got[i] - w[i]); e += d * d; n += float64(w[i]) * float64(w[i]) }
+			limit := 0.02
+			if codec == convert.CodecInt8 { limit = 1e-4 }
+			if e/n > limit { t.Fatalf("%s zp=%v: relative error %g", codec, zp, e/n) }
+		}
+	}
+}
+
+// firstRowPQ parses the first row-aligned R shard of a bank.
+func firstRowPQ(tb testing.TB, bank []byte) *rowPQ {
+	tb.Helper()
+	idx, _ := IndexShardBank(bank)
+	for _, rec := range idx.Records {
+		if rec.Hdr.Type != shRRow { continue }
+		r, err := parseRowPQ(bank[rec.Offset:rec.Offset+int(rec.Hdr.Csize)], nil)
+		if err != nil { tb.Fatal(err) }
+		return r
+	}
+	tb.Fatalf("no row-aligned R shard")
+	return nil
+}
+
+func TestLUTMatchesDirect(t *testing.T) {
+	rows, cols := 24, 45
+	for _, k := range []int{12, 16, 300} {
+		bank, _ := convertBank(t, rows, cols, convert.Config{Rank: 1, OutlierQuantile: 1, PQm: 2, PQk: k, PQd: 8})
+		r := firstRowPQ(t, bank)
+		x := randVec(cols, 4)
+		want := make([]float32, rows)
+		got := make([]float32, rows)
+		r.directMatVecAdd(want, x, cols)
+		r.lutMatVecAdd(got, x)
+		for i := range want {
+			if absf(got[i]-want[i]) > 1e-4*(1+absf(want[i])) { t.Fatalf("k=%d y[%d] lut %f direct %f", k, i, got[i], want[i]) }
+		}
+	}
+}
+
+func TestRowPQGPUHookNeedsDevice(t *testing.T) {
+	rows, cols := 24, 45
+	bank, _ := convertBank(t, rows, cols, convert.Config{Rank: 1, OutlierQuantile: 1, PQm: 2, PQk: 16, PQd: 8})
+	r := firstRowPQ(t, bank)
+	x := randVec(cols, 5)
+	want := make([]float32, rows)
+	r.lutMatVecAdd(want, x)
+	avail, hook := gpu_Available, gpu_RPQRowMatVecF32
+	defer func() { gpu_Available, gpu_RPQRowMatVecF32 = avail, hook }()
+	calls := 0
+	gpu_RPQRowMatVecF32 = func(y []float32, cb []float32, d, m, k, rows, cols, bits int, codes []byte, x []float32) bool { calls++; return true }
+	for _, dev := range []bool{false, true} {
+		gpu_Available = func() bool { return dev }
+		got := make([]float32, rows)
+		r.matVecAdd(got, x)
+		if dev {
+			if calls != 1 { t.Fatalf("bound hook not called with a device") }
+			continue
+		}
+		if calls != 0 { t.Fatalf("hook called without a device") }
+		for i := range want {
+			if got[i] != want[i] { t.Fatalf("y[%d] = %f, lut %f", i, got[i], want[i]) }
+		}
+	}
+}
+
+func benchRMatVec(b *testing.B, layout string, apply func(b *testing.B, bank []byte, y, x []float32)) {
+	rows, cols := 512, 512
+	bank, _ := convertBank(b, rows, cols, convert.Config{Rank: 1, OutlierQuantile: 1, PQm: 8, PQk: 256, PQd: 32, RLayout: layout})
+	x := randVec(cols, 1)
+	y := make([]float32, rows)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ { apply(b, bank, y, x) }
+}
+
+func BenchmarkRFlatDecode(b *testing.B) {
+	benchRMatVec(b, convert.LayoutFlat, func(b *testing.B, bank []byte, y, x []float32) {
+		idx, _ := IndexShardBank(bank)
+		for _, rec := range idx.Records {
+			if rec.Hdr.Type != shR { continue }
+			if err := applyRAddOptimized(y, len(y), len(x), bank[rec.Offset:rec.Offset+int(rec.Hdr.Csize)], x, nil); err != nil { b.Fatal(err) }
+		}
+	})
+}
+
+func BenchmarkRRowDirect(b *testing.B) {
+	benchRMatVec(b, convert.LayoutRow, func(b *testing.B, bank []byte, y, x []float32) {
+		firstRowPQ(b, bank).directMatVecAdd(y, x, len(x))
+	})
+}
+
+func BenchmarkRRowLUT(b *testing.B) {
+	benchRMatVec(b, convert.LayoutRow, func(b *testing.B, bank []byte, y, x []float32) {
+		firstRowPQ(b, bank).lutMatVecAdd(y, x)
+	})
+}
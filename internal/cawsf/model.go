@@ -0,0 +1,92 @@
+package cawsf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/qrv0/crow/internal/fileformat"
+)
+
+// Layer is one entry of META "layers": the tensor a scope was converted from.
+type Layer struct {
+	Scope uint16 `json:"scope_id"`
+	Name  string `json:"name"`
+	Shape []int  `json:"shape"`
+}
+
+// ScopeInfo describes one scope of a Model. Name and Shape come from META
+// and are empty when the file has no entry for the scope.
+type ScopeInfo struct {
+	ID      uint16
+	Name    string
+	Shape   []int
+	Records []BankRec // shard records in bank order
+}
+
+// Model is a .cawsf file loaded once: the shard bank indexed by scope, the
+// codebook pool and the META layer table. Its embedded Runtime does the
+// multiplies and reconstructions; the file can be closed once loaded.
+type Model struct {
+	*Runtime
+	Meta          map[string]any
+	TokenizerJSON []byte // TOKENIZER section, nil when absent
+	layers        map[uint16]Layer
+	byName        map[string]uint16
+}
+
+// LoadModel reads the sections of r it needs. The CODEBOOKS, META and
+// TOKENIZER sections are optional; cache may be nil (see NewRuntime).
+func LoadModel(r *fileformat.Reader, cache *ShardCache) (*Model, error) {
+	bank, err := r.SectionUncompressed(fileformat.TypeShardBank)
+	if err != nil { return nil, fmt.Errorf("read shard bank: %w", err) }
+	var pool *CodebookPool
+	if cb, err := r.SectionUncompressed(fileformat.TypeCodebooks); err == nil {
+		if pool, err = ParseCodebookPool(cb); err != nil { return nil, err }
+	}
+	rt, err := NewRuntime(bank, pool, cache)
+	if err != nil { return nil, err }
+	m := &Model{Runtime: rt, Meta: map[string]any{}, layers: map[uint16]Layer{}, byName: map[string]uint16{}}
+	if tok, err := r.SectionUncompressed(fileformat.TypeTokenizer); err == nil { m.TokenizerJSON = tok }
+	raw, err := r.SectionUncompressed(fileformat.TypeMeta)
+	if err != nil { return m, nil }
+	if err := json.Unmarshal(raw, &m.Meta); err != nil { return nil, fmt.Errorf("parse META: %w", err) }
+	var meta struct{ Layers []Layer `json:"layers"` }
+	if err := json.Unmarshal(raw, &meta); err != nil { return nil, fmt.Errorf("parse META layers: %w", err) }
+	for _, l := range meta.Layers {
+		m.layers[l.Scope] = l
+		m.byName[l.Name] = l.Scope
+	}
+	return m, nil
+}
+
+// OpenModel loads the .cawsf file at path and closes it.
+func OpenModel(path string, cache *ShardCache) (*Model, error) {
+	r, err := fileformat.OpenCAWSF(path)
+	if err != nil { return nil, err }
+	defer r.Close()
+	return LoadModel(r, cache)
+}
+
+// Pool returns the model's codebook pool, or nil.
+func (m *Model) Pool() *CodebookPool { return m.pool }
+
+// Scope looks up a scope present in the shard bank.
+func (m *Model) Scope(id uint16) (ScopeInfo, bool) {
+	recs, ok := m.scopes[id]
+	if !ok { return ScopeInfo{}, false }
+	l := m.layers[id]
+	return ScopeInfo{ID: id, Name: l.Name, Shape: l.Shape, Records: recs}, true
+}
+
+// ScopeByName looks up a scope by the tensor name recorded in META.
+func (m *Model) ScopeByName(name string) (ScopeInfo, bool) {
+	id, ok := m.byName[name]
+	if !ok { return ScopeInfo{}, false }
+	return m.Scope(id)
+}
+
+// Name returns the tensor name of scope, or "scope_<id>" without one.
+func (m *Model) Name(id uint16) string {
+	if l, ok := m.layers[id]; ok && l.Name != "" { return l.Name }
+	return fmt.Sprintf("scope_%d", id)
+}
@@ -0,0 +1,46 @@
+package cawsf
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/qrv0/crow/internal/fileformat"
+)
+
+func TestModelScopes(t *testing.T) {
+	rows, cols := 8, 16
+	bank := multiScopeBank(t, 3, rows, cols)
+	meta, _ := json.Marshal(map[string]any{"layers": []map[string]any{
+		{"scope_id": 0, "name": "a.weight", "shape": []int{rows, cols}},
+		{"scope_id": 2, "name": "c.weight", "shape": []int{rows, cols}},
+	}})
+	path := filepath.Join(t.TempDir(), "m.cawsf")
+	w := fileformat.NewWriter()
+	w.AddSection(fileformat.TypeMeta, meta, 0)
+	w.AddSection(fileformat.TypeShardBank, bank, fileformat.FlagCompLZ4)
+	w.AddSection(fileformat.TypeTokenizer, []byte(`{"model":{}}`), fileformat.FlagCompZSTD)
+	if err := w.Write(path); err != nil { t.Fatal(err) }
+
+	m, err := OpenModel(path, nil)
+	if err != nil { t.Fatal(err) }
+	if sc := m.Scopes(); len(sc) != 3 || sc[0] != 0 || sc[2] != 2 { t.Fatalf("scopes %v", sc) }
+	info, ok := m.ScopeByName("c.weight")
+	if !ok || info.ID != 2 || info.Name != "c.weight" || len(info.Shape) != 2 || len(info.Records) != 4 { t.Fatalf("ScopeByName: %+v %v", info, ok) }
+	if info, ok := m.Scope(1); !ok || info.Name != "" || m.Name(1) != "scope_1" { t.Fatalf("Scope(1): %+v %v", info, ok) }
+	if _, ok := m.Scope(3); ok { t.Fatalf("Scope(3) found") }
+	if _, ok := m.ScopeByName("b.weight"); ok { t.Fatalf("unknown name found") }
+	if m.Meta["layers"] == nil { t.Fatalf("META not kept") }
+	if string(m.TokenizerJSON) != `{"model":{}}` { t.Fatalf("TokenizerJSON = %q", m.TokenizerJSON) }
+
+	x := randVec(cols, 1)
+	for _, sc := range m.Scopes() {
+		want, _, _, err := MultiplyScopeWithPool(bank, nil, sc, x)
+		if err != nil { t.Fatal(err) }
+		got, _, _, err := m.Multiply(sc, x)
+		if err != nil { t.Fatal(err) }
+		for i := range want {
+			if got[i] != want[i] { t.Fatalf("scope %d y[%d] = %f want %f", sc, i, got[i], want[i]) }
+		}
+	}
+}
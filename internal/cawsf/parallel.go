@@ -0,0 +1,76 @@
+package cawsf
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minParallelWork is the multiply-add count below which a kernel stays on
+// the calling goroutine; spawning workers costs more than it saves.
+const minParallelWork = 1 << 16
+
+// chunksPerWorker splits rows finer than one range per worker so uneven rows
+// (S outliers, padded blocks) still balance.
+const chunksPerWorker = 4
+
+var workers atomic.Int32
+
+// SetWorkers sets how many goroutines shard kernels split their output rows
+// over; n <= 0 means GOMAXPROCS. Every row is summed by one goroutine in the
+// serial order, so results do not depend on the setting.
+func SetWorkers(n int) {
+	if n < 0 { n = 0 }
+	workers.Store(int32(n))
+}
+
+// Workers returns the kernel worker count in effect.
+func Workers() int {
+	if n := int(workers.Load()); n > 0 { return n }
+	return runtime.GOMAXPROCS(0)
+}
+
+// rowSplit returns the boundaries of the row ranges parallelRows would hand
+// out, or nil when the work should stay on one goroutine. Kernels that need
+// per-range setup (CSR varint offsets) compute it from these.
+func rowSplit(rows, work int) []int {
+	w := Workers()
+	if w <= 1 || rows <= 1 || work < minParallelWork { return nil }
+	step := max(1, (rows+w*chunksPerWorker-1)/(w*chunksPerWorker))
+	var bounds []int
+	for lo := 0; lo < rows; lo += step { bounds = append(bounds, lo) }
+	return append(bounds, rows)
+}
+
+// runRanges calls fn(i, bounds[i], bounds[i+1]) for every range, spread over
+// Workers goroutines.
+func runRanges(bounds []int, fn func(i, lo, hi int)) {
+	chunks := len(bounds) - 1
+	w := min(Workers(), chunks)
+	var next atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < w; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				c := int(next.Add(1)) - 1
+				if c >= chunks { return }
+				fn(c, bounds[c], bounds[c+1])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// parallelRows calls fn over disjoint row ranges covering [0, rows), in
+// parallel when work (multiply-adds) is large enough. fn must only write the
+// outputs of its own rows.
+func parallelRows(rows, work int, fn func(lo, hi int)) {
+	bounds := rowSplit(rows, work)
+	if bounds == nil {
+		fn(0, rows)
+		return
+	}
+	runRanges(bounds, func(_, lo, hi int) { fn(lo, hi) })
+}
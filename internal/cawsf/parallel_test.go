@@ -0,0 +1,109 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/qrv0/crow/internal/convert"
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// withWorkers runs fn with the kernel worker count set to n.
+func withWorkers(tb testing.TB, n int, fn func()) {
+	tb.Helper()
+	prev := int(workers.Load())
+	SetWorkers(n)
+	defer SetWorkers(prev)
+	fn()
+}
+
+func TestWorkersDeterministic(t *testing.T) {
+	// large enough to pass minParallelWork; a low outlier quantile gives S
+	// shards with enough entries to split too
+	rows, cols := 384, 320
+	cfgs := []convert.Config{
+		{Rank: 2, OutlierQuantile: 0.3, PQm: 4, PQk: 16, PQd: 16},
+		{Rank: 2, OutlierQuantile: 0.3, PQm: 4, PQk: 16, PQd: 16, RLayout: convert.LayoutFlat, SEncoding: convert.SEncTriplet},
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 2, PQk: 512, PQd: 8}, // rows < k: direct kernel
+		{Rank: 2, OutlierQuantile: 0.95, Codec: convert.CodecInt4, IntGroup: 32},
+		{StoreRaw: true},
+	}
+	x := randVec(cols, 4)
+	X := randVec(3*cols, 5)
+	for ci, cfg := range cfgs {
+		bank, _ := convertBank(t, rows, cols, cfg)
+		var want, wantB []float32
+		for _, w := range []int{1, 2, 8} {
+			withWorkers(t, w, func() {
+				y, _, _, err := MultiplyScopeWithPool(bank, nil, 0, x)
+				if err != nil { t.Fatalf("cfg %d: %v", ci, err) }
+				Y, _, _, err := MultiplyScopeBatch(bank, nil, 0, X, 3)
+				if err != nil { t.Fatalf("cfg %d batch: %v", ci, err) }
+				if want == nil {
+					want, wantB = y, Y
+					return
+				}
+				for i := range want {
+					if y[i] != want[i] { t.Fatalf("cfg %d workers %d y[%d] = %v, serial %v", ci, w, i, y[i], want[i]) }
+				}
+				for i := range wantB {
+					if Y[i] != wantB[i] { t.Fatalf("cfg %d workers %d batch Y[%d] = %v, serial %v", ci, w, i, Y[i], wantB[i]) }
+				}
+			})
+		}
+	}
+}
+
+func TestFP16TableMatchesConvert(t *testing.T) {
+	for h := 0; h < 1<<16; h++ {
+		a, b := fp16to32(uint16(h)), quant.FP16ToFloat32(uint16(h))
+		if a != b && !(a != a && b != b) { t.Fatalf("fp16 %#04x: table %v convert %v", h, a, b) }
+	}
+}
+
+// matvecFP16Scalar is the single-goroutine kernel with per-element fp16
+// conversion that matvecFP16Add replaced; kept as the benchmark baseline.
+func matvecFP16Scalar(y []float32, rows, cols int, data []byte, x []float32) {
+	for i := 0; i < rows; i++ {
+		s := float32(0)
+		for j := 0; j < cols; j++ { s += quant.FP16ToFloat32(binary.LittleEndian.Uint16(data[2*(i*cols+j):])) * x[j] }
+		y[i] += s
+	}
+}
+
+func BenchmarkFP16MatVec(b *testing.B) {
+	rows, cols := 2048, 2048
+	data := make([]byte, 2*rows*cols)
+	for i, v := range randVec(rows*cols, 1) { copy(data[2*i:], fp32to16(v)) }
+	x := randVec(cols, 2)
+	y := make([]float32, rows)
+	b.Run("scalar", func(b *testing.B) {
+		for i := 0; i < b.N; i++ { matvecFP16Scalar(y, rows, cols, data, x) }
+	})
+	for _, w := range []int{1, 0} { // 0: GOMAXPROCS
+		b.Run(fmt.Sprintf("workers=%d", w), func(b *testing.B) {
+			withWorkers(b, w, func() {
+				for i := 0; i < b.N; i++ { matvecFP16Add(y, rows, cols, data, x) }
+			})
+		})
+	}
+}
+
+func BenchmarkMultiplyWorkers(b *testing.B) {
+	rows, cols := 512, 512
+	bank, _ := convertBank(b, rows, cols, convert.Config{Rank: 8, OutlierQuantile: 0.99, PQm: 8, PQk: 256, PQd: 32})
+	rt, err := NewRuntime(bank, nil, NewShardCache(1<<30, nil))
+	if err != nil { b.Fatal(err) }
+	x := randVec(cols, 1)
+	if err := rt.Warm(0); err != nil { b.Fatal(err) }
+	for _, w := range []int{1, 0} { // 0: GOMAXPROCS
+		b.Run(fmt.Sprintf("workers=%d", w), func(b *testing.B) {
+			withWorkers(b, w, func() {
+				for i := 0; i < b.N; i++ {
+					if _, _, _, err := rt.Multiply(0, x); err != nil { b.Fatal(err) }
+				}
+			})
+		})
+	}
+}
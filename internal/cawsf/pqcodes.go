@@ -0,0 +1,34 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// flatCodeBits returns the code width of a flat-layout (type 1) R payload
+// holding count codes in n bytes, or 0 if n fits no width. That layout has
+// no width field: codes are one byte each unless k > 256, in which case they
+// are packed at quant.CodeBits(k).
+func flatCodeBits(k, count, n int) int {
+	if n == count { return 8 }
+	if k > 256 {
+		if bits := quant.CodeBits(k); n == quant.PackedLen(count, bits) { return bits }
+	}
+	return 0
+}
+
+// validCodeBits reports whether a row-aligned R shard's code width is known.
+func validCodeBits(bits int) bool { return bits == 4 || bits == 8 || bits == 12 || bits == 16 }
+
+// readCodebook decodes n little-endian f32 (or fp16) codebook values.
+func readCodebook(b []byte, n int, fp16 bool) []float32 {
+	out := make([]float32, n)
+	if fp16 {
+		for i := range out { out[i] = fp16to32(binary.LittleEndian.Uint16(b[2*i:])) }
+		return out
+	}
+	for i := range out { out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])) }
+	return out
+}
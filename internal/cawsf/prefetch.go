@@ -0,0 +1,154 @@
+package cawsf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PrefetchStats counts a Prefetcher's work. How much of it paid off is in
+// the cache's CacheStats (PrefetchHits, Hidden).
+type PrefetchStats struct {
+	Queued   uint64        // shards requested
+	Decoded  uint64        // decoded and handed to the cache
+	Skipped  uint64        // already cached or loading
+	Failed   uint64        // decode errors; callers decode those themselves
+	Canceled uint64        // dropped because the context ended first
+	Decode   time.Duration // total decode time spent in the background
+}
+
+// Prefetcher decodes shards into a Runtime's ShardCache on background
+// goroutines, so a later Multiply finds them there instead of decoding in
+// line. Requests are started in the order given, at most concurrency at a
+// time; a caller that needs a shard still in flight waits for it rather
+// than decoding it twice. It is safe for concurrent use.
+type Prefetcher struct {
+	rt     *Runtime
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	queued, decoded, skipped, failed, canceled atomic.Uint64
+	decode                                     atomic.Int64
+
+	mu  sync.Mutex
+	err error // first decode error
+}
+
+// NewPrefetcher starts a prefetcher for rt, which must have a cache.
+// concurrency <= 0 means 2. Canceling ctx stops queued work; shards being
+// decoded finish first.
+func NewPrefetcher(ctx context.Context, rt *Runtime, concurrency int) (*Prefetcher, error) {
+	if rt.cache == nil { return nil, fmt.Errorf("prefetch: runtime has no shard cache") }
+	if concurrency <= 0 { concurrency = 2 }
+	ctx, cancel := context.WithCancel(ctx)
+	return &Prefetcher{rt: rt, ctx: ctx, cancel: cancel, sem: make(chan struct{}, concurrency)}, nil
+}
+
+// Scopes queues every shard of the given scopes, e.g. the next layers in
+// execution order.
+func (p *Prefetcher) Scopes(scopes ...uint16) error {
+	var recs []BankRec
+	for _, sc := range scopes {
+		r, ok := p.rt.scopes[sc]
+		if !ok { return fmt.Errorf("prefetch: scope %d not found", sc) }
+		recs = append(recs, r...)
+	}
+	p.enqueue(recs)
+	return nil
+}
+
+// Shards queues shards by their index in the bank, which is what the
+// ROUTING section calls a shard id.
+func (p *Prefetcher) Shards(ids ...int) error {
+	recs := make([]BankRec, len(ids))
+	for i, id := range ids {
+		if id < 0 || id >= len(p.rt.records) { return fmt.Errorf("prefetch: shard %d not in bank of %d", id, len(p.rt.records)) }
+		recs[i] = p.rt.records[id]
+	}
+	p.enqueue(recs)
+	return nil
+}
+
+// enqueue starts recs in order on one feeder goroutine, which blocks on the
+// concurrency limit instead of the caller.
+func (p *Prefetcher) enqueue(recs []BankRec) {
+	if len(recs) == 0 { return }
+	p.queued.Add(uint64(len(recs)))
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for i, rec := range recs {
+			select {
+			case p.sem <- struct{}{}:
+			case <-p.ctx.Done():
+				p.canceled.Add(uint64(len(recs) - i))
+				return
+			}
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				defer func() { <-p.sem }()
+				p.load(rec)
+			}()
+		}
+	}()
+}
+
+func (p *Prefetcher) load(rec BankRec) {
+	if p.ctx.Err() != nil {
+		p.canceled.Add(1)
+		return
+	}
+	c := p.rt.cache
+	if !c.reserve(rec.Offset) {
+		p.skipped.Add(1)
+		return
+	}
+	start := time.Now()
+	sh, err := decodeShard(p.rt.bank, rec, p.rt.pool)
+	cost := time.Since(start)
+	p.decode.Add(int64(cost))
+	if err != nil {
+		c.finish(rec.Offset, rec.Hdr.Scope, nil, cost)
+		p.failed.Add(1)
+		p.mu.Lock()
+		if p.err == nil { p.err = fmt.Errorf("prefetch: shard at %d: %w", rec.Offset, err) }
+		p.mu.Unlock()
+		return
+	}
+	c.finish(rec.Offset, rec.Hdr.Scope, sh, cost)
+	p.decoded.Add(1)
+}
+
+// Wait blocks until everything queued so far is done and returns the first
+// decode error, or the context's error if it ended with work dropped.
+func (p *Prefetcher) Wait() error {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil { return p.err }
+	if p.canceled.Load() > 0 { return p.ctx.Err() }
+	return nil
+}
+
+// Close cancels queued work and waits for shards being decoded.
+func (p *Prefetcher) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Stats returns a snapshot of the counters.
+func (p *Prefetcher) Stats() PrefetchStats {
+	return PrefetchStats{
+		Queued:   p.queued.Load(),
+		Decoded:  p.decoded.Load(),
+		Skipped:  p.skipped.Load(),
+		Failed:   p.failed.Load(),
+		Canceled: p.canceled.Load(),
+		Decode:   time.Duration(p.decode.Load()),
+	}
+}
@@ -0,0 +1,122 @@
+package cawsf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrefetchScopes(t *testing.T) {
+	rows, cols := 8, 16
+	bank := multiScopeBank(t, 3, rows, cols)
+	cache := NewShardCache(1<<20, nil)
+	rt, err := NewRuntime(bank, nil, cache)
+	if err != nil { t.Fatal(err) }
+	pf, err := NewPrefetcher(context.Background(), rt, 2)
+	if err != nil { t.Fatal(err) }
+	defer pf.Close()
+	if err := pf.Scopes(1, 2); err != nil { t.Fatal(err) }
+	if err := pf.Wait(); err != nil { t.Fatal(err) }
+	// four shards (D, L, R, S) per scope
+	if ps := pf.Stats(); ps.Queued != 8 || ps.Decoded != 8 || ps.Skipped != 0 { t.Fatalf("prefetch stats %+v", ps) }
+	if st := cache.Stats(); st.Prefetched != 8 || st.Entries != 8 || st.Misses != 0 { t.Fatalf("cache stats %+v", st) }
+
+	x := randVec(cols, 2)
+	want, _, _, err := MultiplyScopeWithPool(bank, nil, 1, x)
+	if err != nil { t.Fatal(err) }
+	for i := 0; i < 2; i++ {
+		got, _, _, err := rt.Multiply(1, x)
+		if err != nil { t.Fatal(err) }
+		for j := range want {
+			if got[j] != want[j] { t.Fatalf("y[%d] = %f want %f", j, got[j], want[j]) }
+		}
+	}
+	// the second multiply is an ordinary hit
+	st := cache.Stats()
+	if st.Hits != 8 || st.Misses != 0 || st.PrefetchHits != 4 || st.PrefetchWaits != 0 || st.Hidden <= 0 { t.Fatalf("cache stats %+v", st) }
+
+	if err := pf.Scopes(1); err != nil { t.Fatal(err) }
+	pf.Wait()
+	if ps := pf.Stats(); ps.Skipped != 4 || ps.Decoded != 8 { t.Fatalf("cached scope decoded again: %+v", ps) }
+}
+
+func TestPrefetchShards(t *testing.T) {
+	bank := multiScopeBank(t, 2, 8, 16)
+	if _, err := NewPrefetcher(context.Background(), mustRuntime(t, bank, nil), 0); err == nil { t.Fatalf("prefetcher without a cache") }
+	cache := NewShardCache(1<<20, nil)
+	rt := mustRuntime(t, bank, cache)
+	pf, err := NewPrefetcher(context.Background(), rt, 1)
+	if err != nil { t.Fatal(err) }
+	defer pf.Close()
+	if err := pf.Shards(8); err == nil { t.Errorf("shard 8 of 8 accepted") }
+	if err := pf.Scopes(2); err == nil { t.Errorf("missing scope accepted") }
+	// routing ids index the bank: 0-3 are scope 0, 4-7 scope 1
+	if err := pf.Shards(0, 5); err != nil { t.Fatal(err) }
+	if err := pf.Wait(); err != nil { t.Fatal(err) }
+	if st := cache.Stats(); st.Prefetched != 2 { t.Fatalf("cache stats %+v", st) }
+	if err := rt.Warm(1); err != nil { t.Fatal(err) }
+	if st := cache.Stats(); st.Hits != 1 || st.Misses != 3 || st.PrefetchHits != 1 { t.Fatalf("cache stats %+v", st) }
+}
+
+func TestPrefetchCanceled(t *testing.T) {
+	bank := multiScopeBank(t, 3, 8, 16)
+	cache := NewShardCache(1<<20, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pf, err := NewPrefetcher(ctx, mustRuntime(t, bank, cache), 1)
+	if err != nil { t.Fatal(err) }
+	if err := pf.Scopes(0, 1, 2); err != nil { t.Fatal(err) }
+	if err := pf.Wait(); !errors.Is(err, context.Canceled) { t.Fatalf("Wait = %v", err) }
+	if ps := pf.Stats(); ps.Canceled != 12 || ps.Decoded != 0 { t.Fatalf("prefetch stats %+v", ps) }
+	if st := cache.Stats(); st.Entries != 0 { t.Fatalf("cache stats %+v", st) }
+}
+
+func TestPrefetchEvictedUnused(t *testing.T) {
+	bank := multiScopeBank(t, 2, 8, 16)
+	big := NewShardCache(1<<20, nil)
+	if err := mustRuntime(t, bank, big).Warm(0); err != nil { t.Fatal(err) }
+	// room for one scope: prefetching two in order evicts unused shards of
+	// the first
+	cache := NewShardCache(big.Stats().Bytes, nil)
+	pf, err := NewPrefetcher(context.Background(), mustRuntime(t, bank, cache), 1)
+	if err != nil { t.Fatal(err) }
+	defer pf.Close()
+	pf.Scopes(0, 1)
+	if err := pf.Wait(); err != nil { t.Fatal(err) }
+	if st := cache.Stats(); st.PrefetchUnused == 0 || st.Evictions != st.PrefetchUnused { t.Fatalf("cache stats %+v", st) }
+}
+
+func TestCacheWaitsForPrefetch(t *testing.T) {
+	c := NewShardCache(1<<20, nil)
+	sh := &denseFP16{rows: 1, cols: 1, data: []byte{0, 0x3c}}
+	for _, fail := range []bool{false, true} {
+		key := 1
+		if fail { key = 2 }
+		if !c.reserve(key) { t.Fatalf("key %d not reserved", key) }
+		if c.reserve(key) { t.Fatalf("key %d reserved twice", key) }
+		got := make(chan bool)
+		go func() {
+			s, ok := c.get(key)
+			got <- ok && s == shard(sh)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		if fail {
+			c.finish(key, 0, nil, 0)
+		} else {
+			c.finish(key, 0, sh, time.Second)
+		}
+		if ok := <-got; ok == fail { t.Fatalf("fail=%v: get found the shard: %v", fail, ok) }
+	}
+	st := c.Stats()
+	if st.Hits != 1 || st.Misses != 1 || st.Prefetched != 1 || st.PrefetchHits != 1 { t.Fatalf("cache stats %+v", st) }
+	// a caller that waited only had part of the decode hidden
+	if st.PrefetchWaits == 1 && (st.Waited <= 0 || st.Hidden >= time.Second) { t.Fatalf("cache stats %+v", st) }
+}
+
+func mustRuntime(t *testing.T, bank []byte, cache *ShardCache) *Runtime {
+	t.Helper()
+	rt, err := NewRuntime(bank, nil, cache)
+	if err != nil { t.Fatal(err) }
+	return rt
+}
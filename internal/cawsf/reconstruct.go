@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/qrv0/crow/internal/quant"
 )
 
 // constants for shard types
@@ -13,10 +14,14 @@ const (
 	shR = 1
 	shS = 2
 	shD = 3
+	shRRow = 4 // R, row-aligned PQ blocks
+	shSCSR = 5 // S, CSR with varint columns and 16-bit values
+	shRRVQ = 6 // R, row-aligned residual PQ
+	shRInt = 7 // R, group-wise int4/int8
 )
 
 type ShardHeader struct {
-	Type  uint8  // 0=L,1=R,2=S,3=D
+	Type  uint8  // 0=L,1=R,2=S,3=D,4=R(row-aligned),5=S(CSR),6=R(residual PQ),7=R(int)
 	Scope uint16
 	Comp  uint8  // 0=raw
 	Usize uint32
@@ -29,13 +34,15 @@ type BankIndex struct {
 
 // CodebookPool holds shared PQ codebooks referenced by R shards.
 // Layout of CODEBOOKS section (little endian):
-// u16 count; then repeated: u16 id; u16 d; u16 m; u16 k; u32 size; bytes (m*k*(d/m)) float32
+// u16 count; then repeated: u16 id; u16 d; u16 m; u16 k; u32 size; bytes (m*k*(d/m)) float32 or fp16.
+// An entry is fp16 when size is 2*m*k*(d/m).
 type CodebookEntry struct {
 	ID uint16
 	D  int
 	M  int
 	K  int
-	Data []float32 // flattened [m][k][dsub]
+	FP16 bool      // stored as fp16 in the file
+	Data []float32 // flattened [m][k][dsub], always widened to f32
 }
 
 type CodebookPool struct {
@@ -59,13 +66,11 @@ func ParseCodebookPool(b []byte) (*CodebookPool, error) {
 		if off+size > len(b) { return nil, fmt.Errorf("codebooks: short data") }
 		dataBytes := b[off:off+size]
 		off += size
-		// convert to float32 slice
-		if size%4 != 0 { return nil, fmt.Errorf("codebooks: size not multiple of 4") }
-		vals := make([]float32, size/4)
-		for j := 0; j < len(vals); j++ {
-			vals[j] = math.Float32frombits(binary.LittleEndian.Uint32(dataBytes[j*4:]))
-		}
-		pool.Entries[id] = CodebookEntry{ID: id, D: d, M: m, K: k, Data: vals}
+		half := size != 0 && size == 2*k*d
+		if !half && size%4 != 0 { return nil, fmt.Errorf("codebooks: size not multiple of 4") }
+		n := size / 4
+		if half { n = size / 2 }
+		pool.Entries[id] = CodebookEntry{ID: id, D: d, M: m, K: k, FP16: half, Data: readCodebook(dataBytes, n, half)}
 	}
 	return pool, nil
 }
@@ -100,109 +105,28 @@ func ReconstructForScope(bank []byte, scope uint16) (rows int, cols int, data []
 	return ReconstructForScopeWithPool(bank, nil, scope)
 }
 
+// ReconstructForScopeWithPool is ReconstructForScope with shared codebooks;
+// like MultiplyScopeWithPool it decodes without caching.
 func ReconstructForScopeWithPool(bank []byte, pool *CodebookPool, scope uint16) (rows int, cols int, data []float32, err error) {
-	idx, err := IndexShardBank(bank)
+	rt, err := NewRuntime(bank, pool, nil)
 	if err != nil { return }
-	var L, D []float32
-	var R [][]float32
-	var shapeRows, shapeCols int
-	var Sind [][2]int32
-	var Sval []float32
-	for _, rec := range idx.Records {
-		if rec.Hdr.Scope != scope { continue }
-		payloadRaw := bank[rec.Offset: rec.Offset+int(rec.Hdr.Csize)]
-		payload, derr := decompressShard(rec.Hdr.Comp, payloadRaw)
-		if derr != nil { return 0,0,nil, derr }
-		switch rec.Hdr.Type {
-		case 0: // L
-			rows, cols, mat, e := readFP16WithShape(payload)
-			if e != nil { return 0,0,nil,e }
-			shapeRows, shapeCols = rows, cols
-			L = mat
-		case 3: // D
-			rows, cols, mat, e := readFP16WithShape(payload)
-			if e != nil { return 0,0,nil,e }
-			shapeRows, shapeCols = rows, cols
-			D = mat
-		case 1: // R PQ
-			rows, cols, mat, e := decodeR(payload)
-			if e != nil {
-				// try shared codebook path if pool provided
-				if pool != nil {
-					mat2, e2 := decodeRWithPool(rows, cols, payload, pool)
-					if e2 != nil { return 0,0,nil,e2 }
-					shapeRows, shapeCols = rows, cols
-					R = append(R, mat2)
-					break
-				}
-				return 0,0,nil,e
-			}
-			shapeRows, shapeCols = rows, cols
-			R = append(R, mat)
-		case 2: // S
-			r, c, sind, sval, e := decodeS(payload)
-			if e != nil { return 0,0,nil,e }
-			shapeRows, shapeCols = r, c
-			Sind, Sval = sind, sval
-		}
-	}
-	if shapeRows == 0 || shapeCols == 0 { return 0,0,nil, fmt.Errorf("scope %d not found", scope) }
-	data = make([]float32, shapeRows*shapeCols)
-	if L != nil { addInPlace(data, L) }
-	if D != nil { addInPlace(data, D) }
-	for _, rr := range R { addInPlace(data, rr) }
-	if len(Sind) > 0 {
-		for i, ij := range Sind {
-			r := int(ij[0]); c := int(ij[1])
-			data[r*shapeCols + c] += Sval[i]
-		}
-	}
-	return shapeRows, shapeCols, data, nil
+	return rt.Reconstruct(scope)
 }
 
 func addInPlace(dst, src []float32) {
 	for i := range src { dst[i] += src[i] }
 }
 
-func readFP16WithShape(p []byte) (rows, cols int, out []float32, err error) {
-	if len(p) < 8 { return 0,0,nil, fmt.Errorf("short fp16 payload") }
-	rows = int(binary.LittleEndian.Uint32(p[0:4]))
-	cols = int(binary.LittleEndian.Uint32(p[4:8]))
-	n := rows*cols
-	out = make([]float32, n)
-	off := 8
-	for i := 0; i < n; i++ {
-		if off+2 > len(p) { return 0,0,nil, fmt.Errorf("short fp16 data") }
-		h := uint16(p[off]) | uint16(p[off+1])<<8
-		off += 2
-		out[i] = fp16to32(h)
-	}
-	return
-}
+// fp16Table maps every half-precision bit pattern to its float32 value, so
+// kernels convert with one load instead of quant.FP16ToFloat32's branches.
+var fp16Table [1 << 16]float32
 
-func fp16to32(h uint16) float32 {
-	s := uint32(h>>15) & 0x1
-	e := uint32(h>>10) & 0x1F
-	m := uint32(h) & 0x3FF
-	var f uint32
-	if e == 0 {
-		if m == 0 { f = s << 31 } else {
-			// subnormal -> normalize approximately
-			e2 := uint32(127 - 15 + 1)
-			m2 := uint32(m) << 13
-			for (m2 & (1<<23)) == 0 { m2 <<= 1; e2-- }
-			m2 &= (1<<23)-1
-	f = (s<<31) | (e2<<23) | m2
-	}
-	} else if e == 0x1F {
-		f = (s<<31) | (0xFF<<23) | (m<<13)
-	} else {
-		e2 := (e) - 15 + 127
-		f = (s<<31) | (e2<<23) | (m<<13)
-	}
-	return math.Float32frombits(f)
+func init() {
+	for i := range fp16Table { fp16Table[i] = quant.FP16ToFloat32(uint16(i)) }
 }
 
+func fp16to32(h uint16) float32 { return fp16Table[h] }
+
 func decodeR(p []byte) (rows, cols int, mat []float32, err error) {
 	// Two possible layouts:
 	// A) Embedded codebooks: rows:u32, cols:u32, d:u16, m:u16, k:u16, n:u32, cb:(m*k*dsub*f32), codes:(n*m*u8)
@@ -220,8 +144,8 @@ func decodeR(p []byte) (rows, cols int, mat []float32, err error) {
 	k := int(binary.LittleEndian.Uint16(p[12:14]))
 	n := int(binary.LittleEndian.Uint32(p[14:18]))
 	dsub := d/m
-	// Check if shared codebook layout by seeing if remaining length matches (2 + n*m)
-	if len(p) >= 18+2 && (len(p)-(18+2)) == n*m {
+	// Check if shared codebook layout by seeing if remaining length matches 2 + codes
+	if len(p) >= 18+2 && flatCodeBits(k, n*m, len(p)-(18+2)) != 0 {
 		cbID := int(binary.LittleEndian.Uint16(p[18:20]))
 		_ = p[20:]
 		// Without external codebook pool here, signal to caller to use pool path by returning error
@@ -232,12 +156,13 @@ func decodeR(p []byte) (rows, cols int, mat []float32, err error) {
 	if 18+cbSize > len(p) { return 0,0,nil, fmt.Errorf("short codebooks") }
 	cb := p[18:18+cbSize]
 	codes := p[18+cbSize:]
-	if len(codes) != n*m { return 0,0,nil, fmt.Errorf("codes size mismatch") }
+	bits := flatCodeBits(k, n*m, len(codes))
+	if bits == 0 { return 0,0,nil, fmt.Errorf("codes size mismatch") }
 	blocks := make([]float32, n*d)
 	// decode per sub-vector
 	for i := 0; i < m; i++ {
 		for r := 0; r < n; r++ {
-			idx := int(codes[r*m + i])
+			idx := quant.Code(codes, bits, r*m + i)
 			start := (i*k + idx)*dsub*4
 			for j := 0; j < dsub; j++ {
 				off := start + j*4
@@ -253,28 +178,6 @@ func decodeR(p []byte) (rows, cols int, mat []float32, err error) {
 	return
 }
 
-func decodeS(p []byte) (rows, cols int, idx [][2]int32, vals []float32, err error) {
-	if len(p) < 12 { return 0,0,nil,nil, fmt.Errorf("short S payload") }
-	rows = int(binary.LittleEndian.Uint32(p[0:4]))
-	cols = int(binary.LittleEndian.Uint32(p[4:8]))
-	n := int(binary.LittleEndian.Uint32(p[8:12]))
-	pos := 12
-	idx = make([][2]int32, n)
-	for i := 0; i < n; i++ {
-		if pos+8 > len(p) { return 0,0,nil,nil, fmt.Errorf("short S indices") }
-		idx[i][0] = int32(binary.LittleEndian.Uint32(p[pos:pos+4]))
-		idx[i][1] = int32(binary.LittleEndian.Uint32(p[pos+4:pos+8]))
-		pos += 8
-	}
-	vals = make([]float32, n)
-	for i := 0; i < n; i++ {
-		if pos+4 > len(p) { return 0,0,nil,nil, fmt.Errorf("short S values") }
-		vals[i] = math.Float32frombits(binary.LittleEndian.Uint32(p[pos:pos+4]))
-		pos += 4
-	}
-	return
-}
-
 func decodeRWithPool(rows, cols int, p []byte, pool *CodebookPool) ([]float32, error) {
 	if len(p) < 20 { return nil, fmt.Errorf("short R payload (pool)") }
 	d := int(binary.LittleEndian.Uint16(p[8:10]))
@@ -283,7 +186,8 @@ func decodeRWithPool(rows, cols int, p []byte, pool *CodebookPool) ([]float32, e
 	n := int(binary.LittleEndian.Uint32(p[14:18]))
 	cbID := binary.LittleEndian.Uint16(p[18:20])
 	codes := p[20:]
-	if len(codes) != n*m { return nil, fmt.Errorf("codes size mismatch") }
+	bits := flatCodeBits(k, n*m, len(codes))
+	if bits == 0 { return nil, fmt.Errorf("codes size mismatch") }
 	entry, ok := pool.Entries[cbID]
 	if !ok { return nil, fmt.Errorf("codebook id %d not found", cbID) }
 	if entry.M != 0 && entry.M != m { return nil, fmt.Errorf("codebook m mismatch: %d vs %d", entry.M, m) }
@@ -295,7 +199,7 @@ func decodeRWithPool(rows, cols int, p []byte, pool *CodebookPool) ([]float32, e
 	blocks := make([]float32, n*d)
 	for i := 0; i < m; i++ {
 		for r := 0; r < n; r++ {
-			idx := int(codes[r*m + i])
+			idx := quant.Code(codes, bits, r*m + i)
 			start := (i*k + idx)*dsub
 			for j := 0; j < dsub; j++ {
 				blocks[r*d + i*dsub + j] = entry.Data[start + j]
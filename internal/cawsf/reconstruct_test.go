@@ -5,6 +5,8 @@ import (
     "encoding/binary"
     "math"
     "testing"
+
+    "github.com/qrv0/crow/internal/quant"
 )
 
 // helper to build fp16 payload with shape
@@ -16,24 +18,9 @@ func f16Payload(rows, cols int, f32 []float32) []byte {
     return buf.Bytes()
 }
 
-// local helper: same as convert.fp32to16
+// fp32to16 encodes f as convert writes fp16 weights.
 func fp32to16(f float32) []byte {
-    u := math.Float32bits(f)
-    sign := (u >> 31) & 1
-    exp := (u >> 23) & 0xFF
-    mant := u & 0x7FFFFF
-    var h uint16
-    if exp == 0 {
-        h = uint16(sign << 15)
-    } else if exp == 0xFF {
-        h = uint16((sign<<15) | (0x1F<<10))
-    } else {
-        e := int(exp) - 127 + 15
-        if e <= 0 { h = uint16(sign<<15) } else if e >= 0x1F { h = uint16((sign<<15)|(0x1F<<10)) } else {
-            h = uint16((sign<<15) | (uint32(e)&0x1F)<<10 | ((mant>>13)&0x3FF))
-        }
-    }
-    return []byte{byte(h), byte(h >> 8)}
+    return binary.LittleEndian.AppendUint16(nil, quant.FP16FromFloat32(f))
 }
 
 func pack(t uint8, scope uint16, payload []byte) []byte {
@@ -0,0 +1,105 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Group-wise scalar quantized R shard (type 7):
+//
+//	rows:u32 cols:u32 bits:u8 flags:u8 group:u16
+//	scales:[rows][ceil(cols/group)]f16
+//	zeros:[rows][ceil(cols/group)]u8 if flags&rIntZeroPoint
+//	q:[rows][ceil(cols*bits/8)]u8, int4 packed low nibble first
+//
+// Without zero-points q is signed and w = scale*q; with them q is unsigned and
+// w = scale*(q-zero).
+const rIntHeader = 12
+
+const rIntZeroPoint = 1 << 0
+
+type intR struct {
+	rows, cols    int
+	bits, group   int
+	ng, rowBytes  int
+	zp            bool
+	scales, zeros []byte
+	q             []byte
+}
+
+func parseIntR(p []byte) (intR, error) {
+	var r intR
+	if len(p) < rIntHeader { return r, fmt.Errorf("short R payload") }
+	r.rows = int(binary.LittleEndian.Uint32(p[0:4]))
+	r.cols = int(binary.LittleEndian.Uint32(p[4:8]))
+	r.bits = int(p[8])
+	r.zp = p[9]&rIntZeroPoint != 0
+	r.group = int(binary.LittleEndian.Uint16(p[10:12]))
+	if r.bits != 4 && r.bits != 8 { return r, fmt.Errorf("unsupported int R width %d", r.bits) }
+	if r.group == 0 { return r, fmt.Errorf("int R group is 0") }
+	r.ng = (r.cols + r.group - 1) / r.group
+	r.rowBytes = (r.cols*r.bits + 7) / 8
+	nz := 0
+	if r.zp { nz = r.rows * r.ng }
+	off := rIntHeader
+	if len(p) != off+2*r.rows*r.ng+nz+r.rows*r.rowBytes { return r, fmt.Errorf("int R payload size mismatch") }
+	r.scales = p[off : off+2*r.rows*r.ng]
+	off += 2 * r.rows * r.ng
+	r.zeros = p[off : off+nz]
+	r.q = p[off+nz:]
+	return r, nil
+}
+
+// qv returns the stored integer for (row, col), sign-extended unless the
+// shard uses zero-points.
+func (r *intR) qv(row []byte, c int) float32 {
+	if r.bits == 8 {
+		if r.zp { return float32(row[c]) }
+		return float32(int8(row[c]))
+	}
+	n := row[c/2] >> (4 * (c & 1)) & 0xF
+	if r.zp { return float32(n) }
+	return float32(int8(n<<4) >> 4)
+}
+
+// matVecAdd accumulates y += R*x without dequantizing: each group's integer
+// dot product is scaled once, and zero-points subtract zero*sum(x_group).
+func (r *intR) matVecAdd(y, x []float32) {
+	var xsum []float32
+	if r.zp {
+		xsum = make([]float32, r.ng)
+		for c, v := range x[:r.cols] { xsum[c/r.group] += v }
+	}
+	parallelRows(r.rows, r.rows*r.cols, func(lo, hi int) {
+		for row := lo; row < hi; row++ {
+			qrow := r.q[row*r.rowBytes : (row+1)*r.rowBytes]
+			s := float32(0)
+			for g := 0; g < r.ng; g++ {
+				c0, c1 := g*r.group, min((g+1)*r.group, r.cols)
+				acc := float32(0)
+				for c := c0; c < c1; c++ { acc += r.qv(qrow, c) * x[c] }
+				i := row*r.ng + g
+				if r.zp { acc -= float32(r.zeros[i]) * xsum[g] }
+				s += fp16to32(binary.LittleEndian.Uint16(r.scales[2*i:])) * acc
+			}
+			y[row] += s
+		}
+	})
+}
+
+// addTo accumulates the dequantized residue into a dense row-major matrix.
+func (r *intR) addTo(dst []float32) { r.addRows(0, r.rows, dst) }
+
+// addRows accumulates rows [r0, r1) into dst, which holds just those rows.
+func (r *intR) addRows(r0, r1 int, dst []float32) {
+	for row := r0; row < r1; row++ {
+		qrow := r.q[row*r.rowBytes : (row+1)*r.rowBytes]
+		out := dst[(row-r0)*r.cols : (row-r0+1)*r.cols]
+		for c := range out {
+			i := row*r.ng + c/r.group
+			v := r.qv(qrow, c)
+			if r.zp { v -= float32(r.zeros[i]) }
+			out[c] += fp16to32(binary.LittleEndian.Uint16(r.scales[2*i:])) * v
+		}
+	}
+}
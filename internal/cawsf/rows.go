@@ -0,0 +1,178 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// rowRange is the half-open row interval [lo, hi).
+type rowRange struct{ lo, hi int }
+
+// ReconstructRange returns rows [r0, r1) of scope's dense matrix, row-major;
+// see ReconstructRows for what gets decoded.
+func (rt *Runtime) ReconstructRange(scope uint16, r0, r1 int) (cols int, data []float32, err error) {
+	shards, err := rt.shards(scope)
+	if err != nil { return 0, nil, err }
+	rows, cols, err := scopeShape(scope, shards)
+	if err != nil { return 0, nil, err }
+	if r0 < 0 || r1 > rows || r0 > r1 { return 0, nil, fmt.Errorf("scope %d: rows %d:%d out of range [0, %d)", scope, r0, r1, rows) }
+	data = make([]float32, (r1-r0)*cols)
+	if r0 == r1 { return cols, data, nil }
+	for _, sh := range shards {
+		if err := sh.accumulateRanges([]rowRange{{r0, r1}}, data); err != nil { return 0, nil, err }
+	}
+	return cols, data, nil
+}
+
+// ReconstructRows returns the listed rows of scope's dense matrix in the
+// order given, repeats allowed: len(rows) x cols, row-major. L, D and the
+// row-aligned R layouts decode only the requested rows, CSR S skips to them
+// and sorted triplet S searches for them; legacy flat R and unsorted
+// triplets have no row index and are decoded whole.
+func (rt *Runtime) ReconstructRows(scope uint16, rows []int) (cols int, data []float32, err error) {
+	shards, err := rt.shards(scope)
+	if err != nil { return 0, nil, err }
+	nrows, cols, err := scopeShape(scope, shards)
+	if err != nil { return 0, nil, err }
+	uniq := make([]int, len(rows))
+	copy(uniq, rows)
+	sort.Ints(uniq)
+	n := 0
+	for _, r := range uniq {
+		if r < 0 || r >= nrows { return 0, nil, fmt.Errorf("scope %d: row %d out of range [0, %d)", scope, r, nrows) }
+		if n == 0 || uniq[n-1] != r { uniq[n] = r; n++ }
+	}
+	uniq = uniq[:n]
+	var ranges []rowRange
+	for _, r := range uniq {
+		if k := len(ranges) - 1; k >= 0 && ranges[k].hi == r {
+			ranges[k].hi++
+			continue
+		}
+		ranges = append(ranges, rowRange{r, r + 1})
+	}
+	buf := make([]float32, len(uniq)*cols)
+	for _, sh := range shards {
+		if err := sh.accumulateRanges(ranges, buf); err != nil { return 0, nil, err }
+	}
+	if len(uniq) == len(rows) && sort.IntsAreSorted(rows) { return cols, buf, nil }
+	data = make([]float32, len(rows)*cols)
+	for i, r := range rows {
+		j := sort.SearchInts(uniq, r)
+		copy(data[i*cols:(i+1)*cols], buf[j*cols:(j+1)*cols])
+	}
+	return cols, data, nil
+}
+
+// Embed looks token ids up in an embedding scope (vocab x hidden) and
+// returns len(ids) x hidden vectors, row-major.
+func (rt *Runtime) Embed(scope uint16, ids []int) ([]float32, error) {
+	_, data, err := rt.ReconstructRows(scope, ids)
+	if err != nil { return nil, fmt.Errorf("embed: %w", err) }
+	return data, nil
+}
+
+// eachRange calls fn for every range with the slice of dst that holds its
+// rows; dst packs the ranges one after another.
+func eachRange(ranges []rowRange, cols int, dst []float32, fn func(lo, hi int, out []float32)) {
+	off := 0
+	for _, rg := range ranges {
+		n := (rg.hi - rg.lo) * cols
+		fn(rg.lo, rg.hi, dst[off:off+n])
+		off += n
+	}
+}
+
+func (d *denseFP16) accumulateRanges(ranges []rowRange, dst []float32) error {
+	eachRange(ranges, d.cols, dst, d.addRows)
+	return nil
+}
+
+// accumulateRanges on the legacy flat layout decodes the whole shard once;
+// its codes are not grouped by row.
+func (r *flatR) accumulateRanges(ranges []rowRange, dst []float32) error {
+	full := make([]float32, r.rows*r.cols)
+	if err := r.accumulate(full); err != nil { return err }
+	eachRange(ranges, r.cols, dst, func(lo, hi int, out []float32) { addInPlace(out, full[lo*r.cols:hi*r.cols]) })
+	return nil
+}
+
+func (r *rowPQ) accumulateRanges(ranges []rowRange, dst []float32) error {
+	eachRange(ranges, r.cols, dst, r.addRows)
+	return nil
+}
+
+func (s rvqShard) accumulateRanges(ranges []rowRange, dst []float32) error {
+	for _, st := range s { eachRange(ranges, st.cols, dst, st.addRows) }
+	return nil
+}
+
+func (r *intR) accumulateRanges(ranges []rowRange, dst []float32) error {
+	eachRange(ranges, r.cols, dst, r.addRows)
+	return nil
+}
+
+// accumulateRanges binary-searches each range's entries when the shard is
+// sorted by row, else scans every entry once.
+func (s *tripletS) accumulateRanges(ranges []rowRange, dst []float32) error {
+	idx, vals := s.payload[12:12+8*s.n], s.payload[12+8*s.n:]
+	add := func(e, lo int, out []float32) error {
+		r := s.row(e)
+		c := int(int32(binary.LittleEndian.Uint32(idx[8*e+4:])))
+		if c < 0 || c >= s.cols { return fmt.Errorf("S entry (%d,%d) out of range", r, c) }
+		out[(r-lo)*s.cols+c] += math.Float32frombits(binary.LittleEndian.Uint32(vals[4*e:]))
+		return nil
+	}
+	if !s.sorted {
+		// row -> (range start, offset into dst) for the rows asked for
+		type slot struct{ lo, off int }
+		want := map[int]slot{}
+		off := 0
+		for _, rg := range ranges {
+			for r := rg.lo; r < rg.hi; r++ { want[r] = slot{rg.lo, off} }
+			off += (rg.hi - rg.lo) * s.cols
+		}
+		for e := 0; e < s.n; e++ {
+			sl, ok := want[s.row(e)]
+			if !ok { continue }
+			if err := add(e, sl.lo, dst[sl.off:]); err != nil { return err }
+		}
+		return nil
+	}
+	var err error
+	eachRange(ranges, s.cols, dst, func(lo, hi int, out []float32) {
+		e := sort.Search(s.n, func(i int) bool { return s.row(i) >= lo })
+		for ; e < s.n && err == nil && s.row(e) < hi; e++ { err = add(e, lo, out) }
+	})
+	return err
+}
+
+// accumulateRanges walks the column varints forward once, skipping the
+// entries of rows that were not asked for.
+func (s *csrS) accumulateRanges(ranges []rowRange, dst []float32) error {
+	entry, p, off := 0, 0, 0
+	for _, rg := range ranges {
+		for target := int(binary.LittleEndian.Uint32(s.rowptr[4*rg.lo:])); entry < target; entry++ {
+			for p < len(s.colIdx) && s.colIdx[p] >= 0x80 { p++ }
+			if p >= len(s.colIdx) { return fmt.Errorf("bad S column index") }
+			p++
+		}
+		for r := rg.lo; r < rg.hi; r++ {
+			end := int(binary.LittleEndian.Uint32(s.rowptr[4*(r+1):]))
+			out := dst[off+(r-rg.lo)*s.cols : off+(r-rg.lo+1)*s.cols]
+			c := 0
+			for ; entry < end; entry++ {
+				delta, n := binary.Uvarint(s.colIdx[p:])
+				if n <= 0 { return fmt.Errorf("bad S column index") }
+				p += n
+				c += int(delta)
+				if c >= s.cols { return fmt.Errorf("S column %d out of range", c) }
+				out[c] += s.val(entry)
+			}
+		}
+		off += (rg.hi - rg.lo) * s.cols
+	}
+	return nil
+}
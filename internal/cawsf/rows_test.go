@@ -0,0 +1,94 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/qrv0/crow/internal/convert"
+)
+
+// checkRowsMatchReconstruct compares ReconstructRows and ReconstructRange
+// on scope 0 of bank with slices of the full reconstruction.
+func checkRowsMatchReconstruct(t *testing.T, name string, bank []byte, pool *CodebookPool) {
+	t.Helper()
+	rt, err := NewRuntime(bank, pool, nil)
+	if err != nil { t.Fatal(err) }
+	rows, cols, full, err := rt.Reconstruct(0)
+	if err != nil { t.Fatalf("%s: %v", name, err) }
+	// unsorted, repeated and adjacent rows, plus the last one
+	for _, want := range [][]int{{rows - 1, 0, 3, 3, 1, 2}, {2}, {}} {
+		c, got, err := rt.ReconstructRows(0, want)
+		if err != nil { t.Fatalf("%s rows %v: %v", name, want, err) }
+		if c != cols || len(got) != len(want)*cols { t.Fatalf("%s rows %v: %d values of width %d", name, want, len(got), c) }
+		for i, r := range want {
+			for j := 0; j < cols; j++ {
+				if got[i*cols+j] != full[r*cols+j] { t.Fatalf("%s rows %v: [%d][%d] = %v, want %v", name, want, i, j, got[i*cols+j], full[r*cols+j]) }
+			}
+		}
+	}
+	_, got, err := rt.ReconstructRange(0, 1, rows-1)
+	if err != nil { t.Fatalf("%s range: %v", name, err) }
+	for i, v := range got {
+		if v != full[cols+i] { t.Fatalf("%s range: [%d] = %v, want %v", name, i, v, full[cols+i]) }
+	}
+	if _, _, err := rt.ReconstructRows(0, []int{rows}); err == nil { t.Errorf("%s: row %d accepted", name, rows) }
+	if _, _, err := rt.ReconstructRange(0, 2, 1); err == nil { t.Errorf("%s: range 2:1 accepted", name) }
+}
+
+func TestReconstructRowsMatchesReconstruct(t *testing.T) {
+	rows, cols := 24, 37
+	for _, c := range []struct {
+		name string
+		cfg  convert.Config
+	}{
+		{"row pq, csr", convert.Config{Rank: 2, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16}},
+		{"flat pq, triplets", convert.Config{Rank: 2, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16, RLayout: convert.LayoutFlat, SEncoding: convert.SEncTriplet}},
+		{"rotated", convert.Config{Rank: 1, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16, Rotation: convert.RotationOPQ}},
+		{"rvq", convert.Config{Rank: 1, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 8, Codec: convert.CodecRVQ, RVQStages: 2}},
+		{"int4", convert.Config{Rank: 1, OutlierQuantile: 0.9, Codec: convert.CodecInt4, IntGroup: 8, IntZeroPoint: true}},
+		{"raw", convert.Config{StoreRaw: true}},
+	} {
+		bank, _ := convertBank(t, rows, cols, c.cfg)
+		checkRowsMatchReconstruct(t, c.name, bank, nil)
+	}
+}
+
+func TestReconstructRowsUnsortedTriplets(t *testing.T) {
+	rows, cols := 6, 5
+	type entry struct {
+		r, c int
+		v    float32
+	}
+	// rows out of order, so the shard cannot be searched by row
+	ents := []entry{{4, 1, 1.5}, {0, 0, -2}, {3, 4, 0.25}, {0, 3, 7}, {5, 2, -1}}
+	p := make([]byte, 12+12*len(ents))
+	binary.LittleEndian.PutUint32(p[0:], uint32(rows))
+	binary.LittleEndian.PutUint32(p[4:], uint32(cols))
+	binary.LittleEndian.PutUint32(p[8:], uint32(len(ents)))
+	for i, e := range ents {
+		binary.LittleEndian.PutUint32(p[12+8*i:], uint32(e.r))
+		binary.LittleEndian.PutUint32(p[16+8*i:], uint32(e.c))
+		binary.LittleEndian.PutUint32(p[12+8*len(ents)+4*i:], math.Float32bits(e.v))
+	}
+	if s, err := parseTripletS(p); err != nil || s.sorted { t.Fatalf("parse: sorted %v, %v", s != nil && s.sorted, err) }
+	bank := append(pack(shL, 0, f16Payload(rows, cols, randVec(rows*cols, 1))), pack(shS, 0, p)...)
+	checkRowsMatchReconstruct(t, "unsorted", bank, nil)
+}
+
+func TestEmbed(t *testing.T) {
+	rows, cols := 16, 8
+	bank, _ := convertBank(t, rows, cols, convert.Config{StoreRaw: true})
+	rt, err := NewRuntime(bank, nil, nil)
+	if err != nil { t.Fatal(err) }
+	_, _, full, _ := rt.Reconstruct(0)
+	ids := []int{5, 0, 5}
+	got, err := rt.Embed(0, ids)
+	if err != nil { t.Fatal(err) }
+	for i, id := range ids {
+		for j := 0; j < cols; j++ {
+			if got[i*cols+j] != full[id*cols+j] { t.Fatalf("token %d dim %d = %v, want %v", id, j, got[i*cols+j], full[id*cols+j]) }
+		}
+	}
+	if _, err := rt.Embed(0, []int{rows}); err == nil { t.Errorf("id %d accepted", rows) }
+}
@@ -0,0 +1,234 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// Row-aligned PQ R shard (type 4). Sub-vectors of d columns tile each row and
+// the last block of a row is zero-padded, so a code's (row, col) follows from
+// its position without a div/mod per element:
+//
+//	rows:u32 cols:u32 d:u16 m:u16 k:u16 bits:u8 flags:u8
+//	flags&rFlagRotation: rotation (see quant.ParseRotation)
+//	flags&rFlagSharedCB: cb_id:u16, else cb:[m*k*dsub]f32 (f16 if rFlagCBFP16)
+//	codes:[rows][ceil(cols/d)][m], packed bits wide (4, 8, 12 or 16)
+const rRowHeader = 16
+
+const (
+	rFlagSharedCB = 1 << 0
+	rFlagCBFP16   = 1 << 1 // embedded codebook only; CODEBOOKS entries carry their own width
+	rFlagRotation = 1 << 2 // codewords live in a rotated space, see rowPQ.rot
+)
+
+type rowPQ struct {
+	rows, cols int
+	d, m, k    int
+	dsub, bpr  int
+	bits       int
+	cb         []float32 // [m][k][dsub]
+	codes      []byte
+	rot        *quant.Rotation // codewords quantize rot*w per block; nil = none
+	rotBuf     *sync.Pool      // *[]float32 for rotateInput, set with rot
+	sharedCB   bool            // cb belongs to a CodebookPool entry
+}
+
+func parseRowPQ(p []byte, pool *CodebookPool) (*rowPQ, error) {
+	if len(p) < rRowHeader { return nil, fmt.Errorf("short R payload") }
+	r := &rowPQ{
+		rows: int(binary.LittleEndian.Uint32(p[0:4])),
+		cols: int(binary.LittleEndian.Uint32(p[4:8])),
+		d:    int(binary.LittleEndian.Uint16(p[8:10])),
+		m:    int(binary.LittleEndian.Uint16(p[10:12])),
+		k:    int(binary.LittleEndian.Uint16(p[12:14])),
+		bits: int(p[14]),
+	}
+	flags := p[15]
+	if r.d == 0 || r.m == 0 || r.d%r.m != 0 { return nil, fmt.Errorf("bad R block shape d=%d m=%d", r.d, r.m) }
+	if !validCodeBits(r.bits) { return nil, fmt.Errorf("unsupported R code width %d", r.bits) }
+	r.dsub = r.d / r.m
+	r.bpr = (r.cols + r.d - 1) / r.d
+	off := rRowHeader
+	if flags&rFlagRotation != 0 {
+		rot, n, err := quant.ParseRotation(p[off:], r.d)
+		if err != nil { return nil, err }
+		r.rot = rot
+		r.rotBuf = &sync.Pool{New: func() any { b := make([]float32, r.bpr*r.d+r.d); return &b }}
+		off += n
+	}
+	if flags&rFlagSharedCB != 0 {
+		if pool == nil { return nil, fmt.Errorf("shared codebooks referenced but pool is nil") }
+		if len(p) < off+2 { return nil, fmt.Errorf("short R payload") }
+		cbID := binary.LittleEndian.Uint16(p[off : off+2])
+		off += 2
+		entry, ok := pool.Entries[cbID]
+		if !ok { return nil, fmt.Errorf("codebook id %d not found", cbID) }
+		if entry.M != 0 && entry.M != r.m { return nil, fmt.Errorf("codebook m mismatch: %d vs %d", entry.M, r.m) }
+		if entry.K != 0 && entry.K != r.k { return nil, fmt.Errorf("codebook k mismatch: %d vs %d", entry.K, r.k) }
+		if len(entry.Data) < r.m*r.k*r.dsub { return nil, fmt.Errorf("codebook %d too small", cbID) }
+		r.cb = entry.Data
+		r.sharedCB = true
+	} else {
+		n := r.m * r.k * r.dsub
+		elem := 4
+		if flags&rFlagCBFP16 != 0 { elem = 2 }
+		if off+elem*n > len(p) { return nil, fmt.Errorf("short codebooks") }
+		r.cb = readCodebook(p[off:], n, elem == 2)
+		off += elem * n
+	}
+	r.codes = p[off:]
+	if len(r.codes) != quant.PackedLen(r.rows*r.bpr*r.m, r.bits) { return nil, fmt.Errorf("codes size mismatch") }
+	return r, nil
+}
+
+// rotateInput zero-pads x to whole blocks and rotates each block, so that
+// full d-wide codewords dot against it without un-rotating any weights.
+// The result lives in buf, taken from rotBuf; hand it back when done.
+func (r *rowPQ) rotateInput(x []float32) (xr []float32, buf *[]float32) {
+	buf = r.rotBuf.Get().(*[]float32)
+	xr, tmp := (*buf)[:r.bpr*r.d], (*buf)[r.bpr*r.d:]
+	clear(xr[copy(xr, x):])
+	for b := 0; b < r.bpr; b++ { r.rot.Apply(xr[b*r.d:(b+1)*r.d], tmp) }
+	return xr, buf
+}
+
+// matVecAdd accumulates y += R*x, through lookup tables (see lutMatVecAdd)
+// once there are enough rows to pay for building them.
+func (r *rowPQ) matVecAdd(y, x []float32) {
+	width := r.cols
+	if r.rot != nil {
+		xr, buf := r.rotateInput(x)
+		defer r.rotBuf.Put(buf)
+		x = xr
+		width = r.bpr * r.d // padding columns take part since rotation spreads them
+	}
+	// the hook is only bound to a device kernel; there is no CPU stand-in
+	// to beat the paths below
+	if gpu_Available() && gpu_RPQRowMatVecF32(y, r.cb, r.d, r.m, r.k, r.rows, width, r.bits, r.codes, x) { return }
+	if r.rows >= r.k {
+		r.lutMatVecAdd(y, x)
+		return
+	}
+	r.directMatVecAdd(y, x, width)
+}
+
+// directMatVecAdd multiplies every decoded element; codewords are cut off at
+// width columns.
+func (r *rowPQ) directMatVecAdd(y, x []float32, width int) {
+	stride := r.bpr * r.m
+	parallelRows(r.rows, r.rows*width, func(lo, hi int) {
+		for row := lo; row < hi; row++ {
+			base := row * stride
+			s := float32(0)
+			for b := 0; b < r.bpr; b++ {
+				for i := 0; i < r.m; i++ {
+					c0 := b*r.d + i*r.dsub
+					if c0 >= width { break }
+					n := r.dsub
+					if c0+n > width { n = width - c0 }
+					cw := r.cb[(i*r.k+quant.Code(r.codes, r.bits, base+b*r.m+i))*r.dsub:]
+					xs := x[c0 : c0+n]
+					for j, xv := range xs { s += cw[j] * xv }
+				}
+			}
+			y[row] += s
+		}
+	})
+}
+
+// lut returns the asymmetric-distance table for x: entry (b*m+i)*k+c is
+// codeword c of sub-space i dotted with the matching slice of block b. x is
+// zero-padded to whole blocks first, so padding columns drop out.
+func (r *rowPQ) lut(x []float32) []float32 {
+	if len(x) < r.bpr*r.d {
+		xp := make([]float32, r.bpr*r.d)
+		copy(xp, x)
+		x = xp
+	}
+	t := make([]float32, r.bpr*r.m*r.k)
+	parallelRows(r.bpr, r.bpr*r.k*r.d, func(lo, hi int) {
+		for b := lo; b < hi; b++ {
+			for i := 0; i < r.m; i++ {
+				xs := x[b*r.d+i*r.dsub:][:r.dsub]
+				row := t[(b*r.m+i)*r.k:][:r.k]
+				cb := r.cb[i*r.k*r.dsub:]
+				for c := range row {
+					cw := cb[c*r.dsub:][:r.dsub]
+					s := float32(0)
+					for j, xv := range xs { s += cw[j] * xv }
+					row[c] = s
+				}
+			}
+		}
+	})
+	return t
+}
+
+// lutMatVecAdd accumulates y += R*x with one table lookup per code instead of
+// dsub multiply-adds; building the table costs bpr*k*d, about k rows' worth.
+func (r *rowPQ) lutMatVecAdd(y, x []float32) {
+	t := r.lut(x)
+	stride := r.bpr * r.m
+	parallelRows(r.rows, r.rows*stride, func(lo, hi int) {
+		for row := lo; row < hi; row++ {
+			base := row * stride
+			if r.bits == 8 {
+				y[row] += lutSum8(t, r.codes[base:base+stride], r.k)
+				continue
+			}
+			s := float32(0)
+			for j := 0; j < stride; j++ { s += t[j*r.k+quant.Code(r.codes, r.bits, base+j)] }
+			y[row] += s
+		}
+	})
+}
+
+// addTo accumulates the decoded residue into a dense row-major matrix.
+func (r *rowPQ) addTo(dst []float32) { r.addRows(0, r.rows, dst) }
+
+// addRows accumulates rows [r0, r1) into dst, which holds just those rows.
+func (r *rowPQ) addRows(r0, r1 int, dst []float32) {
+	if r.rot != nil {
+		r.addRowsRotated(r0, r1, dst)
+		return
+	}
+	stride := r.bpr * r.m
+	for row := r0; row < r1; row++ {
+		base := row * stride
+		out := dst[(row-r0)*r.cols : (row-r0+1)*r.cols]
+		for b := 0; b < r.bpr; b++ {
+			for i := 0; i < r.m; i++ {
+				c0 := b*r.d + i*r.dsub
+				if c0 >= r.cols { break }
+				n := r.dsub
+				if c0+n > r.cols { n = r.cols - c0 }
+				cw := r.cb[(i*r.k+quant.Code(r.codes, r.bits, base+b*r.m+i))*r.dsub:]
+				for j := 0; j < n; j++ { out[c0+j] += cw[j] }
+			}
+		}
+	}
+}
+
+// addRowsRotated decodes each block in full, undoes the rotation and drops
+// the padding columns.
+func (r *rowPQ) addRowsRotated(r0, r1 int, dst []float32) {
+	stride := r.bpr * r.m
+	blk := make([]float32, r.d)
+	tmp := make([]float32, r.d)
+	for row := r0; row < r1; row++ {
+		base := row * stride
+		out := dst[(row-r0)*r.cols : (row-r0+1)*r.cols]
+		for b := 0; b < r.bpr; b++ {
+			for i := 0; i < r.m; i++ {
+				cw := r.cb[(i*r.k+quant.Code(r.codes, r.bits, base+b*r.m+i))*r.dsub:]
+				copy(blk[i*r.dsub:(i+1)*r.dsub], cw)
+			}
+			r.rot.ApplyT(blk, tmp)
+			c0 := b * r.d
+			for j := 0; j < r.d && c0+j < r.cols; j++ { out[c0+j] += blk[j] }
+		}
+	}
+}
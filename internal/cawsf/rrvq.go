@@ -0,0 +1,55 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// Residual PQ R shard (type 6). Blocks tile rows as in type 4; stage s
+// quantizes what stages before it left, so W_R is the sum of the stages:
+//
+//	rows:u32 cols:u32 d:u16 m:u16 k:u16 bits:u8 flags:u8 version:u8 stages:u8
+//	stages x cb:[m*k*dsub]f32 (f16 if rFlagCBFP16)
+//	stages x codes:[rows][ceil(cols/d)][m], packed bits wide
+const rRVQHeader = 18
+
+const rvqVersion = 1
+
+// parseRVQ returns one rowPQ view per stage; applying them all in turn
+// decodes the shard.
+func parseRVQ(p []byte) ([]*rowPQ, error) {
+	if len(p) < rRVQHeader { return nil, fmt.Errorf("short R payload") }
+	base := rowPQ{
+		rows: int(binary.LittleEndian.Uint32(p[0:4])),
+		cols: int(binary.LittleEndian.Uint32(p[4:8])),
+		d:    int(binary.LittleEndian.Uint16(p[8:10])),
+		m:    int(binary.LittleEndian.Uint16(p[10:12])),
+		k:    int(binary.LittleEndian.Uint16(p[12:14])),
+		bits: int(p[14]),
+	}
+	flags, version, stages := p[15], p[16], int(p[17])
+	if version != rvqVersion { return nil, fmt.Errorf("unsupported RVQ payload version %d", version) }
+	if base.d == 0 || base.m == 0 || base.d%base.m != 0 { return nil, fmt.Errorf("bad R block shape d=%d m=%d", base.d, base.m) }
+	if !validCodeBits(base.bits) { return nil, fmt.Errorf("unsupported R code width %d", base.bits) }
+	base.dsub = base.d / base.m
+	base.bpr = (base.cols + base.d - 1) / base.d
+	elem := 4
+	if flags&rFlagCBFP16 != 0 { elem = 2 }
+	ncb := base.m * base.k * base.dsub
+	ncode := quant.PackedLen(base.rows*base.bpr*base.m, base.bits)
+	if len(p) != rRVQHeader+stages*(elem*ncb+ncode) { return nil, fmt.Errorf("RVQ payload size mismatch") }
+	out := make([]*rowPQ, stages)
+	cbOff := rRVQHeader
+	codeOff := rRVQHeader + stages*elem*ncb
+	for s := range out {
+		st := base
+		st.cb = readCodebook(p[cbOff:], ncb, elem == 2)
+		st.codes = p[codeOff : codeOff+ncode]
+		cbOff += elem * ncb
+		codeOff += ncode
+		out[s] = &st
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package cawsf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Runtime applies and reconstructs scopes of one shard bank. Decoded shards
+// go through its ShardCache when it has one, so repeated calls on warm scopes
+// skip decompressing and parsing.
+type Runtime struct {
+	bank    []byte
+	pool    *CodebookPool
+	records []BankRec // bank order; routing shard ids index it
+	scopes  map[uint16][]BankRec
+	cache   *ShardCache
+}
+
+// NewRuntime indexes bank; cache may be nil to decode on every call, but must
+// not be shared with another bank.
+func NewRuntime(bank []byte, pool *CodebookPool, cache *ShardCache) (*Runtime, error) {
+	idx, err := IndexShardBank(bank)
+	if err != nil { return nil, err }
+	rt := &Runtime{bank: bank, pool: pool, records: idx.Records, scopes: map[uint16][]BankRec{}, cache: cache}
+	for _, rec := range idx.Records { rt.scopes[rec.Hdr.Scope] = append(rt.scopes[rec.Hdr.Scope], rec) }
+	return rt, nil
+}
+
+// Cache returns the runtime's cache, or nil.
+func (rt *Runtime) Cache() *ShardCache { return rt.cache }
+
+// Scopes lists the scope ids present in the bank in ascending order.
+func (rt *Runtime) Scopes() []uint16 {
+	out := make([]uint16, 0, len(rt.scopes))
+	for sc := range rt.scopes { out = append(out, sc) }
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Warm loads the shards of scope into the cache.
+func (rt *Runtime) Warm(scope uint16) error {
+	_, err := rt.shards(scope)
+	return err
+}
+
+// shards returns the decoded shards of scope in bank order.
+func (rt *Runtime) shards(scope uint16) ([]shard, error) {
+	recs, ok := rt.scopes[scope]
+	if !ok { return nil, fmt.Errorf("scope %d not found", scope) }
+	out := make([]shard, 0, len(recs))
+	for _, rec := range recs {
+		if rt.cache != nil {
+			if sh, ok := rt.cache.get(rec.Offset); ok {
+				out = append(out, sh)
+				continue
+			}
+		}
+		start := time.Now()
+		sh, err := decodeShard(rt.bank, rec, rt.pool)
+		if err != nil { return nil, err }
+		if rt.cache != nil { rt.cache.put(rec.Offset, scope, sh, time.Since(start)) }
+		out = append(out, sh)
+	}
+	return out, nil
+}
+
+// scopeShape checks that every shard of a scope agrees on its shape.
+func scopeShape(scope uint16, shards []shard) (rows, cols int, err error) {
+	for i, sh := range shards {
+		r, c := sh.shape()
+		if i > 0 && (r != rows || c != cols) { return 0, 0, fmt.Errorf("scope %d: shard shapes %dx%d and %dx%d differ", scope, rows, cols, r, c) }
+		rows, cols = r, c
+	}
+	if rows == 0 || cols == 0 { return 0, 0, fmt.Errorf("scope %d not found", scope) }
+	return rows, cols, nil
+}
+
+// Multiply computes y = W*x for scope: L and D as dense matvecs (on the GPU
+// with CROW_CUDA=1), R straight from its codes and S from its entries.
+func (rt *Runtime) Multiply(scope uint16, x []float32) ([]float32, int, int, error) {
+	shards, err := rt.shards(scope)
+	if err != nil { return nil, 0, 0, err }
+	rows, cols, err := scopeShape(scope, shards)
+	if err != nil { return nil, 0, 0, err }
+	if len(x) != cols { return nil, 0, 0, fmt.Errorf("input length %d != cols %d", len(x), cols) }
+	y := make([]float32, rows)
+	gpuDone := map[int]bool{}
+	if os.Getenv("CROW_CUDA") == "1" && gpuAvailable() {
+		for i, sh := range shards {
+			if d, ok := sh.(*denseFP16); ok && gpuMat(y, d.f32(), rows, cols, x) { gpuDone[i] = true }
+		}
+	}
+	for i, sh := range shards {
+		if gpuDone[i] { continue }
+		if err := sh.apply(y, x); err != nil { return nil, 0, 0, err }
+	}
+	return y, rows, cols, nil
+}
+
+// Reconstruct returns the dense row-major weight matrix of scope.
+func (rt *Runtime) Reconstruct(scope uint16) (rows, cols int, data []float32, err error) {
+	shards, err := rt.shards(scope)
+	if err != nil { return 0, 0, nil, err }
+	rows, cols, err = scopeShape(scope, shards)
+	if err != nil { return 0, 0, nil, err }
+	data = make([]float32, rows*cols)
+	for _, sh := range shards {
+		if err := sh.accumulate(data); err != nil { return 0, 0, nil, err }
+	}
+	return rows, cols, data, nil
+}
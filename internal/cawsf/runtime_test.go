@@ -0,0 +1,112 @@
+package cawsf
+
+import (
+	"testing"
+
+	"github.com/qrv0/crow/internal/convert"
+)
+
+// multiScopeBank converts n random matrices into scopes 0..n-1 of one bank.
+func multiScopeBank(t *testing.T, n, rows, cols int) []byte {
+	t.Helper()
+	var bank []byte
+	for sc := 0; sc < n; sc++ {
+		spec := convert.LayerSpec{Name: "w", Rows: rows, Cols: cols, Data: randVec(rows*cols, int64(sc)), Scope: uint16(sc)}
+		shards, err := convert.ConvertLayer(spec, convert.Config{Rank: 1, OutlierQuantile: 0.95, PQm: 2, PQk: 4, PQd: 8})
+		if err != nil { t.Fatal(err) }
+		for _, s := range shards { bank = append(bank, pack(s.Type, uint16(sc), s.Data)...) }
+	}
+	return bank
+}
+
+func TestRuntimeCacheHits(t *testing.T) {
+	rows, cols := 8, 16
+	bank := multiScopeBank(t, 2, rows, cols)
+	cache := NewShardCache(1<<20, nil)
+	rt, err := NewRuntime(bank, nil, cache)
+	if err != nil { t.Fatal(err) }
+	x := randVec(cols, 2)
+	want, _, _, err := MultiplyScopeWithPool(bank, nil, 1, x)
+	if err != nil { t.Fatal(err) }
+	for i := 0; i < 3; i++ {
+		got, _, _, err := rt.Multiply(1, x)
+		if err != nil { t.Fatal(err) }
+		for j := range want {
+			if got[j] != want[j] { t.Fatalf("call %d y[%d] = %f want %f", i, j, got[j], want[j]) }
+		}
+	}
+	st := cache.Stats()
+	// four shards (D, L, R, S): loaded once, then served twice
+	if st.Misses != 4 || st.Hits != 8 || st.Entries != 4 || st.Bytes <= 0 { t.Fatalf("stats %+v", st) }
+	if _, _, _, err := rt.Reconstruct(1); err != nil { t.Fatal(err) }
+	if st2 := cache.Stats(); st2.Hits != 12 { t.Fatalf("reconstruct did not use the cache: %+v", st2) }
+}
+
+func TestShardCacheEviction(t *testing.T) {
+	sh := func(n int) shard { return &denseFP16{rows: 1, cols: n / 2, data: make([]byte, n)} }
+	c := NewShardCache(300, EvictLRU)
+	c.put(1, 0, sh(100), 0)
+	c.put(2, 0, sh(100), 0)
+	c.get(1)
+	c.put(3, 0, sh(150), 0) // over budget: 2 is least recently used
+	if _, ok := c.entries[2]; ok { t.Fatalf("LRU kept entry 2") }
+	if st := c.Stats(); st.Bytes != 250 || st.Evictions != 1 { t.Fatalf("stats %+v", st) }
+
+	c = NewShardCache(300, EvictLFU)
+	c.put(1, 0, sh(100), 0)
+	c.put(2, 0, sh(100), 0)
+	c.get(2)
+	c.get(2)
+	c.get(1)
+	c.put(3, 0, sh(150), 0) // 1 was used last but less often
+	if _, ok := c.entries[1]; ok { t.Fatalf("LFU kept entry 1") }
+
+	c = NewShardCache(300, EvictLRU)
+	c.Pin(7)
+	c.put(1, 7, sh(200), 0)
+	c.put(2, 0, sh(100), 0)
+	c.put(3, 0, sh(100), 0) // pinned 1 stays, so 2 goes
+	if _, ok := c.entries[1]; !ok { t.Fatalf("pinned entry evicted") }
+	if _, ok := c.entries[2]; ok { t.Fatalf("entry 2 kept over budget") }
+	c.put(4, 7, sh(400), 0) // pinned shards may exceed the budget
+	if st := c.Stats(); st.PinnedBytes != 600 || st.Bytes != 600 || st.Entries != 2 { t.Fatalf("stats %+v", st) }
+	c.put(5, 0, sh(400), 0) // larger than the budget: not cached
+	if _, ok := c.entries[5]; ok { t.Fatalf("oversized entry cached") }
+}
+
+// checkBatchMatchesSingle verifies MultiplyScopeBatch against one
+// MultiplyScopeWithPool call per input.
+func checkBatchMatchesSingle(t *testing.T, bank []byte, pool *CodebookPool, rows, cols, n int) {
+	t.Helper()
+	X := randVec(n*cols, 5)
+	Y, r, c, err := MultiplyScopeBatch(bank, pool, 0, X, n)
+	if err != nil { t.Fatalf("batch: %v", err) }
+	if r != rows || c != cols { t.Fatalf("shape %dx%d want %dx%d", r, c, rows, cols) }
+	for v := 0; v < n; v++ {
+		want, _, _, err := MultiplyScopeWithPool(bank, pool, 0, X[v*cols:(v+1)*cols])
+		if err != nil { t.Fatalf("single: %v", err) }
+		for i, w := range want {
+			if got := Y[v*rows+i]; absf(got-w) > 1e-4*(1+absf(w)) { t.Fatalf("input %d y[%d] batch %f single %f", v, i, got, w) }
+		}
+	}
+}
+
+func TestBatchMatchesSingle(t *testing.T) {
+	// rows spans more than one tile and cols is not a multiple of d
+	rows, cols := 37, 45
+	cfgs := []convert.Config{
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 2, PQk: 8, PQd: 8},
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 2, PQk: 64, PQd: 8}, // rows < k: row tiles
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 2, PQk: 8, PQd: 8, RLayout: convert.LayoutFlat, SEncoding: convert.SEncTriplet},
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 2, PQk: 8, PQd: 8, Codec: convert.CodecRVQ},
+		{Rank: 2, OutlierQuantile: 0.95, Codec: convert.CodecInt4, IntGroup: 16, IntZeroPoint: true},
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 2, PQk: 64, PQd: 16, Rotation: convert.RotationHadamard},
+		{StoreRaw: true},
+	}
+	for _, cfg := range cfgs {
+		bank, _ := convertBank(t, rows, cols, cfg)
+		for _, n := range []int{1, 3} { checkBatchMatchesSingle(t, bank, nil, rows, cols, n) }
+	}
+	bank, _ := convertBank(t, rows, cols, cfgs[0])
+	if _, _, _, err := MultiplyScopeBatch(bank, nil, 0, make([]float32, cols+1), 1); err == nil { t.Fatalf("bad input length accepted") }
+}
@@ -0,0 +1,129 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CSR-encoded S shard (type 5):
+//
+//	rows:u32 cols:u32 nnz:u32 vtype:u8
+//	rowptr:[rows+1]u32
+//	vals:[nnz]u16 (fp16 or bf16, see vtype)
+//	cols: uvarint per entry; the first entry of a row holds its column, the
+//	      rest the gap to the previous column in the same row
+const sCSRHeader = 13
+
+const (
+	sValFP16 = 1
+	sValBF16 = 2
+)
+
+// csrS views a CSR payload in place; nothing is copied or allocated.
+type csrS struct {
+	rows, cols, nnz int
+	vtype           uint8
+	rowptr          []byte
+	vals            []byte
+	colIdx          []byte
+}
+
+func parseSCSR(p []byte) (csrS, error) {
+	var s csrS
+	if len(p) < sCSRHeader { return s, fmt.Errorf("short S payload") }
+	s.rows = int(binary.LittleEndian.Uint32(p[0:4]))
+	s.cols = int(binary.LittleEndian.Uint32(p[4:8]))
+	s.nnz = int(binary.LittleEndian.Uint32(p[8:12]))
+	s.vtype = p[12]
+	if s.vtype != sValFP16 && s.vtype != sValBF16 { return s, fmt.Errorf("unknown S value type %d", s.vtype) }
+	off := sCSRHeader
+	if off+4*(s.rows+1)+2*s.nnz > len(p) { return s, fmt.Errorf("short S payload") }
+	s.rowptr = p[off : off+4*(s.rows+1)]
+	off += 4 * (s.rows + 1)
+	s.vals = p[off : off+2*s.nnz]
+	s.colIdx = p[off+2*s.nnz:]
+	if int(binary.LittleEndian.Uint32(s.rowptr[4*s.rows:])) != s.nnz { return s, fmt.Errorf("S rowptr/nnz mismatch") }
+	return s, nil
+}
+
+func (s *csrS) val(i int) float32 {
+	h := binary.LittleEndian.Uint16(s.vals[2*i:])
+	if s.vtype == sValBF16 { return math.Float32frombits(uint32(h) << 16) }
+	return fp16to32(h)
+}
+
+// each calls fn for every nonzero in row-major order, decoding columns as it
+// goes. It stops at the first malformed column index.
+func (s *csrS) each(fn func(r, c int, v float32)) error {
+	pos := 0
+	for r := 0; r < s.rows; r++ {
+		start := int(binary.LittleEndian.Uint32(s.rowptr[4*r:]))
+		end := int(binary.LittleEndian.Uint32(s.rowptr[4*(r+1):]))
+		c := 0
+		for i := start; i < end; i++ {
+			delta, n := binary.Uvarint(s.colIdx[pos:])
+			if n <= 0 { return fmt.Errorf("bad S column index") }
+			pos += n
+			c += int(delta)
+			if c >= s.cols { return fmt.Errorf("S column %d out of range", c) }
+			fn(r, c, s.val(i))
+		}
+	}
+	return nil
+}
+
+// matVecAdd accumulates y += S*x straight off the encoded bytes. Large
+// shards are split by rows; one pass over the varints finds where each
+// range's columns start.
+func (s *csrS) matVecAdd(y, x []float32) error {
+	bounds := rowSplit(s.rows, s.nnz)
+	if bounds == nil { return s.rowsAdd(0, s.rows, 0, y, x) }
+	// kept apart so the serial path does not move y and x to the heap
+	return s.matVecAddRanges(bounds, y, x)
+}
+
+func (s *csrS) matVecAddRanges(bounds []int, y, x []float32) error {
+	pos := make([]int, len(bounds)-1)
+	entry, p := 0, 0
+	for i, lo := range bounds[:len(pos)] {
+		for target := int(binary.LittleEndian.Uint32(s.rowptr[4*lo:])); entry < target; entry++ {
+			for p < len(s.colIdx) && s.colIdx[p] >= 0x80 { p++ }
+			if p >= len(s.colIdx) { return fmt.Errorf("bad S column index") }
+			p++
+		}
+		pos[i] = p
+	}
+	errs := make([]error, len(pos))
+	view := *s // the goroutines capture a copy so s itself stays on the caller's stack
+	runRanges(bounds, func(i, lo, hi int) { errs[i] = view.rowsAdd(lo, hi, pos[i], y, x) })
+	for _, err := range errs {
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// rowsAdd applies rows [lo, hi), whose first column index starts at byte pos.
+func (s *csrS) rowsAdd(lo, hi, pos int, y, x []float32) error {
+	for r := lo; r < hi; r++ {
+		start := int(binary.LittleEndian.Uint32(s.rowptr[4*r:]))
+		end := int(binary.LittleEndian.Uint32(s.rowptr[4*(r+1):]))
+		c := 0
+		acc := float32(0)
+		for i := start; i < end; i++ {
+			delta, n := binary.Uvarint(s.colIdx[pos:])
+			if n <= 0 { return fmt.Errorf("bad S column index") }
+			pos += n
+			c += int(delta)
+			if c >= s.cols { return fmt.Errorf("S column %d out of range", c) }
+			acc += s.val(i) * x[c]
+		}
+		y[r] += acc
+	}
+	return nil
+}
+
+// addTo accumulates the outliers into a dense row-major matrix.
+func (s *csrS) addTo(dst []float32) error {
+	return s.each(func(r, c int, v float32) { dst[r*s.cols+c] += v })
+}
@@ -0,0 +1,229 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// shard is a decoded shard ready to apply: decompressed and parsed once, so a
+// ShardCache can hand it out again without touching the bank. Implementations
+// keep the compact stored form (fp16 weights, codes plus codebook refs) and
+// are read-only after decoding.
+type shard interface {
+	shape() (rows, cols int)
+	// apply accumulates y += W*x
+	apply(y, x []float32) error
+	// applyT accumulates y += W^T*x: x has rows entries and y cols
+	applyT(y, x []float32) error
+	// accumulate adds the dense matrix into dst (row-major, rows*cols)
+	accumulate(dst []float32) error
+	// accumulateRanges adds the rows of ascending, disjoint ranges into dst,
+	// which holds just those rows, packed in range order
+	accumulateRanges(ranges []rowRange, dst []float32) error
+	// size is the number of bytes the decoded form holds on to
+	size() int64
+	// applyBatch accumulates Y += X*W^T for n inputs: X is n x cols and Y
+	// n x rows, both row-major
+	applyBatch(Y, X []float32, n int) error
+}
+
+// decodeShard decompresses and parses one shard record of bank.
+func decodeShard(bank []byte, rec BankRec, pool *CodebookPool) (shard, error) {
+	if rec.Offset+int(rec.Hdr.Csize) > len(bank) { return nil, fmt.Errorf("shard at %d overruns bank", rec.Offset) }
+	p, err := decompressShard(rec.Hdr.Comp, bank[rec.Offset:rec.Offset+int(rec.Hdr.Csize)])
+	if err != nil { return nil, err }
+	switch rec.Hdr.Type {
+	case shL, shD:
+		if len(p) < 8 { return nil, fmt.Errorf("short fp16 payload") }
+		d := &denseFP16{rows: int(binary.LittleEndian.Uint32(p[0:4])), cols: int(binary.LittleEndian.Uint32(p[4:8])), data: p[8:]}
+		if len(d.data) < 2*d.rows*d.cols { return nil, fmt.Errorf("short fp16 data") }
+		return d, nil
+	case shR:
+		return parseFlatR(p, pool)
+	case shRRow:
+		return parseRowPQ(p, pool)
+	case shRRVQ:
+		st, err := parseRVQ(p)
+		if err != nil { return nil, err }
+		if len(st) == 0 { return nil, fmt.Errorf("RVQ shard has no stages") }
+		return rvqShard(st), nil
+	case shRInt:
+		r, err := parseIntR(p)
+		return &r, err
+	case shS:
+		return parseTripletS(p)
+	case shSCSR:
+		s, err := parseSCSR(p)
+		return &s, err
+	}
+	return nil, fmt.Errorf("unknown shard type %d", rec.Hdr.Type)
+}
+
+// denseFP16 is an L or D shard kept as stored, two bytes per weight.
+type denseFP16 struct {
+	rows, cols int
+	data       []byte
+}
+
+func (d *denseFP16) shape() (int, int) { return d.rows, d.cols }
+func (d *denseFP16) size() int64       { return int64(len(d.data)) }
+
+func (d *denseFP16) apply(y, x []float32) error {
+	matvecFP16Add(y, d.rows, d.cols, d.data, x)
+	return nil
+}
+
+func (d *denseFP16) accumulate(dst []float32) error {
+	d.addRows(0, d.rows, dst)
+	return nil
+}
+
+// addRows accumulates rows [r0, r1) into dst, which holds just those rows.
+func (d *denseFP16) addRows(r0, r1 int, dst []float32) {
+	src := d.data[2*r0*d.cols : 2*r1*d.cols]
+	for i := range dst[:(r1-r0)*d.cols] { dst[i] += fp16to32(binary.LittleEndian.Uint16(src[2*i:])) }
+}
+
+// f32 widens the weights for the GPU path.
+func (d *denseFP16) f32() []float32 {
+	out := make([]float32, d.rows*d.cols)
+	for i := range out { out[i] = fp16to32(binary.LittleEndian.Uint16(d.data[2*i:])) }
+	return out
+}
+
+// flatR is a legacy flat-layout PQ shard (type 1); it has no parsed form, so
+// it keeps the payload and the pool its codebook may live in.
+type flatR struct {
+	rows, cols int
+	payload    []byte
+	pool       *CodebookPool
+}
+
+func parseFlatR(p []byte, pool *CodebookPool) (*flatR, error) {
+	if len(p) < 18 { return nil, fmt.Errorf("short R payload") }
+	return &flatR{rows: int(binary.LittleEndian.Uint32(p[0:4])), cols: int(binary.LittleEndian.Uint32(p[4:8])), payload: p, pool: pool}, nil
+}
+
+func (r *flatR) shape() (int, int) { return r.rows, r.cols }
+func (r *flatR) size() int64       { return int64(len(r.payload)) }
+
+func (r *flatR) apply(y, x []float32) error {
+	return applyRAddOptimized(y, r.rows, r.cols, r.payload, x, r.pool)
+}
+
+func (r *flatR) accumulate(dst []float32) error {
+	_, _, mat, err := decodeR(r.payload)
+	if err != nil {
+		// shared codebook layout
+		if r.pool == nil { return err }
+		if mat, err = decodeRWithPool(r.rows, r.cols, r.payload, r.pool); err != nil { return err }
+	}
+	addInPlace(dst, mat)
+	return nil
+}
+
+func (r *rowPQ) shape() (int, int) { return r.rows, r.cols }
+
+func (r *rowPQ) size() int64 {
+	n := int64(len(r.codes))
+	if !r.sharedCB { n += 4 * int64(len(r.cb)) }
+	if r.rot != nil { n += 4 * int64(len(r.rot.Q)) }
+	return n
+}
+
+func (r *rowPQ) apply(y, x []float32) error { r.matVecAdd(y, x); return nil }
+
+func (r *rowPQ) accumulate(dst []float32) error { r.addTo(dst); return nil }
+
+// rvqShard applies its stages in turn.
+type rvqShard []*rowPQ
+
+func (s rvqShard) shape() (int, int) { return s[0].rows, s[0].cols }
+
+func (s rvqShard) size() int64 {
+	var n int64
+	for _, st := range s { n += st.size() }
+	return n
+}
+
+func (s rvqShard) apply(y, x []float32) error {
+	for _, st := range s { st.matVecAdd(y, x) }
+	return nil
+}
+
+func (s rvqShard) accumulate(dst []float32) error {
+	for _, st := range s { st.addTo(dst) }
+	return nil
+}
+
+func (r *intR) shape() (int, int) { return r.rows, r.cols }
+func (r *intR) size() int64       { return int64(len(r.scales) + len(r.zeros) + len(r.q)) }
+
+func (r *intR) apply(y, x []float32) error { r.matVecAdd(y, x); return nil }
+
+func (r *intR) accumulate(dst []float32) error { r.addTo(dst); return nil }
+
+// tripletS views a triplet-layout S shard (type 2):
+// rows:u32, cols:u32, n:u32, n*(row:i32, col:i32), n*val:f32.
+type tripletS struct {
+	rows, cols int
+	n          int
+	payload    []byte
+	sorted     bool // rows non-decreasing, as convert writes them; allows splitting by rows
+}
+
+func parseTripletS(p []byte) (*tripletS, error) {
+	if len(p) < 12 { return nil, fmt.Errorf("short S payload") }
+	n := int(binary.LittleEndian.Uint32(p[8:12]))
+	if 12+12*n > len(p) { return nil, fmt.Errorf("short S payload") }
+	s := &tripletS{rows: int(binary.LittleEndian.Uint32(p[0:4])), cols: int(binary.LittleEndian.Uint32(p[4:8])), n: n, payload: p, sorted: true}
+	for i := 1; i < n && s.sorted; i++ { s.sorted = s.row(i-1) <= s.row(i) }
+	return s, nil
+}
+
+func (s *tripletS) row(i int) int { return int(int32(binary.LittleEndian.Uint32(s.payload[12+8*i:]))) }
+
+func (s *tripletS) shape() (int, int) { return s.rows, s.cols }
+func (s *tripletS) size() int64       { return int64(len(s.payload)) }
+
+// apply splits sorted shards by rows, each range applying its own entries in
+// file order; unsorted ones (and the GPU) go through applySAddOptimized.
+func (s *tripletS) apply(y, x []float32) error {
+	bounds := rowSplit(s.rows, s.n)
+	if bounds == nil || !s.sorted || gpu_Available() {
+		applySAddOptimized(y, s.rows, s.cols, s.payload, x)
+		return nil
+	}
+	idx, vals := s.payload[12:12+8*s.n], s.payload[12+8*s.n:]
+	runRanges(bounds, func(_, lo, hi int) {
+		e0 := sort.Search(s.n, func(i int) bool { return s.row(i) >= lo })
+		for e := e0; e < s.n; e++ {
+			r := s.row(e)
+			if r >= hi { break }
+			c := int(int32(binary.LittleEndian.Uint32(idx[8*e+4:])))
+			if r < 0 || c < 0 || c >= s.cols { continue }
+			y[r] += math.Float32frombits(binary.LittleEndian.Uint32(vals[4*e:])) * x[c]
+		}
+	})
+	return nil
+}
+
+func (s *tripletS) accumulate(dst []float32) error {
+	idx, vals := s.payload[12:12+8*s.n], s.payload[12+8*s.n:]
+	for i := 0; i < s.n; i++ {
+		r := int(int32(binary.LittleEndian.Uint32(idx[8*i:])))
+		c := int(int32(binary.LittleEndian.Uint32(idx[8*i+4:])))
+		if r < 0 || r >= s.rows || c < 0 || c >= s.cols { return fmt.Errorf("S entry (%d,%d) out of range", r, c) }
+		dst[r*s.cols+c] += math.Float32frombits(binary.LittleEndian.Uint32(vals[4*i:]))
+	}
+	return nil
+}
+
+func (s *csrS) shape() (int, int) { return s.rows, s.cols }
+func (s *csrS) size() int64       { return int64(len(s.rowptr) + len(s.vals) + len(s.colIdx)) }
+
+func (s *csrS) apply(y, x []float32) error { return s.matVecAdd(y, x) }
+
+func (s *csrS) accumulate(dst []float32) error { return s.addTo(dst) }
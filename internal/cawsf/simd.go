@@ -0,0 +1,30 @@
+package cawsf
+
+// Inner loops with assembly versions. The variables start out pointing at
+// the Go reference and are switched by the per-architecture init when the
+// CPU has the needed features; build with -tags purego to keep the Go ones.
+var (
+	// dotFP16 returns the dot product of little-endian fp16 w with x[:len(w)/2].
+	dotFP16 = dotFP16Go
+	// lutSum8 returns the sum over j of t[j*k+codes[j]].
+	lutSum8 = lutSum8Go
+	simdName = ""
+)
+
+// SIMD names the instruction set the fp16 and LUT kernels use, or "" for
+// the pure-Go versions.
+func SIMD() string { return simdName }
+
+func dotFP16Go(w []byte, x []float32) float32 {
+	n := len(w) / 2
+	x = x[:n]
+	s := float32(0)
+	for j, xv := range x { s += fp16Table[uint16(w[2*j])|uint16(w[2*j+1])<<8] * xv }
+	return s
+}
+
+func lutSum8Go(t []float32, codes []byte, k int) float32 {
+	s := float32(0)
+	for j, c := range codes { s += t[j*k+int(c)] }
+	return s
+}
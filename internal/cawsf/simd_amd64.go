@@ -0,0 +1,44 @@
+//go:build !purego
+
+package cawsf
+
+import (
+	"math"
+	"unsafe"
+
+	"github.com/klauspost/cpuid/v2"
+)
+
+//go:noescape
+func dotFP16AVX2(w *byte, x *float32, n int) float32
+
+//go:noescape
+func lutSum8AVX2(t *float32, codes *byte, n, k int) float32
+
+func init() {
+	if !cpuid.CPU.Supports(cpuid.AVX, cpuid.AVX2, cpuid.F16C, cpuid.FMA3) { return }
+	dotFP16, lutSum8, simdName = dotFP16Asm, lutSum8Asm, "avx2"
+}
+
+// dotFP16Asm runs whole groups of 8 in assembly and the tail in Go.
+func dotFP16Asm(w []byte, x []float32) float32 {
+	n := len(w) / 2
+	x = x[:n]
+	body := n &^ 7
+	s := float32(0)
+	if body > 0 { s = dotFP16AVX2(unsafe.SliceData(w), unsafe.SliceData(x), body) }
+	for j := body; j < n; j++ { s += fp16Table[uint16(w[2*j])|uint16(w[2*j+1])<<8] * x[j] }
+	return s
+}
+
+// lutSum8Asm gathers 8 table entries at a time over the codes whose every
+// possible byte value stays inside t, leaving the rest to bounds-checked Go.
+// The gather takes int32 offsets, so tables past 2^31 entries stay in Go.
+func lutSum8Asm(t []float32, codes []byte, k int) float32 {
+	if k <= 0 || len(t) < 256 || len(t) > math.MaxInt32 { return lutSum8Go(t, codes, k) }
+	body := min(len(codes), (len(t)-256)/k+1) &^ 7
+	s := float32(0)
+	if body > 0 { s = lutSum8AVX2(unsafe.SliceData(t), unsafe.SliceData(codes), body, k) }
+	for j := body; j < len(codes); j++ { s += t[j*k+int(codes[j])] }
+	return s
+}
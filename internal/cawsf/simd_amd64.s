@@ -0,0 +1,82 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func dotFP16AVX2(w *byte, x *float32, n int) float32
+// n is a multiple of 8. Two accumulators hide the FMA latency.
+TEXT ·dotFP16AVX2(SB), NOSPLIT, $0-28
+	MOVQ w+0(FP), SI
+	MOVQ x+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+
+loop16:
+	CMPQ CX, $16
+	JL   tail8
+	VCVTPH2PS (SI), Y2
+	VCVTPH2PS 16(SI), Y3
+	VFMADD231PS (DI), Y2, Y0
+	VFMADD231PS 32(DI), Y3, Y1
+	ADDQ $32, SI
+	ADDQ $64, DI
+	SUBQ $16, CX
+	JMP  loop16
+
+tail8:
+	CMPQ CX, $8
+	JL   reduce
+	VCVTPH2PS (SI), Y2
+	VFMADD231PS (DI), Y2, Y0
+
+reduce:
+	VADDPS       Y1, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPS       X1, X0, X0
+	VHADDPS      X0, X0, X0
+	VHADDPS      X0, X0, X0
+	VZEROUPPER
+	MOVSS X0, ret+24(FP)
+	RET
+
+DATA lutLanes<>+0(SB)/4, $0
+DATA lutLanes<>+4(SB)/4, $1
+DATA lutLanes<>+8(SB)/4, $2
+DATA lutLanes<>+12(SB)/4, $3
+DATA lutLanes<>+16(SB)/4, $4
+DATA lutLanes<>+20(SB)/4, $5
+DATA lutLanes<>+24(SB)/4, $6
+DATA lutLanes<>+28(SB)/4, $7
+GLOBL lutLanes<>(SB), RODATA|NOPTR, $32
+
+// func lutSum8AVX2(t *float32, codes *byte, n, k int) float32
+// n is a multiple of 8; lane l of group g reads t[(8g+l)*k + codes[8g+l]].
+TEXT ·lutSum8AVX2(SB), NOSPLIT, $0-36
+	MOVQ t+0(FP), AX
+	MOVQ codes+8(FP), SI
+	MOVQ n+16(FP), CX
+	MOVQ k+24(FP), DX
+	MOVQ DX, X5
+	VPBROADCASTD X5, Y5
+	VPMULLD lutLanes<>(SB), Y5, Y6 // row offsets of the current group
+	VPSLLD  $3, Y5, Y7             // advance per group: 8k
+	VXORPS  Y0, Y0, Y0
+
+loop:
+	VPMOVZXBD (SI), Y1
+	VPADDD    Y6, Y1, Y1
+	VPCMPEQD  Y3, Y3, Y3
+	VGATHERDPS Y3, (AX)(Y1*4), Y4
+	VADDPS    Y4, Y0, Y0
+	VPADDD    Y7, Y6, Y6
+	ADDQ $8, SI
+	SUBQ $8, CX
+	JNZ  loop
+
+	VEXTRACTF128 $1, Y0, X1
+	VADDPS       X1, X0, X0
+	VHADDPS      X0, X0, X0
+	VHADDPS      X0, X0, X0
+	VZEROUPPER
+	MOVSS X0, ret+32(FP)
+	RET
@@ -0,0 +1,36 @@
+//go:build !purego
+
+package cawsf
+
+import (
+	"unsafe"
+
+	"github.com/klauspost/cpuid/v2"
+)
+
+// dotFP16NEON leaves its two 4-lane accumulators in acc for Go to reduce.
+//
+//go:noescape
+func dotFP16NEON(w *byte, x *float32, n int, acc *[8]float32)
+
+func init() {
+	// FCVTL is base ASIMD; no FEAT_FP16 arithmetic is needed. NEON has no
+	// gather, so the LUT sum stays in Go.
+	if !cpuid.CPU.Supports(cpuid.ASIMD) { return }
+	dotFP16, simdName = dotFP16Asm, "neon"
+}
+
+// dotFP16Asm runs whole groups of 8 in assembly and the tail in Go.
+func dotFP16Asm(w []byte, x []float32) float32 {
+	n := len(w) / 2
+	x = x[:n]
+	body := n &^ 7
+	s := float32(0)
+	if body > 0 {
+		var acc [8]float32
+		dotFP16NEON(unsafe.SliceData(w), unsafe.SliceData(x), body, &acc)
+		for _, a := range acc { s += a }
+	}
+	for j := body; j < n; j++ { s += fp16Table[uint16(w[2*j])|uint16(w[2*j+1])<<8] * x[j] }
+	return s
+}
@@ -0,0 +1,27 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func dotFP16NEON(w *byte, x *float32, n int, acc *[8]float32)
+// n is a multiple of 8. The Go assembler has no FCVTL, so it is encoded by
+// hand: FCVTL Vd.4S, Vn.4H = 0x0E217800 | n<<5 | d, FCVTL2 sets bit 30.
+TEXT ·dotFP16NEON(SB), NOSPLIT, $0-32
+	MOVD w+0(FP), R0
+	MOVD x+8(FP), R1
+	MOVD n+16(FP), R2
+	MOVD acc+24(FP), R3
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+
+loop:
+	VLD1.P 16(R0), [V2.H8]
+	VLD1.P 32(R1), [V4.S4, V5.S4]
+	WORD $0x0E217843 // FCVTL  V3.4S, V2.4H
+	WORD $0x4E217842 // FCVTL2 V2.4S, V2.8H
+	VFMLA V3.S4, V4.S4, V0.S4
+	VFMLA V2.S4, V5.S4, V1.S4
+	SUBS $8, R2, R2
+	BNE  loop
+
+	VST1 [V0.S4, V1.S4], (R3)
+	RET
@@ -0,0 +1,101 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestDotFP16AllValues checks the active kernel converts every finite fp16
+// value exactly: each block of 16 is dotted with one-hot inputs.
+func TestDotFP16AllValues(t *testing.T) {
+	t.Logf("SIMD: %q", SIMD())
+	var vals []uint16
+	for h := 0; h < 1<<16; h++ {
+		if !math.IsInf(float64(fp16to32(uint16(h))), 0) && fp16to32(uint16(h)) == fp16to32(uint16(h)) { vals = append(vals, uint16(h)) }
+	}
+	w := make([]byte, 32)
+	x := make([]float32, 16)
+	for b := 0; b+16 <= len(vals); b += 16 {
+		for l := 0; l < 16; l++ { binary.LittleEndian.PutUint16(w[2*l:], vals[b+l]) }
+		for l := 0; l < 16; l++ {
+			x[l] = 1
+			if got, want := dotFP16(w, x), fp16to32(vals[b+l]); got != want { t.Fatalf("fp16 %#04x: got %v want %v", vals[b+l], got, want) }
+			x[l] = 0
+		}
+	}
+	// infinities and NaNs propagate from any lane
+	for _, h := range []uint16{0x7c00, 0xfc00, 0x7e00, 0x7c01} {
+		for l := 0; l < 16; l++ {
+			clear(w)
+			binary.LittleEndian.PutUint16(w[2*l:], h)
+			for i := range x { x[i] = 1 }
+			got, want := dotFP16(w, x), fp16to32(h)
+			if math.IsNaN(float64(want)) != math.IsNaN(float64(got)) || (!math.IsNaN(float64(want)) && got != want) { t.Fatalf("fp16 %#04x lane %d: got %v want %v", h, l, got, want) }
+		}
+	}
+}
+
+func TestDotFP16MatchesGo(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n <= 80; n++ {
+		w := make([]byte, 2*n)
+		for i := 0; i < n; i++ { copy(w[2*i:], fp32to16(float32(rng.NormFloat64()))) }
+		x := randVec(n+3, int64(n)) // longer inputs are cut to len(w)/2
+		got, want := dotFP16(w, x), dotFP16Go(w, x)
+		bound := float32(0)
+		for i := 0; i < n; i++ { bound += absf(fp16to32(binary.LittleEndian.Uint16(w[2*i:])) * x[i]) }
+		if absf(got-want) > 1e-5*(1+bound) { t.Fatalf("n=%d got %v want %v", n, got, want) }
+	}
+}
+
+func TestLUTSum8MatchesGo(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, k := range []int{1, 2, 16, 255, 256} {
+		for n := 0; n <= 70; n++ {
+			tab := randVec(n*k, int64(k*100+n))
+			codes := make([]byte, n)
+			for i := range codes { codes[i] = byte(rng.Intn(k)) }
+			got, want := lutSum8(tab, codes, k), lutSum8Go(tab, codes, k)
+			bound := float32(0)
+			for j, c := range codes { bound += absf(tab[j*k+int(c)]) }
+			if absf(got-want) > 1e-5*(1+bound) { t.Fatalf("k=%d n=%d got %v want %v", k, n, got, want) }
+		}
+	}
+	// every code value in every lane
+	k := 256
+	tab := randVec(16*k, 9)
+	codes := make([]byte, 16)
+	for c := 0; c < 256; c++ {
+		for l := range codes { codes[l] = byte((c + 17*l) % 256) }
+		got, want := lutSum8(tab, codes, k), lutSum8Go(tab, codes, k)
+		if absf(got-want) > 1e-5*(1+absf(want)) { t.Fatalf("codes %v got %v want %v", codes, got, want) }
+	}
+}
+
+func BenchmarkDotFP16(b *testing.B) {
+	n := 4096
+	w := make([]byte, 2*n)
+	for i, v := range randVec(n, 1) { copy(w[2*i:], fp32to16(v)) }
+	x := randVec(n, 2)
+	b.Run("go", func(b *testing.B) {
+		for i := 0; i < b.N; i++ { dotFP16Go(w, x) }
+	})
+	b.Run("simd", func(b *testing.B) {
+		for i := 0; i < b.N; i++ { dotFP16(w, x) }
+	})
+}
+
+func BenchmarkLUTSum8(b *testing.B) {
+	k, n := 256, 512
+	tab := randVec(n*k, 1)
+	codes := make([]byte, n)
+	for i := range codes { codes[i] = byte(i * 7) }
+	b.Run("go", func(b *testing.B) {
+		for i := 0; i < b.N; i++ { lutSum8Go(tab, codes, k) }
+	})
+	b.Run("simd", func(b *testing.B) {
+		for i := 0; i < b.N; i++ { lutSum8(tab, codes, k) }
+	})
+}
@@ -0,0 +1,153 @@
+package cawsf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// MultiplyScopeTransposed computes y = W^T*x for the given scope without
+// materializing W: x has rows entries and y cols. Like MultiplyScopeWithPool
+// it decodes every shard on each call.
+func MultiplyScopeTransposed(bank []byte, pool *CodebookPool, scope uint16, x []float32) ([]float32, int, int, error) {
+	rt, err := NewRuntime(bank, pool, nil)
+	if err != nil { return nil, 0, 0, err }
+	return rt.MultiplyTransposed(scope, x)
+}
+
+// MultiplyTransposed is Multiply with W^T: L and D go through the same GPU
+// matvec with the other op (CROW_CUDA=1), R scatters its codewords into y
+// and S its entries. Kernels split y's columns over Workers, so like
+// Multiply the result does not depend on the worker count.
+func (rt *Runtime) MultiplyTransposed(scope uint16, x []float32) ([]float32, int, int, error) {
+	shards, err := rt.shards(scope)
+	if err != nil { return nil, 0, 0, err }
+	rows, cols, err := scopeShape(scope, shards)
+	if err != nil { return nil, 0, 0, err }
+	if len(x) != rows { return nil, 0, 0, fmt.Errorf("input length %d != rows %d", len(x), rows) }
+	y := make([]float32, cols)
+	gpu := os.Getenv("CROW_CUDA") == "1" && gpuAvailable()
+	for _, sh := range shards {
+		if d, ok := sh.(*denseFP16); ok && gpu && gpuMatT(y, d.f32(), rows, cols, x) { continue }
+		if err := sh.applyT(y, x); err != nil { return nil, 0, 0, err }
+	}
+	return y, rows, cols, nil
+}
+
+func (d *denseFP16) applyT(y, x []float32) error {
+	parallelRows(d.cols, d.rows*d.cols, func(lo, hi int) {
+		for i, xi := range x[:d.rows] {
+			src := d.data[2*(i*d.cols+lo) : 2*(i*d.cols+hi)]
+			for j := range y[lo:hi] { y[lo+j] += xi * fp16to32(binary.LittleEndian.Uint16(src[2*j:])) }
+		}
+	})
+	return nil
+}
+
+// applyT on the legacy flat layout walks the blocks in file order on one
+// goroutine; a block can span rows, so it has no column split.
+func (r *flatR) applyT(y, x []float32) error {
+	c, err := parseFlatRCodes(r.payload, r.pool)
+	if err != nil { return err }
+	dsub := c.d / c.m
+	total := r.rows * r.cols
+	for b := 0; b < c.n; b++ {
+		for i := 0; i < c.m; i++ {
+			cw := c.cb[(i*c.k+quant.Code(c.codes, c.bits, b*c.m+i))*dsub:]
+			for j := 0; j < dsub; j++ {
+				flat := b*c.d + i*dsub + j
+				if flat >= total { break }
+				y[flat%r.cols] += cw[j] * x[flat/r.cols]
+			}
+		}
+	}
+	return nil
+}
+
+// applyT splits by column blocks. Rotated shards sum x-weighted codewords in
+// the rotated space and undo the rotation once per block.
+func (r *rowPQ) applyT(y, x []float32) error {
+	stride := r.bpr * r.m
+	parallelRows(r.bpr, r.rows*r.cols, func(lo, hi int) {
+		var acc, tmp []float32
+		if r.rot != nil { acc, tmp = make([]float32, r.d), make([]float32, r.d) }
+		for b := lo; b < hi; b++ {
+			if acc != nil { clear(acc) }
+			for row, xr := range x[:r.rows] {
+				base := row*stride + b*r.m
+				for i := 0; i < r.m; i++ {
+					c0 := b*r.d + i*r.dsub
+					cw := r.cb[(i*r.k+quant.Code(r.codes, r.bits, base+i))*r.dsub:][:r.dsub]
+					if acc != nil {
+						for j, v := range cw { acc[i*r.dsub+j] += xr * v }
+						continue
+					}
+					if c0 >= r.cols { break }
+					n := min(r.dsub, r.cols-c0)
+					for j, v := range cw[:n] { y[c0+j] += xr * v }
+				}
+			}
+			if acc == nil { continue }
+			r.rot.ApplyT(acc, tmp)
+			c0 := b * r.d
+			for j := 0; j < r.d && c0+j < r.cols; j++ { y[c0+j] += acc[j] }
+		}
+	})
+	return nil
+}
+
+func (s rvqShard) applyT(y, x []float32) error {
+	for _, st := range s {
+		if err := st.applyT(y, x); err != nil { return err }
+	}
+	return nil
+}
+
+// applyT splits by scale groups, so each goroutine reads its groups'
+// scales and zero-points only.
+func (r *intR) applyT(y, x []float32) error {
+	parallelRows(r.ng, r.rows*r.cols, func(lo, hi int) {
+		for row, xr := range x[:r.rows] {
+			qrow := r.q[row*r.rowBytes : (row+1)*r.rowBytes]
+			for g := lo; g < hi; g++ {
+				i := row*r.ng + g
+				s := xr * fp16to32(binary.LittleEndian.Uint16(r.scales[2*i:]))
+				z := float32(0)
+				if r.zp { z = float32(r.zeros[i]) }
+				for c := g * r.group; c < min((g+1)*r.group, r.cols); c++ { y[c] += s * (r.qv(qrow, c) - z) }
+			}
+		}
+	})
+	return nil
+}
+
+// applyT swaps the roles of the row and column indices; the GPU takes the
+// swapped triplets as an ordinary sparse multiply.
+func (s *tripletS) applyT(y, x []float32) error {
+	idx, vals := s.payload[12:12+8*s.n], s.payload[12+8*s.n:]
+	if gpu_Available() {
+		ri := make([]int32, s.n)
+		ci := make([]int32, s.n)
+		val := make([]float32, s.n)
+		for i := 0; i < s.n; i++ {
+			ri[i] = int32(binary.LittleEndian.Uint32(idx[8*i:]))
+			ci[i] = int32(binary.LittleEndian.Uint32(idx[8*i+4:]))
+			val[i] = math.Float32frombits(binary.LittleEndian.Uint32(vals[4*i:]))
+		}
+		if gpu_SparseAddF32(y, s.cols, s.rows, ci, ri, val, x) { return nil }
+	}
+	for i := 0; i < s.n; i++ {
+		r := int(int32(binary.LittleEndian.Uint32(idx[8*i:])))
+		c := int(int32(binary.LittleEndian.Uint32(idx[8*i+4:])))
+		if r < 0 || r >= s.rows || c < 0 || c >= s.cols { continue }
+		y[c] += math.Float32frombits(binary.LittleEndian.Uint32(vals[4*i:])) * x[r]
+	}
+	return nil
+}
+
+func (s *csrS) applyT(y, x []float32) error {
+	return s.each(func(r, c int, v float32) { y[c] += v * x[r] })
+}
@@ -0,0 +1,88 @@
+package cawsf
+
+import (
+	"testing"
+
+	"github.com/qrv0/crow/internal/convert"
+)
+
+// checkTransposedMatchesReconstruct verifies y = W^T*x on scope 0 against
+// the transpose of the dense reconstruction.
+func checkTransposedMatchesReconstruct(t *testing.T, name string, bank []byte, pool *CodebookPool) {
+	t.Helper()
+	rows, cols, w, err := ReconstructForScopeWithPool(bank, pool, 0)
+	if err != nil { t.Fatalf("%s: reconstruct: %v", name, err) }
+	x := randVec(rows, 12)
+	want := make([]float32, cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ { want[j] += w[i*cols+j] * x[i] }
+	}
+	got, r, c, err := MultiplyScopeTransposed(bank, pool, 0, x)
+	if err != nil { t.Fatalf("%s: %v", name, err) }
+	if r != rows || c != cols || len(got) != cols { t.Fatalf("%s: shape %dx%d, %d outputs", name, r, c, len(got)) }
+	for j := range want {
+		if absf(got[j]-want[j]) > 1e-3*(1+absf(want[j])) { t.Fatalf("%s: y[%d] got %f want %f", name, j, got[j], want[j]) }
+	}
+	if _, _, _, err := MultiplyScopeTransposed(bank, pool, 0, x[:rows-1]); err == nil { t.Errorf("%s: short input accepted", name) }
+}
+
+func TestTransposedMatchesReconstruct(t *testing.T) {
+	// cols not a multiple of d or of the int group leaves partial blocks
+	rows, cols := 24, 37
+	for _, c := range []struct {
+		name string
+		cfg  convert.Config
+	}{
+		{"row pq, csr", convert.Config{Rank: 2, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16}},
+		{"flat pq, triplets", convert.Config{Rank: 2, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16, RLayout: convert.LayoutFlat, SEncoding: convert.SEncTriplet}},
+		{"opq", convert.Config{Rank: 1, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16, Rotation: convert.RotationOPQ}},
+		{"hadamard", convert.Config{Rank: 1, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 16, Rotation: convert.RotationHadamard}},
+		{"rvq", convert.Config{Rank: 1, OutlierQuantile: 0.9, PQm: 4, PQk: 8, PQd: 8, Codec: convert.CodecRVQ, RVQStages: 2}},
+		{"int4 zp", convert.Config{Rank: 1, OutlierQuantile: 0.9, Codec: convert.CodecInt4, IntGroup: 8, IntZeroPoint: true}},
+		{"int8", convert.Config{Rank: 1, OutlierQuantile: 0.9, Codec: convert.CodecInt8, IntGroup: 16}},
+		{"raw", convert.Config{StoreRaw: true}},
+	} {
+		bank, _ := convertBank(t, rows, cols, c.cfg)
+		checkTransposedMatchesReconstruct(t, c.name, bank, nil)
+	}
+}
+
+func TestTransposedSharedCodebook(t *testing.T) {
+	// the row-layout shard of TestRowAlignedRSharedCodebook: [1 2 3; 3 4 1]
+	pool := &CodebookPool{Entries: map[uint16]CodebookEntry{7: {ID: 7, D: 2, M: 1, K: 2, Data: []float32{1, 2, 3, 4}}}}
+	p := []byte{2, 0, 0, 0, 3, 0, 0, 0, 2, 0, 1, 0, 2, 0, 8, rFlagSharedCB, 7, 0}
+	p = append(p, 0, 1, 1, 0)
+	bank := pack(shRRow, 0, p)
+	y, _, _, err := MultiplyScopeTransposed(bank, pool, 0, []float32{1, -1})
+	if err != nil { t.Fatal(err) }
+	if y[0] != -2 || y[1] != -2 || y[2] != 2 { t.Fatalf("y = %v", y) }
+	checkTransposedMatchesReconstruct(t, "shared", bank, pool)
+}
+
+func TestTransposedWorkersDeterministic(t *testing.T) {
+	// large enough that the column split kicks in
+	rows, cols := 256, 320
+	cfgs := []convert.Config{
+		{Rank: 2, OutlierQuantile: 0.3, PQm: 4, PQk: 16, PQd: 16},
+		{Rank: 2, OutlierQuantile: 0.95, PQm: 4, PQk: 16, PQd: 16, Rotation: convert.RotationHadamard},
+		{Rank: 2, OutlierQuantile: 0.95, Codec: convert.CodecInt4, IntGroup: 32},
+	}
+	x := randVec(rows, 6)
+	for ci, cfg := range cfgs {
+		bank, _ := convertBank(t, rows, cols, cfg)
+		var want []float32
+		for _, w := range []int{1, 2, 8} {
+			withWorkers(t, w, func() {
+				y, _, _, err := MultiplyScopeTransposed(bank, nil, 0, x)
+				if err != nil { t.Fatalf("cfg %d: %v", ci, err) }
+				if want == nil {
+					want = y
+					return
+				}
+				for i := range want {
+					if y[i] != want[i] { t.Fatalf("cfg %d workers %d y[%d] = %v, serial %v", ci, w, i, y[i], want[i]) }
+				}
+			})
+		}
+	}
+}
@@ -5,11 +5,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
-	"sort"
-
-	"gonum.org/v1/gonum/mat"
 
 	"github.com/qrv0/crow/internal/quant"
+	"gonum.org/v1/gonum/mat"
 )
 
 type LayerSpec struct {
@@ -21,10 +19,152 @@ type LayerSpec struct {
 }
 
 type Config struct {
-	Rank            int
-	OutlierQuantile float64
-	PQm             int
-	PQk             int
+	Rank             int
+	OutlierStrategy  string  // see Outliers*; "" = quantile
+	OutlierQuantile  float64 // quantile strategy
+	OutlierK         int     // row-topk / col-topk: outliers per row or column
+	OutlierThreshold float64 // threshold strategy: minimum |residue|
+	OutlierBudget    int     // budget strategy: outliers per layer
+	PQm              int
+	PQk              int
+	PQd              int    // R sub-vector (block) dimension; 0 = 128
+	PQfp16           bool   // store R codebooks as fp16 (row layout only)
+	PQGroup          string // share one R codebook per group: "" (off), PQGroupRole or a literal name
+	RLayout          string // R block layout: "row" (default) or "flat"
+	SEncoding        string // S encoding: "csr" (default, fp16 values), "csr-bf16" or "triplet"
+	Codec            string // R codec: "pq" (default), "rvq", "int4" or "int8"
+	RVQStages        int    // rvq: residual stages; 0 = 2
+	IntGroup         int    // int4/int8: columns per scale group; 0 = 64
+	IntZeroPoint     bool   // int4/int8: asymmetric groups with a zero-point
+	StoreRaw         bool   // skip NDSQ and store the tensor as a single fp16 L shard
+	Seed             int64  // k-means seed; 0 = 1234
+	KMeansBatch      int    // mini-batch size; 0 = auto (above 262144 vectors), < 0 = always full batch
+	Rotation         string // rotate R blocks before PQ: "" (none), "opq" or "hadamard"; row layout, pq codec
+}
+
+// codec names accepted in Config.Codec
+const (
+	CodecPQ   = "pq"
+	CodecRVQ  = "rvq"  // residual PQ, row layout only
+	CodecInt4 = "int4" // group-wise scalar quantization, no codebook
+	CodecInt8 = "int8"
+	CodecRaw  = "raw"  // reported in META for StoreRaw layers
+)
+
+// R block layouts accepted in Config.RLayout
+const (
+	LayoutRow  = "row"  // blocks tile each row; the last block of a row is zero-padded
+	LayoutFlat = "flat" // legacy: blocks cut the flattened matrix, padding only the tail
+)
+
+// shard type ids, see internal/cawsf
+const (
+	TypeL    = 0
+	TypeR    = 1 // flat-layout PQ
+	TypeS    = 2
+	TypeD    = 3
+	TypeRRow = 4 // row-aligned PQ
+	TypeSCSR = 5 // CSR outliers
+	TypeRRVQ = 6 // row-aligned residual PQ
+	TypeRInt = 7 // group-wise int4/int8
+)
+
+// flags byte of a row-aligned R shard
+const (
+	RFlagSharedCB = 1 << 0 // codes reference a CODEBOOKS entry by cb_id
+	RFlagCBFP16   = 1 << 1 // the embedded codebook is fp16
+	RFlagRotation = 1 << 2 // a rotation block follows the header
+)
+
+// block rotations accepted in Config.Rotation
+const (
+	RotationNone     = "none"
+	RotationOPQ      = "opq"      // learned per layer
+	RotationHadamard = "hadamard" // randomized, from Config.Seed; d must be a power of two
+)
+
+func validRotation(r string) bool { return r == "" || r == RotationNone || r == RotationOPQ || r == RotationHadamard }
+
+func (c Config) rotated() bool { return c.Rotation == RotationOPQ || c.Rotation == RotationHadamard }
+
+// S encodings accepted in Config.SEncoding
+const (
+	SEncCSR     = "csr"      // row pointers, varint column gaps, fp16 values
+	SEncCSRBF16 = "csr-bf16" // as csr with bf16 values
+	SEncTriplet = "triplet"  // legacy (i32 row, i32 col, f32 value)
+)
+
+func validLayout(l string) bool { return l == "" || l == LayoutRow || l == LayoutFlat }
+
+func validSEncoding(e string) bool {
+	switch e {
+	case "", SEncCSR, SEncCSRBF16, SEncTriplet:
+		return true
+	}
+	return false
+}
+
+func validCodec(c string) bool {
+	switch c {
+	case "", CodecPQ, CodecRVQ, CodecInt4, CodecInt8:
+		return true
+	}
+	return false
+}
+
+// Describe returns the effective settings as recorded in META so a conversion
+// can be reproduced layer by layer.
+func (c Config) Describe() map[string]any {
+	if c.StoreRaw {
+		return map[string]any{"codec": CodecRaw}
+	}
+	codec := c.Codec
+	if codec == "" { codec = CodecPQ }
+	layout := c.RLayout
+	if layout == "" { layout = LayoutRow }
+	senc := c.SEncoding
+	if senc == "" { senc = SEncCSR }
+	m := map[string]any{
+		"codec":     codec,
+		"r_layout":  layout,
+		"s_enc":     senc,
+		"pq_d":      c.blockDim(),
+		"rank":      c.Rank,
+		"pq_m":      c.PQm,
+		"pq_k":      c.PQk,
+	}
+	if c.PQfp16 { m["pq_fp16"] = true }
+	if c.PQGroup != "" { m["pq_group"] = c.PQGroup }
+	if codec == CodecRVQ { m["rvq_stages"] = c.rvqStages() }
+	if c.rotated() { m["rotation"] = c.Rotation }
+	if codec == CodecPQ || codec == CodecRVQ {
+		m["seed"] = c.seed()
+		if c.KMeansBatch != 0 { m["kmeans_batch"] = c.KMeansBatch }
+	}
+	if c.intCodec() {
+		// no codebook: the PQ settings do not apply
+		delete(m, "pq_d")
+		delete(m, "pq_m")
+		delete(m, "pq_k")
+		delete(m, "r_layout")
+		m["int_group"] = c.intGroup()
+		m["int_zp"] = c.IntZeroPoint
+	}
+	switch c.OutlierStrategy {
+	case "", OutliersQuantile:
+		m["outliers"] = OutliersQuantile
+		m["outlier_q"] = c.OutlierQuantile
+	case OutliersRowTopK, OutliersColTopK:
+		m["outliers"] = c.OutlierStrategy
+		m["outlier_k"] = c.OutlierK
+	case OutliersThreshold:
+		m["outliers"] = c.OutlierStrategy
+		m["outlier_threshold"] = c.OutlierThreshold
+	case OutliersBudget:
+		m["outliers"] = c.OutlierStrategy
+		m["outlier_budget"] = c.OutlierBudget
+	}
+	return m
 }
 
 type Shard struct {
@@ -41,8 +181,8 @@ type Result struct {
 
 // Decompose NDSQ: returns D, L, R, S
 // Implementation: L via truncated SVD using gonum; D from diagonal of (W-L);
-// S via outlier quantile from residual after removing L and D; R is remaining dense residual.
-func decomposeNDSQ(rows, cols int, data []float32, rank int, outlierQ float64) (D, L, R []float32, Sind [][2]int32, Sval []float32, err error) {
+// S via selectOutliers from residual after removing L and D; R is remaining dense residual.
+func decomposeNDSQ(rows, cols int, data []float32, cfg Config) (D, L, R []float32, Sind [][2]int32, Sval []float32, err error) {
 	// Build float64 matrix for SVD
 	A := make([]float64, rows*cols)
 	for i := range data { A[i] = float64(data[i]) }
@@ -56,7 +196,7 @@ func decomposeNDSQ(rows, cols int, data []float32, rank int, outlierQ float64) (
 		return nil, nil, nil, nil, nil, fmt.Errorf("svd factorization failed")
 	}
 	s := svd.Values(nil)
-	r := rank
+	r := cfg.Rank
 	if r > len(s) { r = len(s) }
 	if r < 0 { r = 0 }
 	// Get U_r and V_r
@@ -89,25 +229,14 @@ func decomposeNDSQ(rows, cols int, data []float32, rank int, outlierQ float64) (
 		D[idx] = resid[idx]
 		resid[idx] = 0
 	}
-	// S: outliers from resid via quantile
+	// S: outliers from resid, chosen by cfg.OutlierStrategy
 	abs := make([]float64, len(resid))
 	for i := range resid { abs[i] = math.Abs(float64(resid[i])) }
-	sorted := append([]float64(nil), abs...)
-	sort.Float64s(sorted)
-	th := 0.0
-	if len(sorted) > 0 {
-		qidx := int(float64(len(sorted)-1) * outlierQ)
-		if qidx < 0 { qidx = 0 }
-		if qidx >= len(sorted) { qidx = len(sorted)-1 }
-		th = sorted[qidx]
-	}
-	mask := make([]bool, len(resid))
+	mask, err := selectOutliers(abs, rows, cols, cfg)
+	if err != nil { return nil, nil, nil, nil, nil, err }
 	cnt := 0
-	for i, v := range abs {
-		if v >= th && v != 0 {
-			mask[i] = true
-			cnt++
-		}
+	for _, sel := range mask {
+		if sel { cnt++ }
 	}
 	Sind = make([][2]int32, 0, cnt)
 	Sval = make([]float32, 0, cnt)
@@ -126,36 +255,9 @@ func decomposeNDSQ(rows, cols int, data []float32, rank int, outlierQ float64) (
 }
 
 func fp16bytes(f []float32) []byte {
-	buf := new(bytes.Buffer)
-	for _, v := range f {
-		buf.Write(fp32to16(v))
-	}
-	return buf.Bytes()
-}
-
-func fp32to16(f float32) []byte {
-	// simple float32->float16 conversion (no denormal handling), little-endian
-	u := math.Float32bits(f)
-	sign := (u >> 31) & 0x1
-	exp := (u >> 23) & 0xFF
-	mant := u & 0x7FFFFF
-	var h uint16
-	if exp == 0 { // zero or subnormal -> zero
-		h = uint16(sign << 15)
-	} else if exp == 0xFF { // inf/nan -> inf
-		h = uint16((sign << 15) | (0x1F << 10))
-	} else {
-		e := int(exp) - 127 + 15
-		if e <= 0 { // underflow -> zero
-			h = uint16(sign << 15)
-		} else if e >= 0x1F { // overflow -> inf
-			h = uint16((sign << 15) | (0x1F << 10))
-		} else {
-			h = uint16((sign << 15) | (uint32(e)&0x1F)<<10 | ((mant >> 13) & 0x3FF))
-		}
-	}
-	b := []byte{byte(h & 0xFF), byte(h >> 8)}
-	return b
+	out := make([]byte, 0, 2*len(f))
+	for _, v := range f { out = binary.LittleEndian.AppendUint16(out, quant.FP16FromFloat32(v)) }
+	return out
 }
 
 // Pack a shard header and payload (type:uint8, scope:uint16, comp:uint8, usize:uint32, csize:uint32)
@@ -173,7 +275,55 @@ func packShard(t uint8, scope uint16, payload []byte) []byte {
 
 // Convert a single layer tensor
 func ConvertLayer(spec LayerSpec, cfg Config) ([]Shard, error) {
-	D, L, R, Sind, Sval, err := decomposeNDSQ(spec.Rows, spec.Cols, spec.Data, cfg.Rank, cfg.OutlierQuantile)
+	res, err := ConvertLayerWithMeta(spec, cfg)
+	if err != nil { return nil, err }
+	return res.Shards, nil
+}
+
+// ConvertLayerWithMeta converts a layer and also reports per-layer statistics
+// for META: s_nnz and s_density (outliers / elements).
+func ConvertLayerWithMeta(spec LayerSpec, cfg Config) (*Result, error) {
+	if err := cfg.validate(); err != nil { return nil, err }
+	if cfg.StoreRaw { return &Result{Shards: []Shard{rawShard(spec)}, Meta: map[string]any{}}, nil }
+	dec, err := Decompose(spec, cfg)
+	if err != nil { return nil, err }
+	return dec.Encode(nil)
+}
+
+func (cfg Config) validate() error {
+	if !validCodec(cfg.Codec) { return fmt.Errorf("unknown codec %q", cfg.Codec) }
+	if !validLayout(cfg.RLayout) { return fmt.Errorf("unknown R layout %q", cfg.RLayout) }
+	if !validSEncoding(cfg.SEncoding) { return fmt.Errorf("unknown S encoding %q", cfg.SEncoding) }
+	if !validOutlierStrategy(cfg.OutlierStrategy) { return fmt.Errorf("unknown outlier strategy %q", cfg.OutlierStrategy) }
+	if cfg.PQk > quant.MaxK { return fmt.Errorf("pq_k %d exceeds %d", cfg.PQk, quant.MaxK) }
+	if cfg.PQfp16 && !cfg.rowLayout() { return fmt.Errorf("fp16 codebooks need the row R layout") }
+	if cfg.Codec == CodecRVQ && !cfg.rowLayout() { return fmt.Errorf("rvq needs the row R layout") }
+	if cfg.RVQStages < 0 || cfg.RVQStages > 255 { return fmt.Errorf("rvq_stages %d out of range", cfg.RVQStages) }
+	if cfg.IntGroup < 0 || cfg.IntGroup > 65535 { return fmt.Errorf("int_group %d out of range", cfg.IntGroup) }
+	if !validRotation(cfg.Rotation) { return fmt.Errorf("unknown rotation %q", cfg.Rotation) }
+	if cfg.rotated() && (!cfg.rowLayout() || (cfg.Codec != "" && cfg.Codec != CodecPQ)) { return fmt.Errorf("rotation needs the pq codec with the row R layout") }
+	if cfg.Rotation == RotationOPQ && cfg.PQGroup != "" { return fmt.Errorf("opq rotations are per layer and cannot share a group codebook") }
+	return nil
+}
+
+// Decomposed is a layer split into D, L and S shards whose R residue has not
+// been product-quantized yet, so several layers can share one codebook.
+type Decomposed struct {
+	Spec   LayerSpec
+	Config Config
+	shards []Shard // D, L, S
+	resid  []float32
+	Meta   map[string]any // as Result.Meta
+}
+
+// Residue returns the dense R left after removing D, L and S, row-major.
+func (d *Decomposed) Residue() []float32 { return d.resid }
+
+// Decompose runs the NDSQ split and encodes everything but R.
+func Decompose(spec LayerSpec, cfg Config) (*Decomposed, error) {
+	if err := cfg.validate(); err != nil { return nil, err }
+	if cfg.StoreRaw { return nil, fmt.Errorf("store_raw layers are not decomposed") }
+	D, L, R, Sind, Sval, err := decomposeNDSQ(spec.Rows, spec.Cols, spec.Data, cfg)
 	if err != nil { return nil, err }
 	var shards []Shard
 	// D shard: shape + fp16
@@ -188,41 +338,41 @@ func ConvertLayer(spec LayerSpec, cfg Config) ([]Shard, error) {
 	binary.Write(lb, binary.LittleEndian, uint32(spec.Cols))
 	lb.Write(fp16bytes(L))
 	shards = append(shards, Shard{Type: 0, Scope: spec.Scope, Comp: 0, Data: lb.Bytes()})
-	// R shard: PQ
-	d := 128
-	flat := make([]float32, len(R))
-	copy(flat, R)
-	pad := (d - (len(flat)%d)) % d
-	if pad != 0 { flat = append(flat, make([]float32, pad)...)}
-	N := len(flat)/d
-	data := make([][]float32, N)
-	for i := 0; i < N; i++ { data[i] = flat[i*d:(i+1)*d] }
-	m := cfg.PQm
-	if d % m != 0 { m = d/8 }
-	pq := quant.TrainPQ(data, m, cfg.PQk, 25, 1234)
-	codes := pq.Encode(data)
-	// pack: rows, cols, d, m, k, n, codebooks, codes
-	rb := new(bytes.Buffer)
-	binary.Write(rb, binary.LittleEndian, uint32(spec.Rows))
-	binary.Write(rb, binary.LittleEndian, uint32(spec.Cols))
-	binary.Write(rb, binary.LittleEndian, uint16(d))
-	binary.Write(rb, binary.LittleEndian, uint16(m))
-	binary.Write(rb, binary.LittleEndian, uint16(pq.K))
-	binary.Write(rb, binary.LittleEndian, uint32(N))
-	// codebooks flattened per subvector
-	for i := 0; i < m; i++ { rb.Write(float32SliceToBytes(pq.Codebooks[i])) }
-	// codes
-	for i := 0; i < N; i++ { rb.Write(codes[i]) }
-	shards = append(shards, Shard{Type: 1, Scope: spec.Scope, Comp: 0, Data: rb.Bytes()})
-	// S shard: rows, cols, n, idx(i32,i32), vals(f32)
-	sb := new(bytes.Buffer)
-	binary.Write(sb, binary.LittleEndian, uint32(spec.Rows))
-	binary.Write(sb, binary.LittleEndian, uint32(spec.Cols))
-	binary.Write(sb, binary.LittleEndian, uint32(len(Sind)))
-	for _, ij := range Sind { binary.Write(sb, binary.LittleEndian, ij) }
-	sb.Write(float32SliceToBytes(Sval))
-	shards = append(shards, Shard{Type: 2, Scope: spec.Scope, Comp: 0, Data: sb.Bytes()})
-	return shards, nil
+	// S shard: outliers
+	shards = append(shards, encodeS(spec, Sind, Sval, cfg))
+	meta := map[string]any{"s_nnz": len(Sind), "s_density": float64(len(Sind)) / float64(spec.Rows*spec.Cols)}
+	spec.Data = nil
+	return &Decomposed{Spec: spec, Config: cfg, shards: shards, resid: R, Meta: meta}, nil
+}
+
+// Encode product-quantizes the residue with pq, or with a codebook trained on
+// this layer alone when pq is nil, and returns the shards in D, L, R, S order.
+func (d *Decomposed) Encode(pq *quant.PQ) (*Result, error) {
+	var rs Shard
+	var err error
+	switch {
+	case pq != nil && d.Config.Codec != "" && d.Config.Codec != CodecPQ:
+		return nil, fmt.Errorf("%s: %s layers cannot use a shared codebook", d.Spec.Name, d.Config.Codec)
+	case d.Config.Codec == CodecRVQ:
+		rs, err = encodeRVQ(d.Spec, d.resid, d.Config)
+	case d.Config.intCodec():
+		rs = encodeRInt(d.Spec, d.resid, d.Config)
+	default:
+		rs, err = encodeR(d.Spec, d.resid, d.Config, pq)
+	}
+	if err != nil { return nil, err }
+	shards := append(append(d.shards[:2:2], rs), d.shards[2])
+	return &Result{Shards: shards, Meta: d.Meta}, nil
+}
+
+// rawShard stores the whole tensor as one fp16 L shard; every reader already
+// decodes it, so no new shard type is needed.
+func rawShard(spec LayerSpec) Shard {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, uint32(spec.Rows))
+	binary.Write(b, binary.LittleEndian, uint32(spec.Cols))
+	b.Write(fp16bytes(spec.Data))
+	return Shard{Type: 0, Scope: spec.Scope, Comp: 0, Data: b.Bytes()}
 }
 
 func float32SliceToBytes(a []float32) []byte {
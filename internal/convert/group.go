@@ -0,0 +1,67 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// PQGroupRole in Config.PQGroup groups layers by Role, so every q_proj shares
+// one codebook, every down_proj another, and so on. Any other non-empty value
+// is used as the group name itself.
+const PQGroupRole = "role"
+
+// groupSamplesPerLayer caps how many R blocks each member contributes to the
+// pooled k-means training set.
+const groupSamplesPerLayer = 4096
+
+// Role returns the last name component before a .weight/.bias suffix:
+// "model.layers.3.self_attn.q_proj.weight" -> "q_proj".
+func Role(name string) string {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".weight"), ".bias")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 { return name[i+1:] }
+	return name
+}
+
+// GroupKey returns the shared-codebook group of a tensor, or "" when its R
+// codebook is trained alone. Only the pq codec groups; layers only share a
+// codebook when their block shape agrees and their blocks are rotated the
+// same way, so the key includes d, m, k, the layout and the rotation (with
+// its seed for Hadamard).
+func GroupKey(name string, rows, cols int, cfg Config) string {
+	if cfg.PQGroup == "" || cfg.StoreRaw || (cfg.Codec != "" && cfg.Codec != CodecPQ) { return "" }
+	g := cfg.PQGroup
+	if g == PQGroupRole { g = Role(name) }
+	d, m, _, _ := pqShape(rows, cols, cfg)
+	layout := LayoutRow
+	if !cfg.rowLayout() { layout = LayoutFlat }
+	rot := RotationNone
+	switch cfg.Rotation {
+	case RotationHadamard:
+		rot = fmt.Sprintf("%s%d", RotationHadamard, cfg.seed())
+	case RotationOPQ:
+		rot = RotationOPQ
+	}
+	return fmt.Sprintf("%s/%s/d%d/m%d/k%d/%s", g, layout, d, m, cfg.PQk, rot)
+}
+
+// TrainGroupPQ trains one codebook on R blocks pooled from all members, which
+// must share a GroupKey. Each member contributes at most
+// groupSamplesPerLayer evenly spaced blocks.
+func TrainGroupPQ(members []*Decomposed) (*quant.PQ, error) {
+	if len(members) == 0 { return nil, nil }
+	cfg := members[0].Config
+	_, m, _, _ := pqShape(members[0].Spec.Rows, members[0].Spec.Cols, cfg)
+	var samples [][]float32
+	for _, d := range members {
+		// Hadamard rotations depend only on d and the seed, both in the key,
+		// so members agree
+		blocks, _, err := residueBlocks(d.Spec, d.resid, d.Config)
+		if err != nil { return nil, err }
+		step := 1
+		if len(blocks) > groupSamplesPerLayer { step = (len(blocks) + groupSamplesPerLayer - 1) / groupSamplesPerLayer }
+		for i := 0; i < len(blocks); i += step { samples = append(samples, blocks[i]) }
+	}
+	return quant.TrainPQWith(samples, m, cfg.PQk, cfg.kmeansOptions(len(samples))), nil
+}
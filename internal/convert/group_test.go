@@ -0,0 +1,66 @@
+package convert
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randSpec(name string, rows, cols int, seed int64) LayerSpec {
+	rng := rand.New(rand.NewSource(seed))
+	data := make([]float32, rows*cols)
+	for i := range data { data[i] = float32(rng.NormFloat64()) }
+	return LayerSpec{Name: name, Rows: rows, Cols: cols, Data: data}
+}
+
+func TestRole(t *testing.T) {
+	for name, want := range map[string]string{
+		"model.layers.3.self_attn.q_proj.weight": "q_proj",
+		"model.layers.0.mlp.down_proj.bias":      "down_proj",
+		"lm_head.weight":                         "lm_head",
+		"toy":                                    "toy",
+	} {
+		if got := Role(name); got != want { t.Errorf("Role(%q) = %q want %q", name, got, want) }
+	}
+}
+
+func TestGroupSharesCodebook(t *testing.T) {
+	cfg := Config{Rank: 1, OutlierQuantile: 0.99, PQm: 2, PQk: 8, PQd: 8, PQGroup: PQGroupRole}
+	a := randSpec("model.layers.0.mlp.up_proj.weight", 16, 24, 1)
+	b := randSpec("model.layers.1.mlp.up_proj.weight", 16, 24, 2)
+	ka, kb := GroupKey(a.Name, a.Rows, a.Cols, cfg), GroupKey(b.Name, b.Rows, b.Cols, cfg)
+	if ka == "" || ka != kb { t.Fatalf("keys %q %q", ka, kb) }
+	if k := GroupKey("model.layers.0.mlp.down_proj.weight", 16, 24, cfg); k == ka { t.Fatalf("down_proj shares key %q", k) }
+	if k := GroupKey(a.Name, a.Rows, a.Cols, Config{PQm: 2, PQk: 8, PQd: 8}); k != "" { t.Fatalf("ungrouped key %q", k) }
+	// rotated blocks only pool with blocks rotated by the same seed
+	had := cfg
+	had.Rotation = RotationHadamard
+	kh := GroupKey(a.Name, a.Rows, a.Cols, had)
+	had.Seed = 99
+	if kh == ka || kh == GroupKey(a.Name, a.Rows, a.Cols, had) { t.Fatalf("rotation not in key: %q %q", ka, kh) }
+
+	var members []*Decomposed
+	for _, s := range []LayerSpec{a, b} {
+		d, err := Decompose(s, cfg)
+		if err != nil { t.Fatal(err) }
+		members = append(members, d)
+	}
+	pq, err := TrainGroupPQ(members)
+	if err != nil { t.Fatal(err) }
+	var cbs [][]byte
+	for _, d := range members {
+		res, err := d.Encode(pq)
+		if err != nil { t.Fatal(err) }
+		if len(res.Shards) != 4 { t.Fatalf("%d shards", len(res.Shards)) }
+		r := res.Shards[2]
+		if r.Type != TypeRRow { t.Fatalf("shard 2 is type %d", r.Type) }
+		// rows, cols, d, m, k, bits, flags, then m*k*dsub f32
+		cbs = append(cbs, r.Data[16:16+8*8*4])
+	}
+	if !bytes.Equal(cbs[0], cbs[1]) { t.Fatal("group members embed different codebooks") }
+
+	// a layer whose block shape differs cannot use the group codebook
+	c, err := Decompose(randSpec("x", 16, 24, 3), Config{Rank: 1, OutlierQuantile: 0.99, PQm: 4, PQk: 8, PQd: 8})
+	if err != nil { t.Fatal(err) }
+	if _, err := c.Encode(pq); err == nil { t.Fatal("expected shape mismatch error") }
+}
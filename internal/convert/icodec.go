@@ -0,0 +1,101 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// RFlagIntZeroPoint marks a type-7 shard whose groups carry a zero-point.
+const RFlagIntZeroPoint = 1 << 0
+
+func (c Config) intCodec() bool { return c.Codec == CodecInt4 || c.Codec == CodecInt8 }
+
+func (c Config) intBits() int {
+	if c.Codec == CodecInt4 { return 4 }
+	return 8
+}
+
+func (c Config) intGroup() int {
+	if c.IntGroup > 0 { return c.IntGroup }
+	return 64
+}
+
+// intRowBytes is the byte length of one packed row; rows start byte-aligned.
+func intRowBytes(cols, bits int) int { return (cols*bits + 7) / 8 }
+
+// encodeRInt quantizes the residue row by row in groups of G columns (type 7):
+//
+//	rows:u32 cols:u32 bits:u8 flags:u8 group:u16
+//	scales:[rows][ceil(cols/G)]f16
+//	zeros:[rows][ceil(cols/G)]u8 if flags&RFlagIntZeroPoint
+//	q:[rows][ceil(cols*bits/8)]u8, LSB first
+//
+// Symmetric groups store signed q with value = scale*q; zero-point groups
+// store unsigned q with value = scale*(q-zero).
+func encodeRInt(spec LayerSpec, R []float32, cfg Config) Shard {
+	rows, cols := spec.Rows, spec.Cols
+	bits, g := cfg.intBits(), cfg.intGroup()
+	ng := (cols + g - 1) / g
+	rowBytes := intRowBytes(cols, bits)
+	scales := make([]float32, rows*ng)
+	zeros := make([]byte, rows*ng)
+	q := make([]byte, rows*rowBytes)
+	qmax := float64(int(1)<<(bits-1) - 1) // 7 or 127
+	umax := float64(int(1)<<bits - 1)     // 15 or 255
+	for r := 0; r < rows; r++ {
+		row := R[r*cols : (r+1)*cols]
+		out := q[r*rowBytes : (r+1)*rowBytes]
+		for gi := 0; gi < ng; gi++ {
+			c0, c1 := gi*g, min((gi+1)*g, cols)
+			lo, hi := math.Inf(1), math.Inf(-1)
+			for _, v := range row[c0:c1] {
+				lo = math.Min(lo, float64(v))
+				hi = math.Max(hi, float64(v))
+			}
+			var scale, zero float64
+			if cfg.IntZeroPoint {
+				lo, hi = math.Min(lo, 0), math.Max(hi, 0)
+				scale = (hi - lo) / umax
+				if scale > 0 { zero = math.Min(math.Max(math.Round(-lo/scale), 0), umax) }
+			} else {
+				scale = math.Max(math.Abs(lo), math.Abs(hi)) / qmax
+			}
+			// quantize against the scale readers will see
+			scale = float64(fp16Round(float32(scale)))
+			scales[r*ng+gi] = float32(scale)
+			zeros[r*ng+gi] = byte(zero)
+			for c := c0; c < c1; c++ {
+				var v float64
+				if scale > 0 { v = math.Round(float64(row[c])/scale) }
+				var code int
+				if cfg.IntZeroPoint {
+					code = int(math.Min(math.Max(v+zero, 0), umax))
+				} else {
+					code = int(math.Min(math.Max(v, -qmax-1), qmax)) & (1<<bits - 1)
+				}
+				if bits == 8 {
+					out[c] = byte(code)
+				} else {
+					out[c/2] |= byte(code) << (4 * (c & 1))
+				}
+			}
+		}
+	}
+	var flags byte
+	if cfg.IntZeroPoint { flags |= RFlagIntZeroPoint }
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, uint32(rows))
+	binary.Write(b, binary.LittleEndian, uint32(cols))
+	b.Write([]byte{byte(bits), flags})
+	binary.Write(b, binary.LittleEndian, uint16(g))
+	b.Write(fp16bytes(scales))
+	if cfg.IntZeroPoint { b.Write(zeros) }
+	b.Write(q)
+	return Shard{Type: TypeRInt, Scope: spec.Scope, Comp: 0, Data: b.Bytes()}
+}
+
+// fp16Round returns f as it reads back from half precision.
+func fp16Round(f float32) float32 { return quant.FP16ToFloat32(quant.FP16FromFloat32(f)) }
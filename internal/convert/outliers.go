@@ -0,0 +1,119 @@
+package convert
+
+import "fmt"
+
+// outlier selection strategies accepted in Config.OutlierStrategy
+const (
+	OutliersQuantile  = "quantile"  // global: |r| >= the OutlierQuantile quantile (default)
+	OutliersRowTopK   = "row-topk"  // the OutlierK largest |r| of every row
+	OutliersColTopK   = "col-topk"  // the OutlierK largest |r| of every column
+	OutliersThreshold = "threshold" // |r| >= OutlierThreshold
+	OutliersBudget    = "budget"    // the OutlierBudget largest |r| overall
+)
+
+func validOutlierStrategy(s string) bool {
+	switch s {
+	case "", OutliersQuantile, OutliersRowTopK, OutliersColTopK, OutliersThreshold, OutliersBudget:
+		return true
+	}
+	return false
+}
+
+// selectOutliers marks the residue entries that go to S. abs holds |resid|
+// row-major; zeros are never selected. No strategy sorts the full matrix:
+// thresholds come from quickselect over a scratch copy.
+func selectOutliers(abs []float64, rows, cols int, cfg Config) ([]bool, error) {
+	mask := make([]bool, len(abs))
+	if len(abs) == 0 { return mask, nil }
+	switch cfg.OutlierStrategy {
+	case "", OutliersQuantile:
+		q := cfg.OutlierQuantile
+		qidx := int(float64(len(abs)-1) * q)
+		if qidx < 0 { qidx = 0 }
+		if qidx >= len(abs) { qidx = len(abs)-1 }
+		th := selectKth(append([]float64(nil), abs...), qidx)
+		for i, v := range abs {
+			if v >= th && v != 0 { mask[i] = true }
+		}
+	case OutliersThreshold:
+		for i, v := range abs {
+			if v >= cfg.OutlierThreshold && v != 0 { mask[i] = true }
+		}
+	case OutliersBudget:
+		idx := make([]int, len(abs))
+		for i := range idx { idx[i] = i }
+		topK(abs, idx, cfg.OutlierBudget, make([]float64, len(abs)), mask)
+	case OutliersRowTopK:
+		idx := make([]int, cols)
+		scratch := make([]float64, cols)
+		for r := 0; r < rows; r++ {
+			for c := range idx { idx[c] = r*cols + c }
+			topK(abs, idx, cfg.OutlierK, scratch, mask)
+		}
+	case OutliersColTopK:
+		idx := make([]int, rows)
+		scratch := make([]float64, rows)
+		for c := 0; c < cols; c++ {
+			for r := range idx { idx[r] = r*cols + c }
+			topK(abs, idx, cfg.OutlierK, scratch, mask)
+		}
+	default:
+		return nil, fmt.Errorf("unknown outlier strategy %q", cfg.OutlierStrategy)
+	}
+	return mask, nil
+}
+
+// topK marks at most k of the nonzero abs[idx[...]] with the largest values.
+// Ties at the threshold are taken in idx order so the count is exact.
+func topK(abs []float64, idx []int, k int, scratch []float64, mask []bool) {
+	if k <= 0 { return }
+	if k >= len(idx) {
+		for _, i := range idx {
+			if abs[i] != 0 { mask[i] = true }
+		}
+		return
+	}
+	s := scratch[:len(idx)]
+	for j, i := range idx { s[j] = abs[i] }
+	th := selectKth(s, len(idx)-k)
+	taken := 0
+	for _, i := range idx {
+		if abs[i] > th { mask[i] = true; taken++ }
+	}
+	for _, i := range idx {
+		if taken >= k || th == 0 { break }
+		if abs[i] == th { mask[i] = true; taken++ }
+	}
+}
+
+// selectKth returns the k-th smallest element (0-based) of a, reordering a in
+// place. Hoare partitioning with a median-of-three pivot: O(n) on average.
+func selectKth(a []float64, k int) float64 {
+	lo, hi := 0, len(a)-1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if a[mid] < a[lo] { a[mid], a[lo] = a[lo], a[mid] }
+		if a[hi] < a[lo] { a[hi], a[lo] = a[lo], a[hi] }
+		if a[hi] < a[mid] { a[hi], a[mid] = a[mid], a[hi] }
+		pivot := a[mid]
+		i, j := lo, hi
+		for i <= j {
+			for a[i] < pivot { i++ }
+			for a[j] > pivot { j-- }
+			if i <= j {
+				a[i], a[j] = a[j], a[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case k <= j:
+			hi = j
+		case k >= i:
+			lo = i
+		default:
+			return a[k]
+		}
+	}
+	return a[k]
+}
@@ -0,0 +1,86 @@
+package convert
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randAbs(n int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	a := make([]float64, n)
+	for i := range a {
+		a[i] = rng.NormFloat64()
+		if a[i] < 0 { a[i] = -a[i] }
+	}
+	a[3] = 0
+	return a
+}
+
+func countMask(m []bool) int {
+	n := 0
+	for _, v := range m { if v { n++ } }
+	return n
+}
+
+func TestQuantileMatchesSort(t *testing.T) {
+	abs := randAbs(37*29, 1)
+	for _, q := range []float64{0, 0.5, 0.9, 0.999, 1} {
+		sorted := append([]float64(nil), abs...)
+		sort.Float64s(sorted)
+		th := sorted[int(float64(len(sorted)-1)*q)]
+		mask, err := selectOutliers(abs, 37, 29, Config{OutlierQuantile: q})
+		if err != nil { t.Fatal(err) }
+		for i, v := range abs {
+			if want := v >= th && v != 0; mask[i] != want { t.Fatalf("q=%v: index %d got %v want %v", q, i, mask[i], want) }
+		}
+	}
+}
+
+func TestOutlierStrategies(t *testing.T) {
+	rows, cols := 12, 20
+	abs := randAbs(rows*cols, 2)
+
+	mask, err := selectOutliers(abs, rows, cols, Config{OutlierStrategy: OutliersRowTopK, OutlierK: 3})
+	if err != nil { t.Fatal(err) }
+	for r := 0; r < rows; r++ {
+		row := abs[r*cols : (r+1)*cols]
+		n, minIn, maxOut := 0, 1e9, 0.0
+		for c, v := range row {
+			if mask[r*cols+c] { n++; if v < minIn { minIn = v } } else if v > maxOut { maxOut = v }
+		}
+		if n != 3 || minIn < maxOut { t.Fatalf("row %d: n=%d min in %v max out %v", r, n, minIn, maxOut) }
+	}
+
+	mask, err = selectOutliers(abs, rows, cols, Config{OutlierStrategy: OutliersColTopK, OutlierK: 2})
+	if err != nil { t.Fatal(err) }
+	for c := 0; c < cols; c++ {
+		n := 0
+		for r := 0; r < rows; r++ { if mask[r*cols+c] { n++ } }
+		if n != 2 { t.Fatalf("col %d: %d outliers", c, n) }
+	}
+
+	mask, err = selectOutliers(abs, rows, cols, Config{OutlierStrategy: OutliersBudget, OutlierBudget: 17})
+	if err != nil { t.Fatal(err) }
+	if n := countMask(mask); n != 17 { t.Fatalf("budget: %d outliers", n) }
+
+	mask, err = selectOutliers(abs, rows, cols, Config{OutlierStrategy: OutliersThreshold, OutlierThreshold: 1.5})
+	if err != nil { t.Fatal(err) }
+	for i, v := range abs {
+		if mask[i] != (v >= 1.5) { t.Fatalf("threshold: index %d", i) }
+	}
+
+	if _, err := selectOutliers(abs, rows, cols, Config{OutlierStrategy: "nope"}); err == nil { t.Fatal("expected error for unknown strategy") }
+}
+
+func TestConvertLayerRecordsSDensity(t *testing.T) {
+	rows, cols := 16, 24
+	rng := rand.New(rand.NewSource(5))
+	data := make([]float32, rows*cols)
+	for i := range data { data[i] = float32(rng.NormFloat64()) }
+	cfg := Config{Rank: 2, OutlierStrategy: OutliersRowTopK, OutlierK: 2, PQm: 4, PQk: 8, PQd: 8}
+	res, err := ConvertLayerWithMeta(LayerSpec{Name: "w", Rows: rows, Cols: cols, Data: data}, cfg)
+	if err != nil { t.Fatal(err) }
+	if res.Meta["s_nnz"] != rows*2 { t.Fatalf("s_nnz = %v", res.Meta["s_nnz"]) }
+	if p := PlanLayer("w", rows, cols, cfg); p.SNonzeros != int64(rows*2) { t.Fatalf("plan nnz = %d", p.SNonzeros) }
+}
@@ -0,0 +1,120 @@
+package convert
+
+import "github.com/qrv0/crow/internal/quant"
+
+// shardHeaderSize is the per-shard record header in SHARD_BANK.
+const shardHeaderSize = 12
+
+// LayerPlan is the expected output of ConvertLayer for one tensor, computed
+// from its shape and Config alone. Byte counts include shard headers; S is an
+// estimate because the real outlier count depends on the data.
+type LayerPlan struct {
+	Name   string
+	Rows   int
+	Cols   int
+	Config Config
+
+	LBytes        int64
+	DBytes        int64
+	RCodeBytes    int64 // R shard (header + codes), codebooks excluded
+	CodebookBytes int64 // CODEBOOKS entry for this layer
+	SBytes        int64
+	SNonzeros     int64
+	SUnknown      bool // threshold strategy: S size depends on the data
+
+	SVDFlops    float64
+	KMeansFlops float64
+}
+
+// Total returns the planned output bytes for the layer.
+func (p LayerPlan) Total() int64 {
+	return p.LBytes + p.DBytes + p.RCodeBytes + p.CodebookBytes + p.SBytes
+}
+
+// Flops returns the estimated SVD plus k-means work.
+func (p LayerPlan) Flops() float64 { return p.SVDFlops + p.KMeansFlops }
+
+// PlanLayer estimates shard sizes and compute cost for a rows x cols tensor.
+func PlanLayer(name string, rows, cols int, cfg Config) LayerPlan {
+	p := LayerPlan{Name: name, Rows: rows, Cols: cols, Config: cfg}
+	nelem := int64(rows) * int64(cols)
+	fp16 := shardHeaderSize + 8 + 2*nelem
+	if cfg.StoreRaw {
+		p.LBytes = fp16
+		return p
+	}
+	p.LBytes = fp16
+	p.DBytes = fp16
+	d, m, k, n := pqShape(rows, cols, cfg)
+	// shared-codebook R layouts: rows, cols, d, m, k, then n (flat) or
+	// bits+flags (row-aligned), cb_id and the codes
+	p.RCodeBytes = shardHeaderSize + 20 + int64(quant.PackedLen(n*m, cfg.codeBits(k)))
+	if cfg.rowLayout() { p.RCodeBytes -= 2 }
+	p.CodebookBytes = 12 + int64(k)*int64(d)*int64(cfg.cbElemSize())
+	if cfg.intCodec() {
+		ng := int64((cols + cfg.intGroup() - 1) / cfg.intGroup())
+		per := int64(2)
+		if cfg.IntZeroPoint { per++ }
+		p.RCodeBytes = shardHeaderSize + 12 + int64(rows)*(ng*per+int64(intRowBytes(cols, cfg.intBits())))
+		p.CodebookBytes = 0
+	}
+	if cfg.Codec == CodecRVQ {
+		// every stage embeds its codebook and adds a code array
+		st := int64(cfg.rvqStages())
+		p.RCodeBytes = shardHeaderSize + 18 + st*(int64(quant.PackedLen(n*m, cfg.codeBits(k)))+int64(k)*int64(d)*int64(cfg.cbElemSize()))
+		p.CodebookBytes = 0
+	}
+	p.SNonzeros, p.SUnknown = plannedOutliers(rows, cols, cfg)
+	p.SBytes = sBytes(rows, cols, p.SNonzeros, cfg.SEncoding)
+	// thin SVD (R-SVD, Golub & Van Loan) plus forming L = U_r S_r V_r^T
+	big, small := float64(rows), float64(cols)
+	if small > big { big, small = small, big }
+	r := float64(cfg.Rank)
+	if r > small { r = small }
+	p.SVDFlops = 4*big*small*small + 22*small*small*small + 2*float64(nelem)*r
+	// each Lloyd iteration and the final encode compare n sub-vectors with
+	// k centroids in every one of the m sub-spaces (d/m dims each)
+	p.KMeansFlops = 3 * float64(pqIters+1) * float64(n) * float64(k) * float64(d)
+	if cfg.Codec == CodecRVQ { p.KMeansFlops *= float64(cfg.rvqStages()) }
+	if cfg.intCodec() { p.KMeansFlops = 0 }
+	return p
+}
+
+// plannedOutliers returns the outlier count each strategy yields, ignoring
+// zeros and ties. The threshold strategy cannot be sized from the shape.
+func plannedOutliers(rows, cols int, cfg Config) (int64, bool) {
+	nelem := int64(rows) * int64(cols)
+	clamp := func(n int64) int64 {
+		if n < 0 { return 0 }
+		if n > nelem { return nelem }
+		return n
+	}
+	switch cfg.OutlierStrategy {
+	case OutliersRowTopK:
+		return clamp(int64(rows) * int64(min(cfg.OutlierK, cols))), false
+	case OutliersColTopK:
+		return clamp(int64(cols) * int64(min(cfg.OutlierK, rows))), false
+	case OutliersBudget:
+		return clamp(int64(cfg.OutlierBudget)), false
+	case OutliersThreshold:
+		return 0, true
+	}
+	q := cfg.OutlierQuantile
+	if q < 0 { q = 0 }
+	if q > 1 { q = 1 }
+	// selectOutliers keeps everything at or above the element at index (n-1)*q
+	return nelem - int64(float64(nelem-1)*q), false
+}
+
+// sBytes sizes an S shard with nnz outliers. For CSR the column gaps are
+// assumed to be spread evenly, which makes the varint part an estimate.
+func sBytes(rows, cols int, nnz int64, enc string) int64 {
+	if enc == SEncTriplet { return shardHeaderSize + 12 + 12*nnz }
+	size := int64(shardHeaderSize + 13 + 4*(rows+1)) + 2*nnz
+	if nnz == 0 { return size }
+	gap := int64(rows) * int64(cols) / nnz
+	if gap > int64(cols) { gap = int64(cols) }
+	varint := int64(1)
+	for g := gap >> 7; g > 0; g >>= 7 { varint++ }
+	return size + varint*nnz
+}
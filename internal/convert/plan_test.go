@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPlanLayerMatchesConvertLayer(t *testing.T) {
+	rows, cols := 24, 40
+	data := make([]float32, rows*cols)
+	rng := rand.New(rand.NewSource(7))
+	for i := range data { data[i] = float32(rng.NormFloat64()) }
+	for _, layout := range []string{LayoutRow, LayoutFlat} {
+		cfg := Config{Rank: 2, OutlierQuantile: 0.99, PQm: 8, PQk: 4, PQd: 16, RLayout: layout, SEncoding: SEncTriplet}
+		shards, err := ConvertLayer(LayerSpec{Name: "w", Rows: rows, Cols: cols, Data: data}, cfg)
+		if err != nil { t.Fatalf("%s: convert: %v", layout, err) }
+		p := PlanLayer("w", rows, cols, cfg)
+		got := map[uint8]int64{}
+		for _, s := range shards { got[s.Type] = shardHeaderSize + int64(len(s.Data)) }
+		if got[TypeL] != p.LBytes || got[TypeD] != p.DBytes { t.Fatalf("%s: L/D: got %d/%d planned %d/%d", layout, got[TypeL], got[TypeD], p.LBytes, p.DBytes) }
+		// ConvertLayer embeds the codebook; the planner accounts for it in CODEBOOKS
+		// with a 12-byte entry header and a 2-byte codebook id in the shard instead.
+		r := got[TypeR] + got[TypeRRow]
+		if want := p.RCodeBytes + p.CodebookBytes - 14; r != want { t.Fatalf("%s: R: got %d planned %d", layout, r, want) }
+		if got[TypeS] != p.SBytes { t.Fatalf("%s: S: got %d planned %d", layout, got[TypeS], p.SBytes) }
+	}
+	// CSR sizes depend on the column gaps, so only the fixed part is exact
+	cfg := Config{Rank: 2, OutlierQuantile: 0.95, PQm: 8, PQk: 4}
+	shards, err := ConvertLayer(LayerSpec{Name: "w", Rows: rows, Cols: cols, Data: data}, cfg)
+	if err != nil { t.Fatalf("convert: %v", err) }
+	p := PlanLayer("w", rows, cols, cfg)
+	for _, s := range shards {
+		if s.Type != TypeSCSR { continue }
+		got := shardHeaderSize + int64(len(s.Data))
+		if d := got - p.SBytes; d < -p.SNonzeros/2 || d > p.SNonzeros/2 { t.Fatalf("csr S: got %d planned %d", got, p.SBytes) }
+		return
+	}
+	t.Fatalf("no CSR S shard")
+}
+
+func TestPlanLayerRVQ(t *testing.T) {
+	rows, cols := 24, 40
+	spec := randSpec("w", rows, cols, 7)
+	cfg := Config{Rank: 2, OutlierQuantile: 0.99, PQm: 4, PQk: 16, PQd: 8, Codec: CodecRVQ, RVQStages: 3}
+	shards, err := ConvertLayer(spec, cfg)
+	if err != nil { t.Fatal(err) }
+	p := PlanLayer("w", rows, cols, cfg)
+	for _, s := range shards {
+		if s.Type != TypeRRVQ { continue }
+		if got := shardHeaderSize + int64(len(s.Data)); got != p.RCodeBytes || p.CodebookBytes != 0 { t.Fatalf("R: got %d planned %d+%d", got, p.RCodeBytes, p.CodebookBytes) }
+		return
+	}
+	t.Fatal("no RVQ shard")
+}
+
+func TestPlanLayerInt(t *testing.T) {
+	rows, cols := 24, 40
+	spec := randSpec("w", rows, cols, 7)
+	for _, cfg := range []Config{
+		{Rank: 2, OutlierQuantile: 0.99, Codec: CodecInt4, IntGroup: 16},
+		{Rank: 2, OutlierQuantile: 0.99, Codec: CodecInt8, IntGroup: 16, IntZeroPoint: true},
+	} {
+		shards, err := ConvertLayer(spec, cfg)
+		if err != nil { t.Fatal(err) }
+		p := PlanLayer("w", rows, cols, cfg)
+		for _, s := range shards {
+			if s.Type != TypeRInt { continue }
+			if got := shardHeaderSize + int64(len(s.Data)); got != p.RCodeBytes { t.Fatalf("%s: R got %d planned %d", cfg.Codec, got, p.RCodeBytes) }
+		}
+	}
+}
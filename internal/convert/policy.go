@@ -0,0 +1,125 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// Policy is an ordered list of per-tensor conversion rules loaded from JSON:
+//
+//	{"rules": [
+//	  {"match": "embed_tokens|lm_head", "store_raw": true},
+//	  {"glob": "*.mlp.*_proj.weight", "rank": 32, "pq_m": 16},
+//	  {"glob": "*.self_attn.*", "outlier_q": 0.995}
+//	]}
+//
+// Rules are tried in order and the first rule whose pattern matches the tensor
+// name wins. Fields left out of a rule keep the value of the base Config.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule matches tensor names with a regular expression (Match) and/or a glob
+// (Glob); when both are set both must match.
+type Rule struct {
+	Match string `json:"match,omitempty"`
+	Glob  string `json:"glob,omitempty"`
+
+	Rank             *int     `json:"rank,omitempty"`
+	OutlierStrategy  string   `json:"outliers,omitempty"`
+	OutlierQuantile  *float64 `json:"outlier_q,omitempty"`
+	OutlierK         *int     `json:"outlier_k,omitempty"`
+	OutlierThreshold *float64 `json:"outlier_threshold,omitempty"`
+	OutlierBudget    *int     `json:"outlier_budget,omitempty"`
+	PQm              *int     `json:"pq_m,omitempty"`
+	PQk              *int     `json:"pq_k,omitempty"`
+	PQd              *int     `json:"pq_d,omitempty"`
+	PQfp16           *bool    `json:"pq_fp16,omitempty"`
+	PQGroup          *string  `json:"pq_group,omitempty"`
+	RLayout          string   `json:"r_layout,omitempty"`
+	Rotation         string   `json:"rotation,omitempty"`
+	SEncoding        string   `json:"s_enc,omitempty"`
+	Codec            string   `json:"codec,omitempty"`
+	RVQStages        *int     `json:"rvq_stages,omitempty"`
+	IntGroup         *int     `json:"int_group,omitempty"`
+	IntZeroPoint     *bool    `json:"int_zp,omitempty"`
+	Skip             bool     `json:"skip,omitempty"`
+	StoreRaw         bool     `json:"store_raw,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Decision is the outcome of resolving a tensor name against a Policy.
+type Decision struct {
+	Config Config
+	Rule   int // index of the matching rule, -1 if none matched
+	Skip   bool
+}
+
+// ParsePolicy parses and compiles a JSON policy document.
+func ParsePolicy(b []byte) (*Policy, error) {
+	var pol Policy
+	if err := json.Unmarshal(b, &pol); err != nil { return nil, fmt.Errorf("policy: %w", err) }
+	for i := range pol.Rules {
+		r := &pol.Rules[i]
+		if r.Match == "" && r.Glob == "" { return nil, fmt.Errorf("policy: rule %d has neither match nor glob", i) }
+		if r.Match != "" {
+			re, err := regexp.Compile(r.Match)
+			if err != nil { return nil, fmt.Errorf("policy: rule %d: %w", i, err) }
+			r.re = re
+		}
+		if r.Glob != "" {
+			if _, err := path.Match(r.Glob, ""); err != nil { return nil, fmt.Errorf("policy: rule %d: bad glob %q", i, r.Glob) }
+		}
+		if !validLayout(r.RLayout) { return nil, fmt.Errorf("policy: rule %d: unknown r_layout %q", i, r.RLayout) }
+		if !validRotation(r.Rotation) { return nil, fmt.Errorf("policy: rule %d: unknown rotation %q", i, r.Rotation) }
+		if !validOutlierStrategy(r.OutlierStrategy) { return nil, fmt.Errorf("policy: rule %d: unknown outliers %q", i, r.OutlierStrategy) }
+		if !validSEncoding(r.SEncoding) { return nil, fmt.Errorf("policy: rule %d: unknown s_enc %q", i, r.SEncoding) }
+		if r.Codec != "" && !validCodec(r.Codec) { return nil, fmt.Errorf("policy: rule %d: unknown codec %q", i, r.Codec) }
+	}
+	return &pol, nil
+}
+
+func (r *Rule) matches(name string) bool {
+	if r.re != nil && !r.re.MatchString(name) { return false }
+	if r.Glob != "" {
+		if ok, _ := path.Match(r.Glob, name); !ok { return false }
+	}
+	return true
+}
+
+// Resolve returns the Config to use for the named tensor. A nil Policy
+// resolves every name to base.
+func (p *Policy) Resolve(name string, base Config) Decision {
+	d := Decision{Config: base, Rule: -1}
+	if p == nil { return d }
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !r.matches(name) { continue }
+		d.Rule = i
+		d.Skip = r.Skip
+		if r.Rank != nil { d.Config.Rank = *r.Rank }
+		if r.OutlierStrategy != "" { d.Config.OutlierStrategy = r.OutlierStrategy }
+		if r.OutlierQuantile != nil { d.Config.OutlierQuantile = *r.OutlierQuantile }
+		if r.OutlierK != nil { d.Config.OutlierK = *r.OutlierK }
+		if r.OutlierThreshold != nil { d.Config.OutlierThreshold = *r.OutlierThreshold }
+		if r.OutlierBudget != nil { d.Config.OutlierBudget = *r.OutlierBudget }
+		if r.PQm != nil { d.Config.PQm = *r.PQm }
+		if r.PQk != nil { d.Config.PQk = *r.PQk }
+		if r.PQd != nil { d.Config.PQd = *r.PQd }
+		if r.PQfp16 != nil { d.Config.PQfp16 = *r.PQfp16 }
+		if r.PQGroup != nil { d.Config.PQGroup = *r.PQGroup }
+		if r.RLayout != "" { d.Config.RLayout = r.RLayout }
+		if r.Rotation != "" { d.Config.Rotation = r.Rotation }
+		if r.SEncoding != "" { d.Config.SEncoding = r.SEncoding }
+		if r.Codec != "" { d.Config.Codec = r.Codec }
+		if r.RVQStages != nil { d.Config.RVQStages = *r.RVQStages }
+		if r.IntGroup != nil { d.Config.IntGroup = *r.IntGroup }
+		if r.IntZeroPoint != nil { d.Config.IntZeroPoint = *r.IntZeroPoint }
+		if r.StoreRaw { d.Config.StoreRaw = true }
+		return d
+	}
+	return d
+}
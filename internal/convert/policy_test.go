@@ -0,0 +1,41 @@
+package convert
+
+import "testing"
+
+func TestPolicyFirstMatchWins(t *testing.T) {
+	pol, err := ParsePolicy([]byte(`{"rules": [
+		{"match": "embed_tokens|lm_head", "store_raw": true},
+		{"glob": "model.layers.*.mlp.*", "rank": 8, "pq_k": 16},
+		{"match": "layers\\.", "outlier_q": 0.5},
+		{"glob": "*norm*", "skip": true}
+	]}`))
+	if err != nil { t.Fatalf("parse: %v", err) }
+	base := Config{Rank: 64, OutlierQuantile: 0.999, PQm: 8, PQk: 256}
+
+	d := pol.Resolve("model.embed_tokens.weight", base)
+	if d.Rule != 0 || !d.Config.StoreRaw { t.Fatalf("embed: %+v", d) }
+
+	d = pol.Resolve("model.layers.3.mlp.up_proj.weight", base)
+	if d.Rule != 1 || d.Config.Rank != 8 || d.Config.PQk != 16 || d.Config.OutlierQuantile != 0.999 {
+		t.Fatalf("mlp: %+v", d)
+	}
+
+	d = pol.Resolve("model.layers.3.self_attn.q_proj.weight", base)
+	if d.Rule != 2 || d.Config.OutlierQuantile != 0.5 || d.Config.Rank != 64 { t.Fatalf("attn: %+v", d) }
+
+	d = pol.Resolve("model.norm.weight", base)
+	if d.Rule != 3 || !d.Skip { t.Fatalf("norm: %+v", d) }
+
+	d = pol.Resolve("other", base)
+	if d.Rule != -1 || d.Config != base { t.Fatalf("no match: %+v", d) }
+}
+
+func TestPolicyRejectsBadRules(t *testing.T) {
+	for _, doc := range []string{
+		`{"rules": [{"rank": 4}]}`,
+		`{"rules": [{"match": "("}]}`,
+		`{"rules": [{"glob": "*", "codec": "nope"}]}`,
+	} {
+		if _, err := ParsePolicy([]byte(doc)); err == nil { t.Fatalf("expected error for %s", doc) }
+	}
+}
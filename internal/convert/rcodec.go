@@ -0,0 +1,243 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+const pqIters = 25
+
+// opqOuterIters is how often OPQ alternates between PQ and the rotation.
+const opqOuterIters = 5
+
+// miniBatchAbove is the training-set size above which k-means switches to
+// mini-batches of defaultBatch unless Config.KMeansBatch says otherwise.
+const (
+	miniBatchAbove = 1 << 18
+	defaultBatch   = 4096
+)
+
+func (c Config) seed() int64 {
+	if c.Seed != 0 { return c.Seed }
+	return 1234
+}
+
+// kmeansOptions returns the k-means settings for n training vectors.
+func (c Config) kmeansOptions(n int) quant.KMeansOptions {
+	o := quant.KMeansOptions{Iters: pqIters, Seed: c.seed()}
+	switch {
+	case c.KMeansBatch > 0:
+		o.BatchSize = c.KMeansBatch
+	case c.KMeansBatch == 0 && n > miniBatchAbove:
+		o.BatchSize = defaultBatch
+	}
+	return o
+}
+
+func (c Config) blockDim() int {
+	if c.PQd > 0 { return c.PQd }
+	return 128
+}
+
+func (c Config) rowLayout() bool { return c.RLayout != LayoutFlat }
+
+// codeBits is the R code width for a codebook of k entries. The flat layout
+// has no width field, so it keeps one byte per code whenever k fits and
+// readers tell 12/16-bit codes apart by the payload length.
+func (c Config) codeBits(k int) int {
+	if !c.rowLayout() && k <= 256 { return 8 }
+	return quant.CodeBits(k)
+}
+
+// cbElemSize is the byte size of one stored codebook value.
+func (c Config) cbElemSize() int {
+	if c.PQfp16 { return 2 }
+	return 4
+}
+
+// pqShape returns the R block dimension d, sub-quantizer count m, effective
+// codebook size k and block count n that ConvertLayer uses for a tensor.
+func pqShape(rows, cols int, cfg Config) (d, m, k, n int) {
+	d = cfg.blockDim()
+	m = cfg.PQm
+	if cfg.rowLayout() {
+		// a block never spans rows, so there is no point in making it wider
+		// than a row (rounded up to keep d divisible by m)
+		if m > 0 && d > cols { d = (cols + m - 1) / m * m }
+		n = rows * ((cols + d - 1) / d)
+	} else {
+		n = (rows*cols + d - 1) / d
+	}
+	if m <= 0 || m > d || d%m != 0 { m = d/8 }
+	if m < 1 { m = 1 }
+	// TrainPQ clamps k to the number of training vectors
+	k = cfg.PQk
+	if k > n { k = n }
+	if k < 1 { k = 1 }
+	return
+}
+
+// rowBlocks cuts a row-major matrix into d-wide blocks that tile each row,
+// zero-padding the last block of every row.
+func rowBlocks(R []float32, rows, cols, d int) [][]float32 {
+	bpr := (cols + d - 1) / d
+	flat := make([]float32, rows*bpr*d)
+	for r := 0; r < rows; r++ {
+		copy(flat[r*bpr*d:], R[r*cols:(r+1)*cols])
+	}
+	blocks := make([][]float32, rows*bpr)
+	for i := range blocks { blocks[i] = flat[i*d : (i+1)*d] }
+	return blocks
+}
+
+// RowBlocks is the row-aligned blocking encodeR trains and encodes on, for
+// tools that quantize matrices outside a conversion.
+func RowBlocks(R []float32, rows, cols, d int) [][]float32 { return rowBlocks(R, rows, cols, d) }
+
+// flatBlocks cuts the flattened matrix into d-wide blocks, padding the tail.
+func flatBlocks(R []float32, n, d int) [][]float32 {
+	flat := make([]float32, n*d)
+	copy(flat, R)
+	blocks := make([][]float32, n)
+	for i := range blocks { blocks[i] = flat[i*d : (i+1)*d] }
+	return blocks
+}
+
+// residueBlocks cuts R into the d-wide PQ training vectors of cfg's layout.
+// With the Hadamard rotation the blocks come back rotated, together with the
+// rotation; OPQ is learned later, in encodeR.
+func residueBlocks(spec LayerSpec, R []float32, cfg Config) ([][]float32, *quant.Rotation, error) {
+	d, _, _, n := pqShape(spec.Rows, spec.Cols, cfg)
+	if !cfg.rowLayout() { return flatBlocks(R, n, d), nil, nil }
+	blocks := rowBlocks(R, spec.Rows, spec.Cols, d)
+	if cfg.Rotation != RotationHadamard { return blocks, nil, nil }
+	rot, err := quant.NewHadamard(d, uint64(cfg.seed()))
+	if err != nil { return nil, nil, fmt.Errorf("%s: %w", spec.Name, err) }
+	for _, b := range blocks { rot.Apply(b, nil) }
+	return blocks, rot, nil
+}
+
+// RRowPrefixLen returns where the codebook id or embedded codebook of a
+// row-aligned R payload starts: the 16-byte header plus any rotation block.
+func RRowPrefixLen(p []byte) (int, error) {
+	const hdr = 16
+	if len(p) < hdr { return 0, fmt.Errorf("short R payload") }
+	if p[15]&RFlagRotation == 0 { return hdr, nil }
+	if len(p) < hdr+1 { return 0, fmt.Errorf("short rotation") }
+	d := int(binary.LittleEndian.Uint16(p[8:10]))
+	n := hdr + (&quant.Rotation{Kind: p[hdr], D: d}).EncodedLen()
+	if len(p) < n { return 0, fmt.Errorf("short rotation") }
+	return n, nil
+}
+
+// encodeR product-quantizes the dense residue into an R shard with an
+// embedded codebook. The CLI later moves codebooks into CODEBOOKS.
+//
+// Flat layout (type 1):
+//
+//	rows:u32 cols:u32 d:u16 m:u16 k:u16 n:u32 cb:[m*k*dsub]f32 codes:[n*m]
+//
+// Row-aligned layout (type 4), n = rows*ceil(cols/d) implied:
+//
+//	rows:u32 cols:u32 d:u16 m:u16 k:u16 bits:u8 flags:u8
+//	rotation (if RFlagRotation, see quant.Rotation.AppendBinary)
+//	cb:[m*k*dsub]f32|f16 codes
+//
+// Codes are packed at codeBits(k) bits (see quant.PackCodes). With a rotation
+// the codes quantize M*w for every block w, and readers rotate x to match.
+//
+// A non-nil pq (a group codebook, see TrainGroupPQ) is used as is instead of
+// training one on this layer.
+func encodeR(spec LayerSpec, R []float32, cfg Config, pq *quant.PQ) (Shard, error) {
+	d, m, _, n := pqShape(spec.Rows, spec.Cols, cfg)
+	data, rot, err := residueBlocks(spec, R, cfg)
+	if err != nil { return Shard{}, err }
+	switch {
+	case pq == nil && cfg.Rotation == RotationOPQ:
+		rot, pq = quant.TrainOPQ(data, m, cfg.PQk, opqOuterIters, cfg.kmeansOptions(len(data)))
+		tmp := make([]float32, d)
+		for _, b := range data { rot.Apply(b, tmp) }
+	case pq == nil:
+		pq = quant.TrainPQWith(data, m, cfg.PQk, cfg.kmeansOptions(len(data)))
+	case pq.M != m || pq.M*pq.Dsub != d:
+		return Shard{}, fmt.Errorf("%s: shared codebook is m=%d d=%d, layer needs m=%d d=%d", spec.Name, pq.M, pq.M*pq.Dsub, m, d)
+	}
+	codes := pq.Encode(data)
+	rb := new(bytes.Buffer)
+	binary.Write(rb, binary.LittleEndian, uint32(spec.Rows))
+	binary.Write(rb, binary.LittleEndian, uint32(spec.Cols))
+	binary.Write(rb, binary.LittleEndian, uint16(d))
+	binary.Write(rb, binary.LittleEndian, uint16(m))
+	binary.Write(rb, binary.LittleEndian, uint16(pq.K))
+	bits := cfg.codeBits(pq.K)
+	typ := uint8(TypeR)
+	if cfg.rowLayout() {
+		typ = TypeRRow
+		var flags byte // embedded codebook
+		if cfg.PQfp16 { flags |= RFlagCBFP16 }
+		if rot != nil { flags |= RFlagRotation }
+		rb.WriteByte(byte(bits))
+		rb.WriteByte(flags)
+		if rot != nil { rb.Write(rot.AppendBinary(nil)) }
+	} else {
+		binary.Write(rb, binary.LittleEndian, uint32(n))
+	}
+	// codebooks flattened per subvector
+	for i := 0; i < m; i++ {
+		if cfg.PQfp16 {
+			rb.Write(fp16bytes(pq.Codebooks[i]))
+		} else {
+			rb.Write(float32SliceToBytes(pq.Codebooks[i]))
+		}
+	}
+	rb.Write(quant.PackCodes(codes, bits))
+	return Shard{Type: typ, Scope: spec.Scope, Comp: 0, Data: rb.Bytes()}, nil
+}
+
+// rvqVersion is the payload version written into type-6 shards.
+const rvqVersion = 1
+
+func (c Config) rvqStages() int {
+	if c.RVQStages > 0 { return c.RVQStages }
+	return 2
+}
+
+// encodeRVQ quantizes the residue with residual PQ over row-aligned blocks
+// (type 6). Each stage has its own embedded codebook and its own code array,
+// byte-aligned so a reader can slice stages without unpacking:
+//
+//	rows:u32 cols:u32 d:u16 m:u16 k:u16 bits:u8 flags:u8 version:u8 stages:u8
+//	stages x cb:[m*k*dsub]f32|f16
+//	stages x codes:[rows][ceil(cols/d)][m], packed bits wide
+func encodeRVQ(spec LayerSpec, R []float32, cfg Config) (Shard, error) {
+	d, m, _, _ := pqShape(spec.Rows, spec.Cols, cfg)
+	data, _, err := residueBlocks(spec, R, cfg)
+	if err != nil { return Shard{}, err }
+	rvq := quant.TrainRVQ(data, cfg.rvqStages(), m, cfg.PQk, cfg.kmeansOptions(len(data)), cfg.PQfp16)
+	codes := rvq.Encode(data)
+	k := rvq.Stages[0].K
+	bits := cfg.codeBits(k)
+	var flags byte
+	if cfg.PQfp16 { flags |= RFlagCBFP16 }
+	rb := new(bytes.Buffer)
+	binary.Write(rb, binary.LittleEndian, uint32(spec.Rows))
+	binary.Write(rb, binary.LittleEndian, uint32(spec.Cols))
+	binary.Write(rb, binary.LittleEndian, uint16(d))
+	binary.Write(rb, binary.LittleEndian, uint16(m))
+	binary.Write(rb, binary.LittleEndian, uint16(k))
+	rb.Write([]byte{byte(bits), flags, rvqVersion, byte(len(rvq.Stages))})
+	for _, pq := range rvq.Stages {
+		for i := 0; i < m; i++ {
+			if cfg.PQfp16 {
+				rb.Write(fp16bytes(pq.Codebooks[i]))
+			} else {
+				rb.Write(float32SliceToBytes(pq.Codebooks[i]))
+			}
+		}
+	}
+	for _, c := range codes { rb.Write(quant.PackCodes(c, bits)) }
+	return Shard{Type: TypeRRVQ, Scope: spec.Scope, Comp: 0, Data: rb.Bytes()}, nil
+}
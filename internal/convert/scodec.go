@@ -0,0 +1,74 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/qrv0/crow/internal/quant"
+)
+
+// encodeS packs the outliers found by decomposeNDSQ, which are in row-major
+// order.
+//
+// Triplet layout (type 2):
+//
+//	rows:u32 cols:u32 n:u32 idx:[n](i32 row, i32 col) vals:[n]f32
+//
+// CSR layout (type 5):
+//
+//	rows:u32 cols:u32 nnz:u32 vtype:u8 (1=fp16, 2=bf16)
+//	rowptr:[rows+1]u32 vals:[nnz]u16 cols:uvarint per entry
+//
+// where a row's first column is stored as is and the rest as the gap to the
+// previous column.
+func encodeS(spec LayerSpec, Sind [][2]int32, Sval []float32, cfg Config) Shard {
+	sb := new(bytes.Buffer)
+	binary.Write(sb, binary.LittleEndian, uint32(spec.Rows))
+	binary.Write(sb, binary.LittleEndian, uint32(spec.Cols))
+	binary.Write(sb, binary.LittleEndian, uint32(len(Sind)))
+	if cfg.SEncoding == SEncTriplet {
+		for _, ij := range Sind { binary.Write(sb, binary.LittleEndian, ij) }
+		sb.Write(float32SliceToBytes(Sval))
+		return Shard{Type: TypeS, Scope: spec.Scope, Comp: 0, Data: sb.Bytes()}
+	}
+	bf16 := cfg.SEncoding == SEncCSRBF16
+	if bf16 { sb.WriteByte(2) } else { sb.WriteByte(1) }
+	rowptr := make([]byte, 4*(spec.Rows+1))
+	vals := make([]byte, 2*len(Sind))
+	var colIdx []byte
+	var tmp [binary.MaxVarintLen64]byte
+	prevRow, prevCol := -1, 0
+	for i, ij := range Sind {
+		r, c := int(ij[0]), int(ij[1])
+		if r != prevRow { prevRow, prevCol = r, 0 }
+		n := binary.PutUvarint(tmp[:], uint64(c-prevCol))
+		colIdx = append(colIdx, tmp[:n]...)
+		prevCol = c
+		// rowptr[r+1] counts entries up to and including row r
+		binary.LittleEndian.PutUint32(rowptr[4*(r+1):], uint32(i+1))
+		if bf16 {
+			binary.LittleEndian.PutUint16(vals[2*i:], fp32tobf16(Sval[i]))
+		} else {
+			binary.LittleEndian.PutUint16(vals[2*i:], quant.FP16FromFloat32(Sval[i]))
+		}
+	}
+	// rows without outliers inherit the previous row's end
+	for r := 1; r <= spec.Rows; r++ {
+		if binary.LittleEndian.Uint32(rowptr[4*r:]) < binary.LittleEndian.Uint32(rowptr[4*(r-1):]) {
+			copy(rowptr[4*r:4*r+4], rowptr[4*(r-1):4*r])
+		}
+	}
+	sb.Write(rowptr)
+	sb.Write(vals)
+	sb.Write(colIdx)
+	return Shard{Type: TypeSCSR, Scope: spec.Scope, Comp: 0, Data: sb.Bytes()}
+}
+
+// fp32tobf16 rounds to nearest even; NaN stays NaN.
+func fp32tobf16(f float32) uint16 {
+	u := math.Float32bits(f)
+	if u&0x7FFFFFFF > 0x7F800000 { return uint16(u>>16) | 0x40 }
+	u += 0x7FFF + (u>>16)&1
+	return uint16(u >> 16)
+}
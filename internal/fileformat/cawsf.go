@@ -111,6 +111,7 @@ const (
 	TypeCodebooks  = 2
 	TypeShardBank  = 3
 	TypeRouting    = 4
+	TypeTokenizer  = 5 // tokenizer.json as found next to the checkpoint
 )
 
 type tocEntry struct {
@@ -158,6 +159,13 @@ func (r *Reader) Section(typeID uint32) ([]byte, error) {
 	return nil, fmt.Errorf("section %d not found", typeID)
 }
 
+// SectionReader reads the i-th TOC entry's payload as stored (still
+// compressed), without loading it.
+func (r *Reader) SectionReader(i int) *io.SectionReader {
+	e := r.TOC[i]
+	return io.NewSectionReader(r.f, int64(e.Offset), int64(e.Size))
+}
+
 // SectionUncompressed returns the raw or decompressed payload depending on flags.
 func (r *Reader) SectionUncompressed(typeID uint32) ([]byte, error) {
 	for _, e := range r.TOC {
@@ -28,12 +28,13 @@ static void gpu_close() {
     if (G.ok) { cublasDestroy(G.handle); G.ok = 0; }
 }
 
-static const char* gpu_matvec_f32(const float* A_rm, int rows, int cols, const float* x, float* y) {
-    // Interpret A (row-major rows x cols) as column-major (cols x rows) and use transpose op.
+static const char* gpu_matvec_f32(const float* A_rm, int rows, int cols, const float* x, float* y, int trans) {
+    // Interpret A (row-major rows x cols) as column-major (cols x rows): the transpose op gives A*x,
+    // the plain op A^T*x.
     if (!G.ok) return "not initialized";
     size_t Asz = (size_t)rows * (size_t)cols * sizeof(float);
-    size_t xsz = (size_t)cols * sizeof(float);
-    size_t ysz = (size_t)rows * sizeof(float);
+    size_t xsz = (size_t)(trans ? rows : cols) * sizeof(float);
+    size_t ysz = (size_t)(trans ? cols : rows) * sizeof(float);
     float *dA = NULL, *dx = NULL, *dy = NULL;
     cudaError_t ce;
     ce = cudaMalloc((void**)&dA, Asz); if (ce != cudaSuccess) return cudaErrStr(ce);
@@ -45,8 +46,8 @@ static const char* gpu_matvec_f32(const float* A_rm, int rows, int cols, const f
     ce = cudaMemset(dy, 0, ysz); if (ce != cudaSuccess) { cudaFree(dA); cudaFree(dx); cudaFree(dy); return cudaErrStr(ce);} 
     const float alpha = 1.0f, beta = 1.0f;
     int m = cols, n = rows, lda = cols, incx = 1, incy = 1;
-    // dy = alpha*op(A)*dx + beta*dy, with op(A)=A^T (since we pass row-major A)
-    cublasStatus_t st = cublasSgemv(G.handle, CUBLAS_OP_T, m, n, &alpha, dA, lda, dx, incx, &beta, dy, incy);
+    // dy = alpha*op(A)*dx + beta*dy, with op(A)=A^T for W*x (since we pass row-major A)
+    cublasStatus_t st = cublasSgemv(G.handle, trans ? CUBLAS_OP_N : CUBLAS_OP_T, m, n, &alpha, dA, lda, dx, incx, &beta, dy, incy);
     if (st != CUBLAS_STATUS_SUCCESS) { cudaFree(dA); cudaFree(dx); cudaFree(dy); return "cublasSgemv failed"; }
     ce = cudaMemcpy(y, dy, ysz, cudaMemcpyDeviceToHost);
     cudaFree(dA); cudaFree(dx); cudaFree(dy);
@@ -55,7 +56,11 @@ static const char* gpu_matvec_f32(const float* A_rm, int rows, int cols, const f
 }
 */
 import "C"
-import "unsafe"
+import (
+    "unsafe"
+
+    "github.com/qrv0/crow/internal/quant"
+)
 
 var available bool
 
@@ -74,7 +79,20 @@ func MatVecF32(y []float32, A []float32, rows, cols int, x []float32) bool {
     if len(A) != rows*cols || len(x) != cols || len(y) != rows { return false }
     // Compute tmp = A*x then add to y
     tmp := make([]float32, rows)
-    if err := C.gpu_matvec_f32((*C.float)(unsafe.Pointer(&A[0])), C.int(rows), C.int(cols), (*C.float)(unsafe.Pointer(&x[0])), (*C.float)(unsafe.Pointer(&tmp[0]))); err != nil {
+    if err := C.gpu_matvec_f32((*C.float)(unsafe.Pointer(&A[0])), C.int(rows), C.int(cols), (*C.float)(unsafe.Pointer(&x[0])), (*C.float)(unsafe.Pointer(&tmp[0])), 0); err != nil {
+        return false
+    }
+    for i := range y { y[i] += tmp[i] }
+    return true
+}
+
+// MatVecTF32 computes y += A^T*x, with A row-major (rows x cols): x has rows
+// entries and y cols. Same kernel as MatVecF32 with the other op.
+func MatVecTF32(y []float32, A []float32, rows, cols int, x []float32) bool {
+    if !available { return false }
+    if len(A) != rows*cols || len(x) != rows || len(y) != cols { return false }
+    tmp := make([]float32, cols)
+    if err := C.gpu_matvec_f32((*C.float)(unsafe.Pointer(&A[0])), C.int(rows), C.int(cols), (*C.float)(unsafe.Pointer(&x[0])), (*C.float)(unsafe.Pointer(&tmp[0])), 1); err != nil {
         return false
     }
     for i := range y { y[i] += tmp[i] }
@@ -84,16 +102,17 @@ func MatVecF32(y []float32, A []float32, rows, cols int, x []float32) bool {
 func Close() { C.gpu_close() }
 
 // RPQMatVecF32 decodes PQ-coded blocks using codebooks and accumulates into y (CPU for now).
-// cb is flattened [m][k][dsub], with d = m*dsub. codes has length n*m.
-func RPQMatVecF32(y []float32, cb []float32, d, m, k, n int, codes []byte, x []float32) bool {
-    if len(y) == 0 || len(cb) == 0 || len(codes) != n*m { return false }
+// cb is flattened [m][k][dsub], with d = m*dsub. codes holds n*m codes packed
+// bits wide (see quant.PackCodes).
+func RPQMatVecF32(y []float32, cb []float32, d, m, k, n, bits int, codes []byte, x []float32) bool {
+    if len(y) == 0 || len(cb) == 0 || len(codes) != quant.PackedLen(n*m, bits) { return false }
     dsub := d / m
     if len(x) < d { return false }
     cols := len(x)
     for r := 0; r < n; r++ {
         baseFlat := r * d
         for i := 0; i < m; i++ {
-            code := int(codes[r*m+i])
+            code := quant.Code(codes, bits, r*m+i)
             cbStart := (i*k + code) * dsub
             for j := 0; j < dsub; j++ {
                 flatIdx := baseFlat + i*dsub + j
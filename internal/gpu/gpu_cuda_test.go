@@ -15,5 +15,8 @@ func TestCUDAAvailable(t *testing.T) {
     x := []float32{0.5, 0.25, -1}
     y := make([]float32, rows)
     if !MatVecF32(y, A, rows, cols, x) { t.Fatalf("MatVecF32 failed") }
+    yt := make([]float32, cols)
+    if !MatVecTF32(yt, A, rows, cols, []float32{1, -1}) { t.Fatalf("MatVecTF32 failed") }
+    if yt[0] != -3 || yt[1] != -3 || yt[2] != -3 { t.Fatalf("MatVecTF32 = %v", yt) }
 }
 
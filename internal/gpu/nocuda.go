@@ -2,6 +2,8 @@
 
 package gpu
 
+import "github.com/qrv0/crow/internal/quant"
+
 // CPU fallback (no CUDA build tag)
 
 func Available() bool { return false }
@@ -11,15 +13,19 @@ func MatVecF32(y []float32, A []float32, rows, cols int, x []float32) bool {
     return false
 }
 
+func MatVecTF32(y []float32, A []float32, rows, cols int, x []float32) bool {
+    return false
+}
+
 // CPU implementation of RPQMatVecF32 so non-CUDA builds are fully functional for R shards
-func RPQMatVecF32(y []float32, cb []float32, d, m, k, n int, codes []byte, x []float32) bool {
-    if len(y) == 0 || len(cb) == 0 || len(codes) != n*m { return false }
+func RPQMatVecF32(y []float32, cb []float32, d, m, k, n, bits int, codes []byte, x []float32) bool {
+    if len(y) == 0 || len(cb) == 0 || len(codes) != quant.PackedLen(n*m, bits) { return false }
     dsub := d / m
     if len(x) < d { return false }
     for r := 0; r < n; r++ {
         baseFlat := r * d
         for i := 0; i < m; i++ {
-            code := int(codes[r*m+i])
+            code := quant.Code(codes, bits, r*m+i)
             cbStart := (i*k + code) * dsub
             for j := 0; j < dsub; j++ {
                 flatIdx := baseFlat + i*dsub + j
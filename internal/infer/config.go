@@ -0,0 +1,55 @@
+// Package infer runs decoder-only transformers (llama, mistral, qwen2)
+// directly on the scopes of a CAWSF model: every linear layer is a shard
+// bank multiply, so weights are never expanded to a dense copy.
+package infer
+
+import "fmt"
+
+// Config is the part of a Hugging Face config.json the forward pass needs.
+type Config struct {
+	Arch          string // model_type
+	Hidden        int
+	Layers        int
+	Heads         int
+	KVHeads       int
+	HeadDim       int
+	Intermediate  int
+	Vocab         int
+	NormEps       float32
+	RopeTheta     float64
+	MaxPositions  int  // 0 = unbounded
+	TieEmbeddings bool // no lm_head; logits come from the embedding matrix
+}
+
+// ConfigFromHF reads a config.json as stored under META "hf_config".
+func ConfigFromHF(hf map[string]any) (Config, error) {
+	c := Config{NormEps: 1e-6, RopeTheta: 10000}
+	c.Arch, _ = hf["model_type"].(string)
+	switch c.Arch {
+	case "llama", "mistral", "qwen2":
+	default:
+		return c, fmt.Errorf("unsupported model_type %q (want llama, mistral or qwen2)", c.Arch)
+	}
+	for _, f := range []struct {
+		key string
+		dst *int
+	}{
+		{"hidden_size", &c.Hidden}, {"num_hidden_layers", &c.Layers}, {"num_attention_heads", &c.Heads},
+		{"intermediate_size", &c.Intermediate}, {"vocab_size", &c.Vocab},
+	} {
+		v, ok := hf[f.key].(float64)
+		if !ok || v <= 0 { return c, fmt.Errorf("hf_config: missing %s", f.key) }
+		*f.dst = int(v)
+	}
+	c.KVHeads = c.Heads
+	if v, ok := hf["num_key_value_heads"].(float64); ok && v > 0 { c.KVHeads = int(v) }
+	c.HeadDim = c.Hidden / c.Heads
+	if v, ok := hf["head_dim"].(float64); ok && v > 0 { c.HeadDim = int(v) }
+	if v, ok := hf["rms_norm_eps"].(float64); ok && v > 0 { c.NormEps = float32(v) }
+	if v, ok := hf["rope_theta"].(float64); ok && v > 0 { c.RopeTheta = v }
+	if v, ok := hf["max_position_embeddings"].(float64); ok { c.MaxPositions = int(v) }
+	c.TieEmbeddings, _ = hf["tie_word_embeddings"].(bool)
+	if c.Heads%c.KVHeads != 0 { return c, fmt.Errorf("hf_config: %d heads not a multiple of %d kv heads", c.Heads, c.KVHeads) }
+	if c.HeadDim%2 != 0 { return c, fmt.Errorf("hf_config: odd head_dim %d", c.HeadDim) }
+	return c, nil
+}
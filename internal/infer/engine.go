@@ -0,0 +1,257 @@
+package infer
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/qrv0/crow/internal/cawsf"
+)
+
+// Engine is one generation context over a Model: weights plus a KV cache.
+// Forward runs the network a block at a time, multiplying each weight scope
+// by all pending tokens at once, so a scope's shards are decoded once per
+// call and only the block being run is touched. Whether decoded shards stay
+// resident between calls is up to the Model's ShardCache (none: nothing is
+// kept). An Engine is not safe for concurrent use.
+type Engine struct {
+	Config
+	m      *cawsf.Model
+	blocks []block
+	embed  uint16
+	lmHead uint16
+	norm   []float32
+	freq   []float64
+	kc, vc [][]float32 // per block: pos x KVHeads*HeadDim
+	pos    int
+	pf     *cawsf.Prefetcher
+	ahead  int
+	primed bool // the look-ahead window is queued
+}
+
+type block struct {
+	attnNorm, mlpNorm          []float32
+	q, k, v, o, gate, up, down uint16
+	qb, kb, vb                 []float32 // attention biases (qwen2), nil when absent
+}
+
+// New binds an engine to m, reading the architecture from META "hf_config"
+// and scopes by their Hugging Face tensor names. Norm weights and biases
+// must be in the file, which convert does for 1-D tensors.
+func New(m *cawsf.Model) (*Engine, error) {
+	hf, ok := m.Meta["hf_config"].(map[string]any)
+	if !ok { return nil, fmt.Errorf("model has no hf_config in META") }
+	cfg, err := ConfigFromHF(hf)
+	if err != nil { return nil, err }
+	e := &Engine{Config: cfg, m: m, freq: ropeTable(cfg.HeadDim, cfg.RopeTheta), kc: make([][]float32, cfg.Layers), vc: make([][]float32, cfg.Layers)}
+	qDim, kvDim := cfg.Heads*cfg.HeadDim, cfg.KVHeads*cfg.HeadDim
+	if e.embed, err = e.matrix("model.embed_tokens.weight", cfg.Vocab, cfg.Hidden); err != nil { return nil, err }
+	e.lmHead = e.embed
+	if _, ok := m.ScopeByName("lm_head.weight"); ok {
+		if e.lmHead, err = e.matrix("lm_head.weight", cfg.Vocab, cfg.Hidden); err != nil { return nil, err }
+	} else if !cfg.TieEmbeddings {
+		return nil, fmt.Errorf("no lm_head.weight and tie_word_embeddings is not set")
+	}
+	if e.norm, err = e.vector("model.norm.weight", cfg.Hidden, true); err != nil { return nil, err }
+	for i := 0; i < cfg.Layers; i++ {
+		p := fmt.Sprintf("model.layers.%d.", i)
+		var b block
+		for _, s := range []struct {
+			name       string
+			dst        *uint16
+			rows, cols int
+		}{
+			{"self_attn.q_proj.weight", &b.q, qDim, cfg.Hidden},
+			{"self_attn.k_proj.weight", &b.k, kvDim, cfg.Hidden},
+			{"self_attn.v_proj.weight", &b.v, kvDim, cfg.Hidden},
+			{"self_attn.o_proj.weight", &b.o, cfg.Hidden, qDim},
+			{"mlp.gate_proj.weight", &b.gate, cfg.Intermediate, cfg.Hidden},
+			{"mlp.up_proj.weight", &b.up, cfg.Intermediate, cfg.Hidden},
+			{"mlp.down_proj.weight", &b.down, cfg.Hidden, cfg.Intermediate},
+		} {
+			if *s.dst, err = e.matrix(p+s.name, s.rows, s.cols); err != nil { return nil, err }
+		}
+		if b.attnNorm, err = e.vector(p+"input_layernorm.weight", cfg.Hidden, true); err != nil { return nil, err }
+		if b.mlpNorm, err = e.vector(p+"post_attention_layernorm.weight", cfg.Hidden, true); err != nil { return nil, err }
+		if b.qb, err = e.vector(p+"self_attn.q_proj.bias", qDim, false); err != nil { return nil, err }
+		if b.kb, err = e.vector(p+"self_attn.k_proj.bias", kvDim, false); err != nil { return nil, err }
+		if b.vb, err = e.vector(p+"self_attn.v_proj.bias", kvDim, false); err != nil { return nil, err }
+		e.blocks = append(e.blocks, b)
+	}
+	return e, nil
+}
+
+// matrix finds a weight scope and checks its META shape.
+func (e *Engine) matrix(name string, rows, cols int) (uint16, error) {
+	s, ok := e.m.ScopeByName(name)
+	if !ok { return 0, fmt.Errorf("tensor %s not found", name) }
+	if len(s.Shape) == 2 && (s.Shape[0] != rows || s.Shape[1] != cols) { return 0, fmt.Errorf("tensor %s is %v, want [%d %d]", name, s.Shape, rows, cols) }
+	return s.ID, nil
+}
+
+// vector reconstructs a 1-D tensor; a missing optional one is nil.
+func (e *Engine) vector(name string, n int, required bool) ([]float32, error) {
+	s, ok := e.m.ScopeByName(name)
+	if !ok {
+		if required { return nil, fmt.Errorf("tensor %s not found", name) }
+		return nil, nil
+	}
+	_, _, data, err := e.m.Reconstruct(s.ID)
+	if err != nil { return nil, fmt.Errorf("%s: %w", name, err) }
+	if len(data) != n { return nil, fmt.Errorf("tensor %s has %d values, want %d", name, len(data), n) }
+	return data, nil
+}
+
+// Prefetch makes Forward queue the weights of the next ahead steps on p while
+// it runs the current one, so their shards decode in the background. The
+// steps are the blocks, then the output head, then block 0 again for the
+// next Forward. p must prefetch into the engine's model; nil turns
+// prefetching off.
+func (e *Engine) Prefetch(p *cawsf.Prefetcher, ahead int) { e.pf, e.ahead, e.primed = p, ahead, false }
+
+// stepScopes lists the weight scopes of execution step s: block s, or the
+// output head after the last block.
+func (e *Engine) stepScopes(s int) []uint16 {
+	if s == len(e.blocks) { return []uint16{e.lmHead} }
+	b := &e.blocks[s]
+	return []uint16{b.q, b.k, b.v, b.o, b.gate, b.up, b.down}
+}
+
+// prefetch keeps steps l+1 .. l+ahead queued before step l runs.
+func (e *Engine) prefetch(l int) error {
+	if e.pf == nil || e.ahead <= 0 { return nil }
+	var scopes []uint16
+	for _, s := range e.prefetchSteps(l) { scopes = append(scopes, e.stepScopes(s)...) }
+	return e.pf.Scopes(scopes...)
+}
+
+// prefetchSteps lists the steps to queue before step l: the whole window
+// the first time, then the one step that enters it. Steps wrap around from
+// the output head to block 0, so the next Forward starts on a warm block.
+func (e *Engine) prefetchSteps(l int) []int {
+	n := len(e.blocks) + 1
+	ahead := min(e.ahead, n-1)
+	if e.primed { return []int{(l + ahead) % n} }
+	e.primed = true
+	steps := make([]int, ahead)
+	for i := range steps { steps[i] = (l + 1 + i) % n }
+	return steps
+}
+
+// Pos is the number of tokens in the KV cache.
+func (e *Engine) Pos() int { return e.pos }
+
+// Reset empties the KV cache.
+func (e *Engine) Reset() {
+	e.pos = 0
+	e.truncate(0)
+}
+
+// truncate drops cached keys and values past pos, e.g. those the blocks
+// before a failed one appended.
+func (e *Engine) truncate(pos int) {
+	kvDim := e.KVHeads * e.HeadDim
+	for i := range e.kc { e.kc[i], e.vc[i] = e.kc[i][:min(len(e.kc[i]), pos*kvDim)], e.vc[i][:min(len(e.vc[i]), pos*kvDim)] }
+}
+
+// Forward appends tokens to the context and returns the logits predicting
+// the token after the last one.
+func (e *Engine) Forward(tokens []int) ([]float32, error) {
+	n := len(tokens)
+	if n == 0 { return nil, fmt.Errorf("no tokens") }
+	if e.MaxPositions > 0 && e.pos+n > e.MaxPositions { return nil, fmt.Errorf("context of %d tokens exceeds %d positions", e.pos+n, e.MaxPositions) }
+	for _, tok := range tokens {
+		if tok < 0 || tok >= e.Vocab { return nil, fmt.Errorf("token %d out of vocabulary", tok) }
+	}
+	H := e.Hidden
+	x, err := e.m.Embed(e.embed, tokens)
+	if err != nil { return nil, err }
+	for l := range e.blocks {
+		err := e.prefetch(l)
+		if err == nil {
+			if err = e.block(l, x, n); err != nil { err = fmt.Errorf("block %d: %w", l, err) }
+		}
+		if err != nil {
+			e.truncate(e.pos)
+			e.primed = false // the window stopped short
+			return nil, err
+		}
+	}
+	e.pos += n
+	last := make([]float32, H)
+	rmsNorm(last, x[(n-1)*H:], e.norm, e.NormEps)
+	if err := e.prefetch(len(e.blocks)); err != nil { return nil, err }
+	logits, _, _, err := e.m.Multiply(e.lmHead, last)
+	if err != nil { return nil, fmt.Errorf("lm_head: %w", err) }
+	return logits, nil
+}
+
+// block runs decoder block l over the n rows of x in place.
+func (e *Engine) block(l int, x []float32, n int) error {
+	b := &e.blocks[l]
+	H, hd := e.Hidden, e.HeadDim
+	qDim, kvDim := e.Heads*hd, e.KVHeads*hd
+	xn := make([]float32, n*H)
+	for t := 0; t < n; t++ { rmsNorm(xn[t*H:(t+1)*H], x[t*H:(t+1)*H], b.attnNorm, e.NormEps) }
+	q, err := e.linear(b.q, xn, n, b.qb)
+	if err != nil { return err }
+	k, err := e.linear(b.k, xn, n, b.kb)
+	if err != nil { return err }
+	v, err := e.linear(b.v, xn, n, b.vb)
+	if err != nil { return err }
+	for t := 0; t < n; t++ {
+		rope(q[t*qDim:(t+1)*qDim], hd, e.pos+t, e.freq)
+		rope(k[t*kvDim:(t+1)*kvDim], hd, e.pos+t, e.freq)
+	}
+	e.kc[l] = append(e.kc[l], k...)
+	e.vc[l] = append(e.vc[l], v...)
+	att := make([]float32, n*qDim)
+	scale := float32(1 / math.Sqrt(float64(hd)))
+	group := e.Heads / e.KVHeads
+	scores := make([]float32, e.pos+n)
+	for t := 0; t < n; t++ {
+		ctx := e.pos + t + 1 // causal: this token and everything before it
+		for h := 0; h < e.Heads; h++ {
+			qh := q[t*qDim+h*hd : t*qDim+(h+1)*hd]
+			kvOff := (h / group) * hd
+			s := scores[:ctx]
+			for p := range s {
+				kh := e.kc[l][p*kvDim+kvOff : p*kvDim+kvOff+hd]
+				d := float32(0)
+				for i, qv := range qh { d += qv * kh[i] }
+				s[p] = d * scale
+			}
+			softmax(s)
+			out := att[t*qDim+h*hd : t*qDim+(h+1)*hd]
+			for p, w := range s {
+				vh := e.vc[l][p*kvDim+kvOff : p*kvDim+kvOff+hd]
+				for i, vv := range vh { out[i] += w * vv }
+			}
+		}
+	}
+	o, err := e.linear(b.o, att, n, nil)
+	if err != nil { return err }
+	for i, v := range o { x[i] += v }
+
+	for t := 0; t < n; t++ { rmsNorm(xn[t*H:(t+1)*H], x[t*H:(t+1)*H], b.mlpNorm, e.NormEps) }
+	g, err := e.linear(b.gate, xn, n, nil)
+	if err != nil { return err }
+	u, err := e.linear(b.up, xn, n, nil)
+	if err != nil { return err }
+	for i := range g { g[i] = silu(g[i]) * u[i] }
+	d, err := e.linear(b.down, g, n, nil)
+	if err != nil { return err }
+	for i, v := range d { x[i] += v }
+	return nil
+}
+
+// linear multiplies scope by the n rows of X and adds bias to every output.
+func (e *Engine) linear(scope uint16, X []float32, n int, bias []float32) ([]float32, error) {
+	Y, rows, _, err := e.m.MultiplyBatch(scope, X, n)
+	if err != nil { return nil, fmt.Errorf("%s: %w", e.m.Name(scope), err) }
+	if bias != nil {
+		for t := 0; t < n; t++ {
+			for i, bv := range bias { Y[t*rows+i] += bv }
+		}
+	}
+	return Y, nil
+}
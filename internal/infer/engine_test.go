@@ -0,0 +1,344 @@
+package infer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/qrv0/crow/internal/cawsf"
+	"github.com/qrv0/crow/internal/convert"
+	"github.com/qrv0/crow/internal/fileformat"
+)
+
+// toyModel writes a random model with the given hf_config to a .cawsf file,
+// converting matrices with cfg and vectors raw, and opens it.
+func toyModel(t *testing.T, hf map[string]any, bias, lmHead bool, cfg convert.Config) *cawsf.Model {
+	t.Helper()
+	m, err := cawsf.OpenModel(toyFile(t, hf, bias, lmHead, cfg), nil)
+	if err != nil { t.Fatal(err) }
+	return m
+}
+
+// toyFile writes the file behind toyModel and returns its path.
+func toyFile(t *testing.T, hf map[string]any, bias, lmHead bool, cfg convert.Config) string {
+	t.Helper()
+	c, err := ConfigFromHF(hf)
+	if err != nil { t.Fatal(err) }
+	qDim, kvDim := c.Heads*c.HeadDim, c.KVHeads*c.HeadDim
+	shapes := map[string][]int{"model.embed_tokens.weight": {c.Vocab, c.Hidden}, "model.norm.weight": {c.Hidden}}
+	if lmHead { shapes["lm_head.weight"] = []int{c.Vocab, c.Hidden} }
+	for i := 0; i < c.Layers; i++ {
+		p := fmt.Sprintf("model.layers.%d.", i)
+		shapes[p+"input_layernorm.weight"] = []int{c.Hidden}
+		shapes[p+"post_attention_layernorm.weight"] = []int{c.Hidden}
+		shapes[p+"self_attn.q_proj.weight"] = []int{qDim, c.Hidden}
+		shapes[p+"self_attn.k_proj.weight"] = []int{kvDim, c.Hidden}
+		shapes[p+"self_attn.v_proj.weight"] = []int{kvDim, c.Hidden}
+		shapes[p+"self_attn.o_proj.weight"] = []int{c.Hidden, qDim}
+		shapes[p+"mlp.gate_proj.weight"] = []int{c.Intermediate, c.Hidden}
+		shapes[p+"mlp.up_proj.weight"] = []int{c.Intermediate, c.Hidden}
+		shapes[p+"mlp.down_proj.weight"] = []int{c.Hidden, c.Intermediate}
+		if bias {
+			shapes[p+"self_attn.q_proj.bias"] = []int{qDim}
+			shapes[p+"self_attn.k_proj.bias"] = []int{kvDim}
+			shapes[p+"self_attn.v_proj.bias"] = []int{kvDim}
+		}
+	}
+	names := make([]string, 0, len(shapes))
+	for n := range shapes { names = append(names, n) }
+	sort.Strings(names)
+	rng := rand.New(rand.NewSource(1))
+	var bank []byte
+	var layers []map[string]any
+	for sc, name := range names {
+		shape := shapes[name]
+		rows, cols, lc := 1, shape[0], convert.Config{StoreRaw: true}
+		if len(shape) == 2 { rows, cols, lc = shape[0], shape[1], cfg }
+		data := make([]float32, rows*cols)
+		for i := range data {
+			data[i] = float32(rng.NormFloat64()) * 0.3
+			if len(shape) == 1 { data[i] = 1 + data[i]/3 }
+		}
+		shards, err := convert.ConvertLayer(convert.LayerSpec{Name: name, Rows: rows, Cols: cols, Data: data, Scope: uint16(sc)}, lc)
+		if err != nil { t.Fatalf("%s: %v", name, err) }
+		for _, s := range shards { bank = append(bank, pack(s.Type, s.Scope, s.Data)...) }
+		layers = append(layers, map[string]any{"scope_id": sc, "name": name, "shape": shape})
+	}
+	meta, _ := json.Marshal(map[string]any{"hf_config": hf, "layers": layers})
+	path := filepath.Join(t.TempDir(), "toy.cawsf")
+	w := fileformat.NewWriter()
+	w.AddSection(fileformat.TypeMeta, meta, 0)
+	w.AddSection(fileformat.TypeShardBank, bank, fileformat.FlagCompLZ4)
+	if err := w.Write(path); err != nil { t.Fatal(err) }
+	return path
+}
+
+func pack(typ uint8, scope uint16, payload []byte) []byte {
+	hdr := make([]byte, 12, 12+len(payload))
+	hdr[0] = typ
+	binary.LittleEndian.PutUint16(hdr[1:], scope)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(payload)))
+	return append(hdr, payload...)
+}
+
+// reference recomputes the whole sequence from dense reconstructed weights
+// in float64, without a KV cache, and returns the logits after every token.
+func reference(t *testing.T, m *cawsf.Model, c Config, tokens []int) [][]float64 {
+	t.Helper()
+	w := func(name string) []float64 {
+		s, ok := m.ScopeByName(name)
+		if !ok { return nil }
+		_, _, d, err := m.Reconstruct(s.ID)
+		if err != nil { t.Fatal(err) }
+		out := make([]float64, len(d))
+		for i, v := range d { out[i] = float64(v) }
+		return out
+	}
+	matvec := func(W, x []float64, rows int) []float64 {
+		cols := len(x)
+		y := make([]float64, rows)
+		for r := range y {
+			for j, xv := range x { y[r] += W[r*cols+j] * xv }
+		}
+		return y
+	}
+	norm := func(x, g []float64) []float64 {
+		ss := 0.0
+		for _, v := range x { ss += v * v }
+		inv := 1 / math.Sqrt(ss/float64(len(x))+float64(c.NormEps))
+		out := make([]float64, len(x))
+		for i, v := range x { out[i] = v * inv * g[i] }
+		return out
+	}
+	rot := func(v []float64, pos int) {
+		half := c.HeadDim / 2
+		for h := 0; h < len(v); h += c.HeadDim {
+			for i := 0; i < half; i++ {
+				a := float64(pos) * math.Pow(c.RopeTheta, -2*float64(i)/float64(c.HeadDim))
+				x0, x1 := v[h+i], v[h+i+half]
+				v[h+i], v[h+i+half] = x0*math.Cos(a)-x1*math.Sin(a), x1*math.Cos(a)+x0*math.Sin(a)
+			}
+		}
+	}
+	addBias := func(y, b []float64) {
+		for i := range b { y[i] += b[i] }
+	}
+	emb := w("model.embed_tokens.weight")
+	head := w("lm_head.weight")
+	if head == nil { head = emb }
+	T := len(tokens)
+	xs := make([][]float64, T)
+	for i, tok := range tokens { xs[i] = append([]float64(nil), emb[tok*c.Hidden:(tok+1)*c.Hidden]...) }
+	for l := 0; l < c.Layers; l++ {
+		p := fmt.Sprintf("model.layers.%d.", l)
+		qs, ks, vs := make([][]float64, T), make([][]float64, T), make([][]float64, T)
+		for i := range xs {
+			xn := norm(xs[i], w(p+"input_layernorm.weight"))
+			qs[i] = matvec(w(p+"self_attn.q_proj.weight"), xn, c.Heads*c.HeadDim)
+			ks[i] = matvec(w(p+"self_attn.k_proj.weight"), xn, c.KVHeads*c.HeadDim)
+			vs[i] = matvec(w(p+"self_attn.v_proj.weight"), xn, c.KVHeads*c.HeadDim)
+			addBias(qs[i], w(p+"self_attn.q_proj.bias"))
+			addBias(ks[i], w(p+"self_attn.k_proj.bias"))
+			addBias(vs[i], w(p+"self_attn.v_proj.bias"))
+			rot(qs[i], i)
+			rot(ks[i], i)
+		}
+		for i := range xs {
+			att := make([]float64, c.Heads*c.HeadDim)
+			for h := 0; h < c.Heads; h++ {
+				kv := h / (c.Heads / c.KVHeads) * c.HeadDim
+				s := make([]float64, i+1)
+				mx := math.Inf(-1)
+				for j := range s {
+					for d := 0; d < c.HeadDim; d++ { s[j] += qs[i][h*c.HeadDim+d] * ks[j][kv+d] }
+					s[j] /= math.Sqrt(float64(c.HeadDim))
+					mx = math.Max(mx, s[j])
+				}
+				sum := 0.0
+				for j := range s {
+					s[j] = math.Exp(s[j] - mx)
+					sum += s[j]
+				}
+				for j := range s {
+					for d := 0; d < c.HeadDim; d++ { att[h*c.HeadDim+d] += s[j] / sum * vs[j][kv+d] }
+				}
+			}
+			o := matvec(w(p+"self_attn.o_proj.weight"), att, c.Hidden)
+			for d := range o { xs[i][d] += o[d] }
+			xn := norm(xs[i], w(p+"post_attention_layernorm.weight"))
+			g := matvec(w(p+"mlp.gate_proj.weight"), xn, c.Intermediate)
+			u := matvec(w(p+"mlp.up_proj.weight"), xn, c.Intermediate)
+			for d := range g { g[d] = g[d] / (1 + math.Exp(-g[d])) * u[d] }
+			dn := matvec(w(p+"mlp.down_proj.weight"), g, c.Hidden)
+			for d := range dn { xs[i][d] += dn[d] }
+		}
+	}
+	out := make([][]float64, T)
+	for i := range xs { out[i] = matvec(head, norm(xs[i], w("model.norm.weight")), c.Vocab) }
+	return out
+}
+
+func checkLogits(t *testing.T, what string, got []float32, want []float64) {
+	t.Helper()
+	for i := range want {
+		if d := math.Abs(float64(got[i]) - want[i]); d > 1e-3*(1+math.Abs(want[i])) { t.Fatalf("%s: logit %d = %f, want %f", what, i, got[i], want[i]) }
+	}
+}
+
+func TestForwardMatchesReference(t *testing.T) {
+	base := map[string]any{"hidden_size": 32.0, "num_hidden_layers": 2.0, "num_attention_heads": 4.0, "intermediate_size": 48.0, "vocab_size": 40.0, "rms_norm_eps": 1e-5, "rope_theta": 10000.0}
+	with := func(kv map[string]any) map[string]any {
+		out := map[string]any{}
+		for k, v := range base { out[k] = v }
+		for k, v := range kv { out[k] = v }
+		return out
+	}
+	cases := []struct {
+		name         string
+		hf           map[string]any
+		bias, lmHead bool
+		cfg          convert.Config
+	}{
+		{"llama", with(map[string]any{"model_type": "llama"}), false, true, convert.Config{StoreRaw: true}},
+		{"mistral-gqa", with(map[string]any{"model_type": "mistral", "num_key_value_heads": 2.0, "rope_theta": 1e6}), false, true, convert.Config{StoreRaw: true}},
+		{"qwen2-tied", with(map[string]any{"model_type": "qwen2", "num_key_value_heads": 1.0, "tie_word_embeddings": true}), true, false, convert.Config{StoreRaw: true}},
+		{"llama-pq", with(map[string]any{"model_type": "llama", "num_key_value_heads": 2.0}), false, true, convert.Config{Rank: 2, OutlierQuantile: 0.98, PQm: 2, PQk: 8, PQd: 8}},
+	}
+	tokens := []int{3, 17, 5, 39, 0, 22}
+	for _, tc := range cases {
+		m := toyModel(t, tc.hf, tc.bias, tc.lmHead, tc.cfg)
+		e, err := New(m)
+		if err != nil { t.Fatalf("%s: %v", tc.name, err) }
+		want := reference(t, m, e.Config, tokens)
+		// one token at a time through the KV cache
+		for i, tok := range tokens {
+			logits, err := e.Forward([]int{tok})
+			if err != nil { t.Fatalf("%s: %v", tc.name, err) }
+			checkLogits(t, fmt.Sprintf("%s step %d", tc.name, i), logits, want[i])
+		}
+		// a batched prompt, then one more token
+		e.Reset()
+		logits, err := e.Forward(tokens[:4])
+		if err != nil { t.Fatalf("%s: %v", tc.name, err) }
+		checkLogits(t, tc.name+" prefill", logits, want[3])
+		if logits, err = e.Forward(tokens[4:5]); err != nil { t.Fatalf("%s: %v", tc.name, err) }
+		checkLogits(t, tc.name+" after prefill", logits, want[4])
+		if e.Pos() != 5 { t.Fatalf("%s: pos %d", tc.name, e.Pos()) }
+	}
+}
+
+func TestNewRejectsIncompleteModels(t *testing.T) {
+	hf := map[string]any{"model_type": "llama", "hidden_size": 8.0, "num_hidden_layers": 1.0, "num_attention_heads": 2.0, "intermediate_size": 8.0, "vocab_size": 8.0}
+	m := toyModel(t, hf, false, false, convert.Config{StoreRaw: true})
+	if _, err := New(m); err == nil { t.Fatalf("untied model without lm_head accepted") }
+	if _, err := ConfigFromHF(map[string]any{"model_type": "gpt2"}); err == nil { t.Fatalf("gpt2 accepted") }
+	e, err := New(toyModel(t, hf, false, true, convert.Config{StoreRaw: true}))
+	if err != nil { t.Fatal(err) }
+	if _, err := e.Forward([]int{8}); err == nil { t.Fatalf("out-of-vocabulary token accepted") }
+}
+
+func TestForwardErrorKeepsCache(t *testing.T) {
+	hf := map[string]any{"model_type": "llama", "hidden_size": 16.0, "num_hidden_layers": 2.0, "num_attention_heads": 2.0, "intermediate_size": 24.0, "vocab_size": 20.0}
+	m := toyModel(t, hf, false, true, convert.Config{StoreRaw: true})
+	want, _ := New(m)
+	e, err := New(m)
+	if err != nil { t.Fatal(err) }
+	if _, err := e.Forward([]int{3, 7}); err != nil { t.Fatal(err) }
+	want.Forward([]int{3, 7})
+	// block 1 fails after block 0 and its own attention have cached the step
+	down := e.blocks[1].down
+	e.blocks[1].down = 9999
+	if _, err := e.Forward([]int{1, 12}); err == nil { t.Fatalf("missing scope accepted") }
+	if e.Pos() != 2 { t.Fatalf("pos %d after a failed step", e.Pos()) }
+	for l := range e.kc {
+		if len(e.kc[l]) != 2*e.KVHeads*e.HeadDim || len(e.vc[l]) != len(e.kc[l]) { t.Fatalf("block %d caches %d keys, %d values", l, len(e.kc[l]), len(e.vc[l])) }
+	}
+	e.blocks[1].down = down
+	got, err := e.Forward([]int{12})
+	if err != nil { t.Fatal(err) }
+	w, _ := want.Forward([]int{12})
+	for i := range w {
+		if got[i] != w[i] { t.Fatalf("logit %d = %v, want %v", i, got[i], w[i]) }
+	}
+}
+
+func TestForwardPrefetch(t *testing.T) {
+	hf := map[string]any{"model_type": "llama", "hidden_size": 16.0, "num_hidden_layers": 3.0, "num_attention_heads": 2.0, "intermediate_size": 24.0, "vocab_size": 20.0}
+	path := toyFile(t, hf, false, true, convert.Config{Rank: 2, OutlierQuantile: 0.98, PQm: 2, PQk: 8, PQd: 8})
+	plain, err := cawsf.OpenModel(path, nil)
+	if err != nil { t.Fatal(err) }
+	cache := cawsf.NewShardCache(1<<30, nil)
+	m, err := cawsf.OpenModel(path, cache)
+	if err != nil { t.Fatal(err) }
+	pf, err := cawsf.NewPrefetcher(context.Background(), m.Runtime, 2)
+	if err != nil { t.Fatal(err) }
+	defer pf.Close()
+	want, _ := New(plain)
+	e, err := New(m)
+	if err != nil { t.Fatal(err) }
+	e.Prefetch(pf, 1)
+	// a toy block runs faster than a goroutine starts, so give the first
+	// window a head start
+	if err := e.prefetch(0); err != nil { t.Fatal(err) }
+	if err := pf.Wait(); err != nil { t.Fatal(err) }
+	for _, step := range [][]int{{3, 7, 1}, {12}} {
+		w, err := want.Forward(step)
+		if err != nil { t.Fatal(err) }
+		got, err := e.Forward(step)
+		if err != nil { t.Fatal(err) }
+		for i := range w {
+			if got[i] != w[i] { t.Fatalf("logit %d = %v, want %v", i, got[i], w[i]) }
+		}
+	}
+	if err := pf.Wait(); err != nil { t.Fatal(err) }
+	// block 1 at least was loaded ahead; every shard loaded that way has
+	// been used by the second step
+	ps, st := pf.Stats(), cache.Stats()
+	if ps.Decoded < 28 || st.Prefetched != ps.Decoded || st.PrefetchHits != st.Prefetched || st.Hidden <= 0 { t.Fatalf("prefetch %+v, cache %+v", ps, st) }
+}
+
+func TestPrefetchStepsWrap(t *testing.T) {
+	e := &Engine{blocks: make([]block, 3), ahead: 2}
+	// blocks 0-2, the output head (3), then block 0 of the next Forward
+	want := [][]int{{1, 2}, {3}, {0}, {1}, {2}, {3}}
+	for i, w := range want {
+		if got := e.prefetchSteps(i % 4); !slices.Equal(got, w) { t.Fatalf("step %d queues %v, want %v", i, got, w) }
+	}
+	e = &Engine{blocks: make([]block, 1), ahead: 5}
+	if got := e.prefetchSteps(0); !slices.Equal(got, []int{1}) { t.Fatalf("window past the cycle: %v", got) }
+	if got := e.prefetchSteps(1); !slices.Equal(got, []int{0}) { t.Fatalf("window past the cycle: %v", got) }
+}
+
+func TestForwardPrefetchWrapsToBlock0(t *testing.T) {
+	hf := map[string]any{"model_type": "llama", "hidden_size": 16.0, "num_hidden_layers": 3.0, "num_attention_heads": 2.0, "intermediate_size": 24.0, "vocab_size": 20.0}
+	path := toyFile(t, hf, false, true, convert.Config{Rank: 2, OutlierQuantile: 0.98, PQm: 2, PQk: 8, PQd: 8})
+	cache := cawsf.NewShardCache(1<<30, nil)
+	m, err := cawsf.OpenModel(path, cache)
+	if err != nil { t.Fatal(err) }
+	pf, err := cawsf.NewPrefetcher(context.Background(), m.Runtime, 2)
+	if err != nil { t.Fatal(err) }
+	defer pf.Close()
+	e, err := New(m)
+	if err != nil { t.Fatal(err) }
+	// every shard of every step, block 0 included
+	steps := cawsf.NewShardCache(1<<30, nil)
+	all, err := cawsf.OpenModel(path, steps)
+	if err != nil { t.Fatal(err) }
+	for s := 0; s <= len(e.blocks); s++ {
+		for _, sc := range e.stepScopes(s) { all.Warm(sc) }
+	}
+	e.Prefetch(pf, 1)
+	if _, err := e.Forward([]int{3, 7}); err != nil { t.Fatal(err) }
+	if err := pf.Wait(); err != nil { t.Fatal(err) }
+	// steps 1 .. 3 while the blocks ran, then block 0 for the next Forward
+	// while the output head did
+	if ps := pf.Stats(); ps.Queued != uint64(steps.Stats().Entries) { t.Fatalf("queued %d shards, want %d: %+v", ps.Queued, steps.Stats().Entries, ps) }
+}
@@ -0,0 +1,46 @@
+package infer
+
+import "math"
+
+// rmsNorm writes x / rms(x) * w into out.
+func rmsNorm(out, x, w []float32, eps float32) {
+	ss := float32(0)
+	for _, v := range x { ss += v * v }
+	inv := float32(1 / math.Sqrt(float64(ss/float32(len(x))+eps)))
+	for i, v := range x { out[i] = v * inv * w[i] }
+}
+
+// ropeTable returns the per-pair inverse frequencies theta^(-2i/headDim).
+func ropeTable(headDim int, theta float64) []float64 {
+	f := make([]float64, headDim/2)
+	for i := range f { f[i] = math.Pow(theta, -2*float64(i)/float64(headDim)) }
+	return f
+}
+
+// rope rotates every head of v in place for position pos, pairing element i
+// with i+headDim/2 as the Hugging Face checkpoints expect.
+func rope(v []float32, headDim, pos int, freq []float64) {
+	half := headDim / 2
+	for h := 0; h+headDim <= len(v); h += headDim {
+		for i := 0; i < half; i++ {
+			sin, cos := math.Sincos(float64(pos) * freq[i])
+			a, b := v[h+i], v[h+i+half]
+			v[h+i] = a*float32(cos) - b*float32(sin)
+			v[h+i+half] = b*float32(cos) + a*float32(sin)
+		}
+	}
+}
+
+// softmax normalizes x in place.
+func softmax(x []float32) {
+	m := x[0]
+	for _, v := range x[1:] { m = max(m, v) }
+	sum := float32(0)
+	for i, v := range x {
+		x[i] = float32(math.Exp(float64(v - m)))
+		sum += x[i]
+	}
+	for i := range x { x[i] /= sum }
+}
+
+func silu(x float32) float32 { return x / (1 + float32(math.Exp(float64(-x)))) }
@@ -0,0 +1,71 @@
+package quant
+
+// MaxK is the largest codebook a PQ code can address (16-bit codes).
+const MaxK = 1<<16 - 1
+
+// CodeBits returns the packed code width used for a codebook of k entries:
+// 4, 8, 12 or 16 bits.
+func CodeBits(k int) int {
+	switch {
+	case k <= 16:
+		return 4
+	case k <= 256:
+		return 8
+	case k <= 4096:
+		return 12
+	}
+	return 16
+}
+
+// PackedLen is the byte length of n codes packed at the given width.
+func PackedLen(n, bits int) int { return (n*bits + 7) / 8 }
+
+// PackCodes concatenates the (N x m) codes and packs them LSB first: code i
+// occupies bits [i*bits, (i+1)*bits) of the little-endian bit stream, so 4-bit
+// codes put the even index in the low nibble and 12-bit codes share a byte
+// every other code.
+func PackCodes(codes [][]uint16, bits int) []byte {
+	n := 0
+	for _, c := range codes { n += len(c) }
+	out := make([]byte, PackedLen(n, bits))
+	i := 0
+	for _, row := range codes {
+		for _, c := range row {
+			PutCode(out, bits, i, int(c))
+			i++
+		}
+	}
+	return out
+}
+
+// PutCode stores code c at index i of a packed array.
+func PutCode(b []byte, bits, i, c int) {
+	switch bits {
+	case 8:
+		b[i] = byte(c)
+	case 16:
+		b[2*i] = byte(c)
+		b[2*i+1] = byte(c >> 8)
+	default:
+		bit := i * bits
+		for j := 0; j < bits; j++ {
+			if c&(1<<j) != 0 { b[(bit+j)/8] |= 1 << ((bit + j) % 8) }
+		}
+	}
+}
+
+// Code reads code i from a packed array.
+func Code(b []byte, bits, i int) int {
+	switch bits {
+	case 4:
+		return int(b[i>>1]>>(4*(i&1))) & 0xF
+	case 8:
+		return int(b[i])
+	case 12:
+		o := i * 3 / 2
+		v := int(b[o]) | int(b[o+1])<<8
+		if i&1 != 0 { v >>= 4 }
+		return v & 0xFFF
+	}
+	return int(b[2*i]) | int(b[2*i+1])<<8
+}
@@ -0,0 +1,20 @@
+package quant
+
+import "testing"
+
+func TestPackCodesRoundTrip(t *testing.T) {
+	for _, bits := range []int{4, 8, 12, 16} {
+		max := 1 << bits
+		codes := [][]uint16{{0, uint16(max - 1), 5}, {uint16(max / 2), 1, uint16(max - 2)}, {3}}
+		b := PackCodes(codes, bits)
+		if len(b) != PackedLen(7, bits) { t.Fatalf("bits=%d: %d bytes", bits, len(b)) }
+		i := 0
+		for _, row := range codes {
+			for _, c := range row {
+				if got := Code(b, bits, i); got != int(c) { t.Fatalf("bits=%d code %d: got %d want %d", bits, i, got, c) }
+				i++
+			}
+		}
+	}
+	if CodeBits(16) != 4 || CodeBits(17) != 8 || CodeBits(257) != 12 || CodeBits(4097) != 16 { t.Fatal("CodeBits thresholds") }
+}
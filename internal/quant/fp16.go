@@ -0,0 +1,47 @@
+package quant
+
+import "math"
+
+// FP16FromFloat32 truncates f to half precision, the form every fp16 field
+// of a .cawsf file is written in: the mantissa is cut rather than rounded,
+// values below the smallest normal become zero and values past the largest
+// become infinity.
+func FP16FromFloat32(f float32) uint16 {
+	u := math.Float32bits(f)
+	sign := uint16(u>>31) << 15
+	exp := int(u>>23) & 0xFF
+	switch e := exp - 127 + 15; {
+	case exp == 0 || e <= 0:
+		return sign
+	case exp == 0xFF || e >= 0x1F:
+		return sign | 0x1F<<10
+	default:
+		return sign | uint16(e)<<10 | uint16(u>>13)&0x3FF
+	}
+}
+
+// FP16ToFloat32 widens a half-precision value exactly, subnormals included.
+func FP16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	e := uint32(h>>10) & 0x1F
+	m := uint32(h) & 0x3FF
+	switch {
+	case e == 0 && m == 0:
+		return math.Float32frombits(sign)
+	case e == 0: // subnormal
+		v := float32(m) / (1 << 24)
+		if sign != 0 { v = -v }
+		return v
+	case e == 0x1F:
+		return math.Float32frombits(sign | 0xFF<<23 | m<<13)
+	}
+	return math.Float32frombits(sign | (e-15+127)<<23 | m<<13)
+}
+
+// RoundFP16 replaces every centroid with the value it reads back as once
+// stored in half precision.
+func (pq *PQ) RoundFP16() {
+	for _, cb := range pq.Codebooks {
+		for i, v := range cb { cb[i] = FP16ToFloat32(FP16FromFloat32(v)) }
+	}
+}
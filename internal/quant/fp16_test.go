@@ -0,0 +1,26 @@
+package quant
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFP16(t *testing.T) {
+	inf := float32(math.Inf(1))
+	for _, c := range []struct{ in, want float32 }{
+		{1, 1}, {-2.5, -2.5}, {65504, 65504}, {1e6, inf}, {-1e6, -inf},
+		// truncated, not rounded
+		{1 + 1.0/2048 + 1.0/4096, 1},
+		// below the smallest normal
+		{1e-5, 0},
+	} {
+		if got := FP16ToFloat32(FP16FromFloat32(c.in)); got != c.want { t.Errorf("%v -> %v, want %v", c.in, got, c.want) }
+	}
+	// every normal half survives a round trip; subnormals only widen
+	for h := 0; h < 1<<16; h++ {
+		f := FP16ToFloat32(uint16(h))
+		if f != f || math.IsInf(float64(f), 0) || (h&0x7C00 == 0 && h&0x3FF != 0) { continue }
+		if back := FP16FromFloat32(f); back != uint16(h) { t.Fatalf("%#04x -> %v -> %#04x", h, f, back) }
+	}
+	if f := FP16ToFloat32(1); f != 1.0/(1<<24) { t.Errorf("smallest subnormal %v", f) }
+}
@@ -0,0 +1,215 @@
+package quant
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// KMeansOptions tunes the per-subspace k-means in TrainPQWith.
+type KMeansOptions struct {
+	Iters     int     // maximum Lloyd iterations (mini-batch: batches = 10*Iters)
+	Tol       float64 // stop once inertia improves by less than Tol (relative); 0 = 1e-4
+	Workers   int     // assignment goroutines; 0 = GOMAXPROCS
+	BatchSize int     // > 0 switches to mini-batch k-means with batches of this size
+	Seed      int64
+}
+
+// assignChunk is the unit of parallel work. Partial sums are merged in chunk
+// order, so results do not depend on the number of workers.
+const assignChunk = 1024
+
+// initSampleMax bounds the vectors k-means++ seeds from (at least 16 per centroid).
+const initSampleMax = 4096
+
+func (o KMeansOptions) tol() float64 {
+	if o.Tol > 0 { return o.Tol }
+	return 1e-4
+}
+
+func (o KMeansOptions) workers() int {
+	if o.Workers > 0 { return o.Workers }
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallelChunks calls fn(chunk, lo, hi) for every assignChunk-sized slice of
+// [0, n), spread over workers goroutines.
+func parallelChunks(n, workers int, fn func(chunk, lo, hi int)) {
+	chunks := (n + assignChunk - 1) / assignChunk
+	if workers > chunks { workers = chunks }
+	if workers <= 1 {
+		for c := 0; c < chunks; c++ { fn(c, c*assignChunk, min((c+1)*assignChunk, n)) }
+		return
+	}
+	var wg sync.WaitGroup
+	next := make(chan int, chunks)
+	for c := 0; c < chunks; c++ { next <- c }
+	close(next)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next { fn(c, c*assignChunk, min((c+1)*assignChunk, n)) }
+		}()
+	}
+	wg.Wait()
+}
+
+// nearest returns the closest centroid in flat ([k][D]) and its squared distance.
+func nearest(v, flat []float32, k int) (int, float32) {
+	D := len(v)
+	best, bestd := 0, float32(math.MaxFloat32)
+	for j := 0; j < k; j++ {
+		if d := l2(v, flat[j*D:(j+1)*D]); d < bestd { bestd, best = d, j }
+	}
+	return best, bestd
+}
+
+func kmeans(data [][]float32, k int, opt KMeansOptions, rng *rand.Rand) []float32 {
+	cent := kmeansPPInit(data, k, rng)
+	if opt.BatchSize > 0 && opt.BatchSize < len(data) { return miniBatchKMeans(data, cent, k, opt, rng) }
+	return lloyd(data, cent, k, opt)
+}
+
+// kmeansPPInit seeds k centroids with k-means++ (D^2 sampling) over at most
+// max(initSampleMax, 16k) vectors.
+func kmeansPPInit(data [][]float32, k int, rng *rand.Rand) []float32 {
+	D := len(data[0])
+	sample := data
+	if lim := max(initSampleMax, 16*k); len(data) > lim {
+		sample = make([][]float32, lim)
+		for i, j := range rng.Perm(len(data))[:lim] { sample[i] = data[j] }
+	}
+	cent := make([]float32, k*D)
+	copy(cent, sample[rng.Intn(len(sample))])
+	d2 := make([]float64, len(sample))
+	for i, v := range sample { d2[i] = float64(l2(v, cent[:D])) }
+	for j := 1; j < k; j++ {
+		total := 0.0
+		for _, d := range d2 { total += d }
+		pick := rng.Intn(len(sample))
+		if total > 0 {
+			r := rng.Float64() * total
+			for i, d := range d2 {
+				if r -= d; r <= 0 { pick = i; break }
+			}
+		}
+		c := cent[j*D : (j+1)*D]
+		copy(c, sample[pick])
+		for i, v := range sample {
+			if d := float64(l2(v, c)); d < d2[i] { d2[i] = d }
+		}
+	}
+	return cent
+}
+
+// lloyd runs full-batch iterations with parallel assignment, reseeding empty
+// clusters with the vectors farthest from their centroid.
+func lloyd(data [][]float32, cent []float32, k int, opt KMeansOptions) []float32 {
+	N, D := len(data), len(data[0])
+	chunks := (N + assignChunk - 1) / assignChunk
+	sums := make([][]float64, chunks)
+	counts := make([][]int, chunks)
+	inertia := make([]float64, chunks)
+	dist := make([]float32, N)
+	prev := math.Inf(1)
+	for it := 0; it < opt.Iters; it++ {
+		parallelChunks(N, opt.workers(), func(c, lo, hi int) {
+			if sums[c] == nil { sums[c] = make([]float64, k*D); counts[c] = make([]int, k) }
+			s, n := sums[c], counts[c]
+			for i := range s { s[i] = 0 }
+			for i := range n { n[i] = 0 }
+			in := 0.0
+			for i := lo; i < hi; i++ {
+				j, d := nearest(data[i], cent, k)
+				dist[i] = d
+				in += float64(d)
+				n[j]++
+				for t, v := range data[i] { s[j*D+t] += float64(v) }
+			}
+			inertia[c] = in
+		})
+		total := 0.0
+		sum := make([]float64, k*D)
+		cnt := make([]int, k)
+		for c := 0; c < chunks; c++ {
+			total += inertia[c]
+			for i, v := range sums[c] { sum[i] += v }
+			for j, v := range counts[c] { cnt[j] += v }
+		}
+		var empty []int
+		for j := 0; j < k; j++ {
+			if cnt[j] == 0 { empty = append(empty, j); continue }
+			inv := 1 / float64(cnt[j])
+			for t := 0; t < D; t++ { cent[j*D+t] = float32(sum[j*D+t] * inv) }
+		}
+		reseedFarthest(data, dist, cent, empty)
+		if len(empty) == 0 && !math.IsInf(prev, 1) && prev-total <= opt.tol()*prev { break }
+		prev = total
+	}
+	return cent
+}
+
+// reseedFarthest moves each empty centroid onto one of the vectors that were
+// worst served in the last assignment.
+func reseedFarthest(data [][]float32, dist []float32, cent []float32, empty []int) {
+	D := len(data[0])
+	for _, j := range empty {
+		far := 0
+		for i, d := range dist {
+			if d > dist[far] { far = i }
+		}
+		copy(cent[j*D:(j+1)*D], data[far])
+		dist[far] = 0
+	}
+}
+
+// miniBatchKMeans updates centroids from random batches with per-centroid
+// learning rates 1/count (Sculley 2010). It stops early once the smoothed
+// batch inertia stops improving.
+func miniBatchKMeans(data [][]float32, cent []float32, k int, opt KMeansOptions, rng *rand.Rand) []float32 {
+	D := len(data[0])
+	B := opt.BatchSize
+	seen := make([]int, k)
+	batch := make([][]float32, B)
+	assign := make([]int, B)
+	dist := make([]float32, B)
+	ewa := math.Inf(1)
+	const alpha = 0.1
+	for step := 0; step < 10*opt.Iters; step++ {
+		for i := range batch { batch[i] = data[rng.Intn(len(data))] }
+		parallelChunks(B, opt.workers(), func(_, lo, hi int) {
+			for i := lo; i < hi; i++ { assign[i], dist[i] = nearest(batch[i], cent, k) }
+		})
+		in := 0.0
+		for i, v := range batch {
+			j := assign[i]
+			seen[j]++
+			in += float64(dist[i])
+			eta := float32(1) / float32(seen[j])
+			c := cent[j*D : (j+1)*D]
+			for t := range c { c[t] += eta * (v[t] - c[t]) }
+		}
+		in /= float64(B)
+		// centroids nothing has landed on after a few batches are reseeded
+		if step >= 3 {
+			var empty []int
+			for j, n := range seen {
+				if n == 0 { empty = append(empty, j) }
+			}
+			if len(empty) > 0 {
+				reseedFarthest(batch, dist, cent, empty)
+				for _, j := range empty { seen[j] = 1 }
+			}
+		}
+		if math.IsInf(ewa, 1) {
+			ewa = in
+			continue
+		}
+		next := (1-alpha)*ewa + alpha*in
+		if step >= opt.Iters && ewa-next <= opt.tol()*ewa { break }
+		ewa = next
+	}
+	return cent
+}
@@ -0,0 +1,57 @@
+package quant
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// blobs draws n points around each of the given 2D centers.
+func blobs(centers [][2]float32, n int, seed int64) [][]float32 {
+	rng := rand.New(rand.NewSource(seed))
+	var out [][]float32
+	for i := 0; i < n; i++ {
+		for _, c := range centers {
+			out = append(out, []float32{c[0] + 0.05*float32(rng.NormFloat64()), c[1] + 0.05*float32(rng.NormFloat64())})
+		}
+	}
+	return out
+}
+
+func checkCentersFound(t *testing.T, cb []float32, centers [][2]float32) {
+	t.Helper()
+	for _, c := range centers {
+		best := math.Inf(1)
+		for j := 0; j < len(cb)/2; j++ {
+			best = math.Min(best, math.Hypot(float64(cb[2*j]-c[0]), float64(cb[2*j+1]-c[1])))
+		}
+		if best > 0.1 { t.Fatalf("no centroid near %v (closest %.3f): %v", c, best, cb) }
+	}
+}
+
+var testCenters = [][2]float32{{0, 0}, {5, 5}, {-5, 5}, {5, -5}, {-5, -5}, {0, 8}}
+
+func TestKMeansFindsBlobs(t *testing.T) {
+	data := blobs(testCenters, 200, 1)
+	pq := TrainPQWith(data, 1, len(testCenters), KMeansOptions{Iters: 50, Seed: 7})
+	checkCentersFound(t, pq.Codebooks[0], testCenters)
+}
+
+func TestMiniBatchKMeansFindsBlobs(t *testing.T) {
+	data := blobs(testCenters, 2000, 2)
+	pq := TrainPQWith(data, 1, len(testCenters), KMeansOptions{Iters: 30, BatchSize: 256, Seed: 7})
+	checkCentersFound(t, pq.Codebooks[0], testCenters)
+}
+
+func TestKMeansDeterministicAcrossWorkers(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	data := make([][]float32, 5000)
+	for i := range data {
+		data[i] = make([]float32, 8)
+		for j := range data[i] { data[i][j] = float32(rng.NormFloat64()) }
+	}
+	a := TrainPQWith(data, 2, 32, KMeansOptions{Iters: 10, Seed: 5, Workers: 1})
+	b := TrainPQWith(data, 2, 32, KMeansOptions{Iters: 10, Seed: 5, Workers: 8})
+	if !reflect.DeepEqual(a.Codebooks, b.Codebooks) { t.Fatal("codebooks depend on the worker count") }
+}
@@ -0,0 +1,176 @@
+package quant
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// rotation kinds as stored in R shards
+const (
+	RotOPQ      = 1 // learned d x d orthogonal matrix
+	RotHadamard = 2 // randomized Hadamard transform, rebuilt from a seed
+)
+
+// Rotation is an orthogonal transform M applied to sub-vector blocks before
+// PQ. Because M is orthogonal, w·x = (Mw)·(Mx): weights are quantized in the
+// rotated space and inputs are rotated with the same Apply at inference,
+// while reconstructing weights needs ApplyT.
+type Rotation struct {
+	Kind  uint8
+	D     int
+	Q     []float32 // RotOPQ: row-major d x d
+	Seed  uint64    // RotHadamard
+	signs []float32
+}
+
+// NewHadamard returns M = H*S/sqrt(d) with S a random ±1 diagonal drawn from
+// seed; d must be a power of two.
+func NewHadamard(d int, seed uint64) (*Rotation, error) {
+	if d <= 0 || d&(d-1) != 0 { return nil, fmt.Errorf("hadamard rotation needs a power-of-two block size, got %d", d) }
+	r := &Rotation{Kind: RotHadamard, D: d, Seed: seed, signs: make([]float32, d)}
+	rng := rand.New(rand.NewSource(int64(seed)))
+	for i := range r.signs {
+		r.signs[i] = 1
+		if rng.Intn(2) == 1 { r.signs[i] = -1 }
+	}
+	return r, nil
+}
+
+// fwht is the in-place unnormalized fast Walsh-Hadamard transform.
+func fwht(v []float32) {
+	for h := 1; h < len(v); h <<= 1 {
+		for i := 0; i < len(v); i += h << 1 {
+			for j := i; j < i+h; j++ {
+				a, b := v[j], v[j+h]
+				v[j], v[j+h] = a+b, a-b
+			}
+		}
+	}
+}
+
+// Apply replaces v (length D) with M*v. tmp, if len >= D, avoids an allocation
+// for OPQ.
+func (r *Rotation) Apply(v, tmp []float32) {
+	switch r.Kind {
+	case RotHadamard:
+		for i := range v { v[i] *= r.signs[i] }
+		fwht(v)
+		scale := float32(1 / math.Sqrt(float64(r.D)))
+		for i := range v { v[i] *= scale }
+	case RotOPQ:
+		r.matVec(v, tmp, false)
+	}
+}
+
+// ApplyT replaces v with M^T*v, undoing Apply.
+func (r *Rotation) ApplyT(v, tmp []float32) {
+	switch r.Kind {
+	case RotHadamard:
+		fwht(v)
+		scale := float32(1 / math.Sqrt(float64(r.D)))
+		for i := range v { v[i] *= scale * r.signs[i] }
+	case RotOPQ:
+		r.matVec(v, tmp, true)
+	}
+}
+
+func (r *Rotation) matVec(v, tmp []float32, transpose bool) {
+	d := r.D
+	if len(tmp) < d { tmp = make([]float32, d) }
+	tmp = tmp[:d]
+	copy(tmp, v)
+	for i := 0; i < d; i++ {
+		s := float32(0)
+		for j := 0; j < d; j++ {
+			if transpose {
+				s += r.Q[j*d+i] * tmp[j]
+			} else {
+				s += r.Q[i*d+j] * tmp[j]
+			}
+		}
+		v[i] = s
+	}
+}
+
+// EncodedLen is the size of the rotation block AppendBinary writes.
+func (r *Rotation) EncodedLen() int {
+	if r.Kind == RotOPQ { return 1 + 4*r.D*r.D }
+	return 9
+}
+
+// AppendBinary appends kind:u8 then Q:[d*d]f32 (OPQ) or seed:u64 (Hadamard).
+func (r *Rotation) AppendBinary(b []byte) []byte {
+	b = append(b, r.Kind)
+	if r.Kind == RotOPQ {
+		for _, v := range r.Q { b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v)) }
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(b, r.Seed)
+}
+
+// ParseRotation reads a rotation block for d-wide blocks and returns it with
+// the number of bytes consumed.
+func ParseRotation(b []byte, d int) (*Rotation, int, error) {
+	if len(b) < 1 { return nil, 0, fmt.Errorf("short rotation") }
+	switch b[0] {
+	case RotOPQ:
+		n := 1 + 4*d*d
+		if len(b) < n { return nil, 0, fmt.Errorf("short rotation") }
+		q := make([]float32, d*d)
+		for i := range q { q[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[1+4*i:])) }
+		return &Rotation{Kind: RotOPQ, D: d, Q: q}, n, nil
+	case RotHadamard:
+		if len(b) < 9 { return nil, 0, fmt.Errorf("short rotation") }
+		r, err := NewHadamard(d, binary.LittleEndian.Uint64(b[1:9]))
+		return r, 9, err
+	}
+	return nil, 0, fmt.Errorf("unknown rotation kind %d", b[0])
+}
+
+// TrainOPQ learns a rotation and a PQ for it with non-parametric OPQ (Ge et
+// al. 2013): alternate training PQ on the rotated data with solving the
+// orthogonal Procrustes problem that best maps the data onto its
+// reconstruction. data is left unrotated; rotate it with Apply before Encode.
+func TrainOPQ(data [][]float32, m, k, outer int, opt KMeansOptions) (*Rotation, *PQ) {
+	if len(data) == 0 { return &Rotation{Kind: RotOPQ}, &PQ{M: m, K: k} }
+	d := len(data[0])
+	rot := &Rotation{Kind: RotOPQ, D: d, Q: make([]float32, d*d)}
+	for i := 0; i < d; i++ { rot.Q[i*d+i] = 1 }
+	rotated := make([][]float32, len(data))
+	for i := range rotated { rotated[i] = make([]float32, d) }
+	tmp := make([]float32, d)
+	inner := opt
+	inner.Iters = max(1, opt.Iters/4)
+	for it := 0; it < outer; it++ {
+		for i, v := range data { copy(rotated[i], v); rot.Apply(rotated[i], tmp) }
+		pq := TrainPQWith(rotated, m, k, inner)
+		rec := pq.Decode(pq.Encode(rotated))
+		// R = U V^T for U S V^T = svd(sum_i rec_i x_i^T)
+		acc := make([]float64, d*d)
+		for i, x := range data {
+			for a := 0; a < d; a++ {
+				ra := float64(rec[i][a])
+				if ra == 0 { continue }
+				row := acc[a*d : (a+1)*d]
+				for b, xb := range x { row[b] += ra * float64(xb) }
+			}
+		}
+		c := mat.NewDense(d, d, acc)
+		var svd mat.SVD
+		if !svd.Factorize(c, mat.SVDFull) { break }
+		var u, v, q mat.Dense
+		svd.UTo(&u)
+		svd.VTo(&v)
+		q.Mul(&u, v.T())
+		for a := 0; a < d; a++ {
+			for b := 0; b < d; b++ { rot.Q[a*d+b] = float32(q.At(a, b)) }
+		}
+	}
+	for i, v := range data { copy(rotated[i], v); rot.Apply(rotated[i], tmp) }
+	return rot, TrainPQWith(rotated, m, k, opt)
+}
+
@@ -0,0 +1,36 @@
+package quant
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestRotationRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	data := make([][]float32, 300)
+	for i := range data {
+		data[i] = make([]float32, 8)
+		for j := range data[i] { data[i][j] = float32(rng.NormFloat64()) * float32(j+1) }
+	}
+	had, err := NewHadamard(8, 42)
+	if err != nil { t.Fatal(err) }
+	opq, _ := TrainOPQ(data, 2, 8, 2, KMeansOptions{Iters: 8, Seed: 1})
+	if _, err := NewHadamard(12, 1); err == nil { t.Fatalf("hadamard accepted d=12") }
+	for _, rot := range []*Rotation{had, opq} {
+		back, n, err := ParseRotation(rot.AppendBinary(nil), 8)
+		if err != nil || n != rot.EncodedLen() { t.Fatalf("kind %d: parse n=%d err=%v", rot.Kind, n, err) }
+		v := append([]float32(nil), data[0]...)
+		w := append([]float32(nil), data[1]...)
+		var dot, rdot float64
+		for j := range v { dot += float64(v[j] * w[j]) }
+		back.Apply(v, nil)
+		back.Apply(w, nil)
+		for j := range v { rdot += float64(v[j] * w[j]) }
+		if math.Abs(dot-rdot) > 1e-3*(1+math.Abs(dot)) { t.Fatalf("kind %d: dot %f rotated %f", rot.Kind, dot, rdot) }
+		back.ApplyT(v, nil)
+		for j := range v {
+			if math.Abs(float64(v[j]-data[0][j])) > 1e-4 { t.Fatalf("kind %d: ApplyT(Apply(v)) = %v want %v", rot.Kind, v, data[0]) }
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package quant
+
+// RVQ is a residual vector quantizer: stage s product-quantizes what stages
+// 0..s-1 left over, so a vector decodes to the sum of one codeword per stage
+// and sub-space.
+type RVQ struct {
+	Stages []*PQ
+}
+
+// TrainRVQ trains stages residual PQ stages on data (N x D). Every stage uses
+// m sub-quantizers with k centroids; the seed advances per stage. With fp16
+// each stage's centroids are rounded to half precision before its residual
+// is taken, so later stages quantize what a reader of fp16 codebooks sees.
+func TrainRVQ(data [][]float32, stages, m, k int, opt KMeansOptions, fp16 bool) *RVQ {
+	rvq := &RVQ{}
+	resid := make([][]float32, len(data))
+	for i, v := range data { resid[i] = append([]float32(nil), v...) }
+	for s := 0; s < stages; s++ {
+		o := opt
+		o.Seed += int64(s)
+		pq := TrainPQWith(resid, m, k, o)
+		if fp16 { pq.RoundFP16() }
+		rvq.Stages = append(rvq.Stages, pq)
+		if s == stages-1 || len(resid) == 0 { break }
+		dec := pq.Decode(pq.Encode(resid))
+		for i := range resid {
+			for j := range resid[i] { resid[i][j] -= dec[i][j] }
+		}
+	}
+	return rvq
+}
+
+// Encode returns one (N x m) code matrix per stage.
+func (r *RVQ) Encode(data [][]float32) [][][]uint16 {
+	resid := make([][]float32, len(data))
+	for i, v := range data { resid[i] = append([]float32(nil), v...) }
+	out := make([][][]uint16, len(r.Stages))
+	for s, pq := range r.Stages {
+		out[s] = pq.Encode(resid)
+		if s == len(r.Stages)-1 { break }
+		dec := pq.Decode(out[s])
+		for i := range resid {
+			for j := range resid[i] { resid[i][j] -= dec[i][j] }
+		}
+	}
+	return out
+}
+
+// Decode sums the per-stage reconstructions.
+func (r *RVQ) Decode(codes [][][]uint16) [][]float32 {
+	var out [][]float32
+	for s, pq := range r.Stages {
+		dec := pq.Decode(codes[s])
+		if out == nil { out = dec; continue }
+		for i := range out {
+			for j := range out[i] { out[i][j] += dec[i][j] }
+		}
+	}
+	return out
+}
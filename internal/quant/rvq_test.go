@@ -0,0 +1,40 @@
+package quant
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTrainRVQFP16(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	data := make([][]float32, 300)
+	for i := range data {
+		data[i] = make([]float32, 8)
+		for j := range data[i] { data[i][j] = float32(rng.NormFloat64()) * 3.7 }
+	}
+	opt := KMeansOptions{Iters: 10, Seed: 1}
+	rvq := TrainRVQ(data, 2, 2, 8, opt, true)
+	for s, pq := range rvq.Stages {
+		for _, cb := range pq.Codebooks {
+			for _, v := range cb {
+				if FP16ToFloat32(FP16FromFloat32(v)) != v { t.Fatalf("stage %d centroid %v is not fp16", s, v) }
+			}
+		}
+	}
+	// stage 1 trains on what the rounded stage 0 leaves
+	first := TrainPQWith(data, 2, 8, opt)
+	first.RoundFP16()
+	dec := first.Decode(first.Encode(data))
+	resid := make([][]float32, len(data))
+	for i := range data {
+		resid[i] = make([]float32, len(data[i]))
+		for j := range resid[i] { resid[i][j] = data[i][j] - dec[i][j] }
+	}
+	opt.Seed++
+	second := TrainPQWith(resid, 2, 8, opt)
+	second.RoundFP16()
+	for i := range second.Codebooks {
+		if !slices.Equal(second.Codebooks[i], rvq.Stages[1].Codebooks[i]) { t.Fatalf("stage 1 sub-quantizer %d trained on another residual", i) }
+	}
+}
@@ -0,0 +1,102 @@
+package quant
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CODEBOOKS entries, as stored in .cawsf files and read by
+// cawsf.ParseCodebookPool:
+//
+//	id:u16 d:u16 m:u16 k:u16 size:u32 data:[m][k][dsub]f32|f16
+//
+// fp16 entries are told apart by size == 2*k*d. A whole section is count:u16
+// followed by that many entries.
+const entryHeader = 12
+
+// MarshalEntry appends pq as a CODEBOOKS entry with the given id, storing the
+// codebooks as fp16 when fp16 is set.
+func (pq *PQ) MarshalEntry(b []byte, id uint16, fp16 bool) []byte {
+	d := pq.M * pq.Dsub
+	elem := 4
+	if fp16 { elem = 2 }
+	b = binary.LittleEndian.AppendUint16(b, id)
+	b = binary.LittleEndian.AppendUint16(b, uint16(d))
+	b = binary.LittleEndian.AppendUint16(b, uint16(pq.M))
+	b = binary.LittleEndian.AppendUint16(b, uint16(pq.K))
+	b = binary.LittleEndian.AppendUint32(b, uint32(elem*pq.K*d))
+	for _, cb := range pq.Codebooks {
+		for _, v := range cb[:pq.K*pq.Dsub] {
+			if fp16 {
+				b = binary.LittleEndian.AppendUint16(b, FP16FromFloat32(v))
+			} else {
+				b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+			}
+		}
+	}
+	return b
+}
+
+// UnmarshalEntry reads one CODEBOOKS entry and returns it with its id and
+// the number of bytes consumed.
+func UnmarshalEntry(b []byte) (pq *PQ, id uint16, n int, err error) {
+	if len(b) < entryHeader { return nil, 0, 0, fmt.Errorf("codebooks: short entry header") }
+	id = binary.LittleEndian.Uint16(b[0:2])
+	d := int(binary.LittleEndian.Uint16(b[2:4]))
+	m := int(binary.LittleEndian.Uint16(b[4:6]))
+	k := int(binary.LittleEndian.Uint16(b[6:8]))
+	size := int(binary.LittleEndian.Uint32(b[8:12]))
+	if m == 0 || d%m != 0 { return nil, 0, 0, fmt.Errorf("codebooks: entry %d has d=%d m=%d", id, d, m) }
+	n = entryHeader + size
+	if len(b) < n { return nil, 0, 0, fmt.Errorf("codebooks: short data") }
+	elem := 4
+	if size == 2*k*d { elem = 2 } else if size != 4*k*d { return nil, 0, 0, fmt.Errorf("codebooks: entry %d size %d does not fit k=%d d=%d", id, size, k, d) }
+	pq = &PQ{M: m, K: k, Dsub: d / m, Codebooks: make([][]float32, m)}
+	data := b[entryHeader:n]
+	for i := range pq.Codebooks {
+		cb := make([]float32, k*pq.Dsub)
+		for j := range cb {
+			o := (i*len(cb) + j) * elem
+			if elem == 2 {
+				cb[j] = FP16ToFloat32(binary.LittleEndian.Uint16(data[o:]))
+			} else {
+				cb[j] = math.Float32frombits(binary.LittleEndian.Uint32(data[o:]))
+			}
+		}
+		pq.Codebooks[i] = cb
+	}
+	return pq, id, n, nil
+}
+
+// MarshalCodebooks writes a CODEBOOKS section holding pqs with ids 0..n-1.
+func MarshalCodebooks(pqs []*PQ, fp16 bool) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, uint16(len(pqs)))
+	for i, pq := range pqs { b = pq.MarshalEntry(b, uint16(i), fp16) }
+	return b
+}
+
+// UnmarshalCodebooks reads a CODEBOOKS section into a map keyed by entry id.
+func UnmarshalCodebooks(b []byte) (map[uint16]*PQ, error) {
+	out := map[uint16]*PQ{}
+	if len(b) == 0 { return out, nil }
+	if len(b) < 2 { return nil, fmt.Errorf("codebooks: short header") }
+	cnt := int(binary.LittleEndian.Uint16(b[0:2]))
+	off := 2
+	for i := 0; i < cnt; i++ {
+		pq, id, n, err := UnmarshalEntry(b[off:])
+		if err != nil { return nil, err }
+		out[id] = pq
+		off += n
+	}
+	return out, nil
+}
+
+// MSE is the mean squared reconstruction error per element of data under pq.
+func (pq *PQ) MSE(data [][]float32) float64 {
+	if len(data) == 0 { return 0 }
+	rec := pq.Decode(pq.Encode(data))
+	var s float64
+	for i, v := range data { s += float64(l2(v, rec[i])) }
+	return s / float64(len(data)*pq.M*pq.Dsub)
+}
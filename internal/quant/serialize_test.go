@@ -0,0 +1,30 @@
+package quant
+
+import "testing"
+
+func TestCodebooksRoundTrip(t *testing.T) {
+	data := blobs(testCenters, 50, 2)
+	a := TrainPQWith(data, 2, 6, KMeansOptions{Iters: 10, Seed: 1})
+	b := TrainPQWith(data, 1, 300, KMeansOptions{Iters: 5, Seed: 1})
+	for _, fp16 := range []bool{false, true} {
+		got, err := UnmarshalCodebooks(MarshalCodebooks([]*PQ{a, b}, fp16))
+		if err != nil { t.Fatal(err) }
+		for id, want := range []*PQ{a, b} {
+			pq := got[uint16(id)]
+			if pq == nil || pq.M != want.M || pq.K != want.K || pq.Dsub != want.Dsub { t.Fatalf("fp16=%v id %d: got %+v", fp16, id, pq) }
+			for i := range want.Codebooks {
+				for j, v := range want.Codebooks[i] {
+					tol := float32(0)
+					if fp16 { tol = 1e-2 * (1 + abs32(v)) }
+					if abs32(pq.Codebooks[i][j]-v) > tol { t.Fatalf("fp16=%v id %d cb[%d][%d] = %f want %f", fp16, id, i, j, pq.Codebooks[i][j], v) }
+				}
+			}
+		}
+	}
+	if _, err := UnmarshalCodebooks([]byte{1, 0, 0, 0}); err == nil { t.Fatalf("truncated section accepted") }
+}
+
+func abs32(v float32) float32 {
+	if v < 0 { return -v }
+	return v
+}
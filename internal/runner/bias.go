@@ -0,0 +1,18 @@
+package runner
+
+import (
+	"fmt"
+	"math"
+)
+
+// logitBiasArg formats one logit bias for the llama.cpp binding, which reads
+// "<id>+<bias>" or "<id>-<bias>" and hands the magnitude to std::stof. A ban
+// (-Inf) goes out as "<id>-inf", the form llama.cpp itself uses, since %g
+// would print "+Inf" after the sign.
+func logitBiasArg(id int, bias float32) string {
+	sign := "+"
+	if bias < 0 { sign = "-" }
+	mag := math.Abs(float64(bias))
+	if math.IsInf(mag, 1) { return fmt.Sprintf("%d%sinf", id, sign) }
+	return fmt.Sprintf("%d%s%g", id, sign, mag)
+}
@@ -0,0 +1,22 @@
+package runner
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLogitBiasArg(t *testing.T) {
+	for _, c := range []struct {
+		id   int
+		bias float32
+		want string
+	}{
+		{2, float32(math.Inf(-1)), "2-inf"},
+		{7, float32(math.Inf(1)), "7+inf"},
+		{15043, 1.5, "15043+1.5"},
+		{3, -0.25, "3-0.25"},
+		{0, 0, "0+0"},
+	} {
+		if got := logitBiasArg(c.id, c.bias); got != c.want { t.Errorf("logitBiasArg(%d, %v) = %q, want %q", c.id, c.bias, got, c.want) }
+	}
+}
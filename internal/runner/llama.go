@@ -5,9 +5,12 @@ package runner
 import (
 	"context"
 	"fmt"
+	"math"
 	"time"
 
 	llama "github.com/go-skynet/go-llama.cpp"
+
+	"github.com/qrv0/crow/internal/sampling"
 )
 
 type LLaMARunner struct {
@@ -19,12 +22,12 @@ type RunOptions struct {
 	GPULayers int
 }
 
-type SampleOptions struct {
-	Temperature   float64
-	TopK          int
-	TopP          float64
-	RepeatPenalty float64
-}
+// SampleOptions are the Go-side sampler settings; Generate forwards the
+// ones the llama.cpp binding understands.
+type SampleOptions = sampling.Options
+
+// Available reports whether crow was built with llama.cpp.
+func Available() bool { return true }
 
 func New(modelPath string, opt RunOptions) (*LLaMARunner, error) {
 	ll, err := llama.New(modelPath,
@@ -39,10 +42,25 @@ func (r *LLaMARunner) Generate(prompt string, s SampleOptions) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 	opts := []llama.PredictOption{ llama.Debug(false) }
-	if s.Temperature > 0 { opts = append(opts, llama.SetTemperature(float32(s.Temperature))) }
+	// always forwarded: 0 means greedy on both sides
+	opts = append(opts, llama.SetTemperature(float32(s.Temperature)))
 	if s.TopK > 0 { opts = append(opts, llama.SetTopK(s.TopK)) }
 	if s.TopP > 0 { opts = append(opts, llama.SetTopP(float32(s.TopP))) }
 	if s.RepeatPenalty > 0 { opts = append(opts, llama.SetPenalty(float32(s.RepeatPenalty))) }
+	if s.RepeatLastN > 0 { opts = append(opts, llama.SetRepeat(s.RepeatLastN)) }
+	if s.TypicalP > 0 { opts = append(opts, llama.SetTypicalP(float32(s.TypicalP))) }
+	if s.FrequencyPenalty != 0 { opts = append(opts, llama.SetFrequencyPenalty(float32(s.FrequencyPenalty))) }
+	if s.PresencePenalty != 0 { opts = append(opts, llama.SetPresencePenalty(float32(s.PresencePenalty))) }
+	if s.Mirostat != 0 {
+		opts = append(opts, llama.SetMirostat(s.Mirostat))
+		if s.MirostatTau > 0 { opts = append(opts, llama.SetMirostatTAU(float32(s.MirostatTau))) }
+		if s.MirostatEta > 0 { opts = append(opts, llama.SetMirostatETA(float32(s.MirostatEta))) }
+	}
+	if s.Seed != 0 { opts = append(opts, llama.SetSeed(int(s.Seed&math.MaxInt32))) }
+	if s.MinP > 0 { return "", fmt.Errorf("llama runner: min-p is not supported by the llama.cpp binding") }
+	// the binding parses a single "<id>+<bias>" or "<id>-<bias>"
+	if len(s.LogitBias) > 1 { return "", fmt.Errorf("llama runner: the llama.cpp binding takes one logit bias, got %d", len(s.LogitBias)) }
+	for id, b := range s.LogitBias { opts = append(opts, llama.SetLogitBias(logitBiasArg(id, b))) }
 	resp, err := r.Model.Predict(ctx, prompt, opts...)
 	if err != nil { return "", err }
 	return resp, nil
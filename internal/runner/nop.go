@@ -1,11 +1,21 @@
+//go:build !llama
+
 package runner
 
-import "fmt"
+import (
+    "fmt"
+
+    "github.com/qrv0/crow/internal/sampling"
+)
+
 type LLaMARunner struct{}
 
 type RunOptions struct{ CtxSize, GPULayers int }
 
-type SampleOptions struct{ Temperature float64; TopK int; TopP float64; RepeatPenalty float64 }
+type SampleOptions = sampling.Options
+
+// Available reports whether crow was built with llama.cpp.
+func Available() bool { return false }
 
 func New(modelPath string, opt RunOptions) (*LLaMARunner, error) {
     return nil, fmt.Errorf("llama runner unavailable: build with -tags llama and install go-llama.cpp")
@@ -38,14 +38,45 @@ func Open(path string) (*File, error) {
 	f, err := os.Open(path)
 	if err != nil { return nil, err }
 	defer f.Close()
+	header, hdrLen, err := readHeader(f)
+	if err != nil { return nil, err }
+	// Load tensors
+	pos := int64(8 + hdrLen)
+	res := make(map[string]Tensor)
+	for name, meta := range header {
+		if len(meta.Data) < 2 {
+			// skip non-tensor entries (e.g., metadata records without data_offsets)
+			continue
+		}
+		start, end := meta.Data[0], meta.Data[1]
+		size := end - start
+		if size <= 0 { continue }
+		buf := make([]byte, size)
+		if _, err := f.ReadAt(buf, pos+int64(start)); err != nil { return nil, err }
+		res[name] = Tensor{ Meta: meta, Data: buf }
+	}
+	return &File{ Header: header, Tensors: res }, nil
+}
+
+// ReadHeader parses only the JSON header of a safetensors file, without
+// loading any tensor data.
+func ReadHeader(path string) (Header, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	header, _, err := readHeader(f)
+	return header, err
+}
+
+func readHeader(f *os.File) (Header, uint64, error) {
 	br := bufio.NewReader(f)
 	// read header length (u64 little endian)
 	var hdrLen uint64
-	if err := binaryRead(br, &hdrLen); err != nil { return nil, err }
+	if err := binaryRead(br, &hdrLen); err != nil { return nil, 0, err }
 	hdrBytes := make([]byte, hdrLen)
-	if _, err := io.ReadFull(br, hdrBytes); err != nil { return nil, err }
+	if _, err := io.ReadFull(br, hdrBytes); err != nil { return nil, 0, err }
 	var raw map[string]any
-	if err := json.Unmarshal(hdrBytes, &raw); err != nil { return nil, fmt.Errorf("invalid header: %w", err) }
+	if err := json.Unmarshal(hdrBytes, &raw); err != nil { return nil, 0, fmt.Errorf("invalid header: %w", err) }
 	// Build header filtering only tensor entries with data_offsets
 	header := make(Header)
 	for name, meta := range raw {
@@ -62,22 +93,13 @@ func Open(path string) (*File, error) {
 		doffs := []int64{ int64(doffsAny[0].(float64)), int64(doffsAny[1].(float64)) }
 		header[name] = TensorMeta{ Dtype: dt, Shape: shape, Data: doffs }
 	}
-	// Load tensors
-	pos := int64(8 + hdrLen)
-	res := make(map[string]Tensor)
-	for name, meta := range header {
-		if len(meta.Data) < 2 {
-			// skip non-tensor entries (e.g., metadata records without data_offsets)
-			continue
-		}
-		start, end := meta.Data[0], meta.Data[1]
-		size := end - start
-		if size <= 0 { continue }
-		buf := make([]byte, size)
-		if _, err := f.ReadAt(buf, pos+int64(start)); err != nil { return nil, err }
-		res[name] = Tensor{ Meta: meta, Data: buf }
-	}
-	return &File{ Header: header, Tensors: res }, nil
+	return header, hdrLen, nil
+}
+
+// Size returns the number of bytes the tensor occupies in the file.
+func (m TensorMeta) Size() int64 {
+	if len(m.Data) < 2 { return 0 }
+	return m.Data[1] - m.Data[0]
 }
 
 func binaryRead(r io.Reader, v any) error {
@@ -0,0 +1,44 @@
+package sampling
+
+// Options is the flat form of a chain, as a command line or the llama
+// runner takes it. Zero values switch a stage off, except Temperature,
+// where 0 means greedy.
+type Options struct {
+	Temperature      float64
+	TopK             int
+	TopP             float64
+	MinP             float64
+	TypicalP         float64
+	RepeatPenalty    float64
+	RepeatLastN      int // 0: whole history
+	FrequencyPenalty float64
+	PresencePenalty  float64
+	Mirostat         int // 0 off, 2 for Mirostat v2
+	MirostatTau      float64
+	MirostatEta      float64
+	LogitBias        map[int]float32
+	Seed             uint64
+}
+
+// Chain builds the stages in llama.cpp's order: bias, penalties, then
+// either top-k, typical, top-p, min-p and temperature, or temperature
+// and Mirostat.
+func (o Options) Chain() *Chain {
+	var st []Stage
+	if len(o.LogitBias) > 0 { st = append(st, LogitBias(o.LogitBias)) }
+	if (o.RepeatPenalty != 0 && o.RepeatPenalty != 1) || o.FrequencyPenalty != 0 || o.PresencePenalty != 0 {
+		st = append(st, &Penalties{LastN: o.RepeatLastN, Repeat: float32(o.RepeatPenalty), Frequency: float32(o.FrequencyPenalty), Presence: float32(o.PresencePenalty)})
+	}
+	if o.Temperature <= 0 { return NewChain(o.Seed, append(st, Greedy{})...) }
+	if o.Mirostat == 2 {
+		tau, eta := o.MirostatTau, o.MirostatEta
+		if tau == 0 { tau = 5 }
+		if eta == 0 { eta = 0.1 }
+		return NewChain(o.Seed, append(st, Temperature{float32(o.Temperature)}, &Mirostat{Tau: float32(tau), Eta: float32(eta)})...)
+	}
+	if o.TopK > 0 { st = append(st, TopK{o.TopK}) }
+	if o.TypicalP > 0 && o.TypicalP < 1 { st = append(st, Typical{P: float32(o.TypicalP), MinKeep: 1}) }
+	if o.TopP > 0 && o.TopP < 1 { st = append(st, TopP{P: float32(o.TopP), MinKeep: 1}) }
+	if o.MinP > 0 { st = append(st, MinP{P: float32(o.MinP), MinKeep: 1}) }
+	return NewChain(o.Seed, append(st, Temperature{float32(o.Temperature)})...)
+}
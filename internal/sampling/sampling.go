@@ -0,0 +1,290 @@
+// Package sampling picks the next token from a vector of logits through an
+// ordered chain of stages (penalties, truncation, temperature, Mirostat),
+// then a seeded draw. It follows llama.cpp's samplers closely enough that
+// the same Options mean the same thing on both sides.
+package sampling
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// Token is one candidate: its id, logit and, once a stage has computed it,
+// its probability among the remaining candidates.
+type Token struct {
+	ID    int
+	Logit float32
+	P     float32
+}
+
+// Stage rewrites the candidate list: changing logits, dropping candidates
+// or reordering them. It may reuse toks.
+type Stage interface {
+	Apply(toks []Token) []Token
+}
+
+// Accepter is implemented by stages that track the tokens chosen so far.
+type Accepter interface {
+	Accept(id int)
+}
+
+// Chain runs its stages in order and draws from what is left. It is not
+// safe for concurrent use.
+type Chain struct {
+	Stages []Stage
+	rng    *rand.Rand
+	buf    []Token
+}
+
+// NewChain returns a chain whose draws are fixed by seed.
+func NewChain(seed uint64, stages ...Stage) *Chain {
+	return &Chain{Stages: stages, rng: rand.New(rand.NewPCG(seed, 0x9e3779b97f4a7c15))}
+}
+
+// ErrNoCandidates is returned by Sample when the stages drop every token,
+// e.g. a LogitBias that bans all of them.
+var ErrNoCandidates = errors.New("sampling: every token was dropped")
+
+// Sample picks a token id from logits (indexed by id) and accepts it.
+func (c *Chain) Sample(logits []float32) (int, error) {
+	toks := c.buf[:0]
+	for i, l := range logits { toks = append(toks, Token{ID: i, Logit: l}) }
+	for _, s := range c.Stages { toks = s.Apply(toks) }
+	c.buf = toks[:0]
+	id := c.draw(toks)
+	if id < 0 { return 0, ErrNoCandidates }
+	c.Accept(id)
+	return id, nil
+}
+
+// Accept tells the stages that track history about a token, e.g. the
+// prompt before the first Sample. Sample calls it for its own picks.
+func (c *Chain) Accept(id int) {
+	for _, s := range c.Stages {
+		if a, ok := s.(Accepter); ok { a.Accept(id) }
+	}
+}
+
+// draw samples from the softmax of the remaining logits; -1 if none are left.
+func (c *Chain) draw(toks []Token) int {
+	switch len(toks) {
+	case 0:
+		return -1
+	case 1:
+		return toks[0].ID
+	}
+	softmax(toks)
+	u := c.rng.Float32()
+	for _, t := range toks {
+		if u < t.P { return t.ID }
+		u -= t.P
+	}
+	return toks[len(toks)-1].ID // rounding left u just above the total
+}
+
+// softmax fills P from Logit.
+func softmax(toks []Token) {
+	m := float32(math.Inf(-1))
+	for _, t := range toks { m = max(m, t.Logit) }
+	sum := float32(0)
+	for i := range toks {
+		toks[i].P = float32(math.Exp(float64(toks[i].Logit - m)))
+		sum += toks[i].P
+	}
+	for i := range toks { toks[i].P /= sum }
+}
+
+// sortDesc orders toks by logit, highest first, ties by id.
+func sortDesc(toks []Token) {
+	sort.Slice(toks, func(i, j int) bool {
+		if toks[i].Logit != toks[j].Logit { return toks[i].Logit > toks[j].Logit }
+		return toks[i].ID < toks[j].ID
+	})
+}
+
+// Greedy keeps only the highest logit (lowest id on ties).
+type Greedy struct{}
+
+func (Greedy) Apply(toks []Token) []Token {
+	if len(toks) == 0 { return toks }
+	best := 0
+	for i, t := range toks {
+		if t.Logit > toks[best].Logit || (t.Logit == toks[best].Logit && t.ID < toks[best].ID) { best = i }
+	}
+	toks[0] = toks[best]
+	return toks[:1]
+}
+
+// Temperature divides logits by T; T <= 0 is greedy.
+type Temperature struct{ T float32 }
+
+func (s Temperature) Apply(toks []Token) []Token {
+	if s.T <= 0 { return Greedy{}.Apply(toks) }
+	for i := range toks { toks[i].Logit /= s.T }
+	return toks
+}
+
+// TopK keeps the K highest logits; K <= 0 keeps all.
+type TopK struct{ K int }
+
+func (s TopK) Apply(toks []Token) []Token {
+	if s.K <= 0 || s.K >= len(toks) { return toks }
+	sortDesc(toks)
+	return toks[:s.K]
+}
+
+// TopP keeps the smallest set of most likely tokens whose probability
+// reaches P, and at least MinKeep.
+type TopP struct {
+	P       float32
+	MinKeep int
+}
+
+func (s TopP) Apply(toks []Token) []Token {
+	if s.P >= 1 || len(toks) == 0 { return toks }
+	sortDesc(toks)
+	softmax(toks)
+	cum := float32(0)
+	for i, t := range toks {
+		cum += t.P
+		if cum >= s.P && i+1 >= s.MinKeep { return toks[:i+1] }
+	}
+	return toks
+}
+
+// MinP drops tokens less than P times as likely as the most likely one,
+// keeping at least MinKeep.
+type MinP struct {
+	P       float32
+	MinKeep int
+}
+
+func (s MinP) Apply(toks []Token) []Token {
+	if s.P <= 0 || len(toks) == 0 { return toks }
+	sortDesc(toks)
+	// p_i >= P * p_max  <=>  logit_i >= logit_max + ln P
+	cut := toks[0].Logit + float32(math.Log(float64(s.P)))
+	n := 1
+	for n < len(toks) && (toks[n].Logit >= cut || n < s.MinKeep) { n++ }
+	return toks[:n]
+}
+
+// Typical is locally typical sampling: tokens are ranked by how far their
+// surprise is from the distribution's entropy, and the closest ones are
+// kept until their probability reaches P (at least MinKeep).
+type Typical struct {
+	P       float32
+	MinKeep int
+}
+
+func (s Typical) Apply(toks []Token) []Token {
+	if s.P >= 1 || len(toks) == 0 { return toks }
+	softmax(toks)
+	ent := 0.0
+	for _, t := range toks {
+		if t.P > 0 { ent -= float64(t.P) * math.Log(float64(t.P)) }
+	}
+	dist := func(t Token) float64 { return math.Abs(-math.Log(float64(t.P)) - ent) }
+	sort.SliceStable(toks, func(i, j int) bool { return dist(toks[i]) < dist(toks[j]) })
+	cum := float32(0)
+	for i, t := range toks {
+		cum += t.P
+		if cum >= s.P && i+1 >= s.MinKeep { return toks[:i+1] }
+	}
+	return toks
+}
+
+// LogitBias adds a fixed amount to the logits of given ids; -Inf bans a
+// token outright.
+type LogitBias map[int]float32
+
+func (b LogitBias) Apply(toks []Token) []Token {
+	if len(b) == 0 { return toks }
+	out := toks[:0]
+	for _, t := range toks {
+		t.Logit += b[t.ID]
+		if !math.IsInf(float64(t.Logit), -1) { out = append(out, t) }
+	}
+	return out
+}
+
+// Penalties discourages tokens seen in the last LastN accepted tokens (all
+// of them when LastN <= 0). Repeat divides positive logits and multiplies
+// negative ones (1 = off); Frequency subtracts per occurrence and Presence
+// once per distinct token.
+type Penalties struct {
+	LastN     int
+	Repeat    float32
+	Frequency float32
+	Presence  float32
+	history   []int
+}
+
+func (s *Penalties) Accept(id int) {
+	s.history = append(s.history, id)
+	if s.LastN > 0 && len(s.history) > 2*s.LastN { s.history = append(s.history[:0], s.history[len(s.history)-s.LastN:]...) }
+}
+
+func (s *Penalties) Apply(toks []Token) []Token {
+	recent := s.history
+	if s.LastN > 0 && len(recent) > s.LastN { recent = recent[len(recent)-s.LastN:] }
+	if len(recent) == 0 { return toks }
+	count := make(map[int]int, len(recent))
+	for _, id := range recent { count[id]++ }
+	for i := range toks {
+		n, ok := count[toks[i].ID]
+		if !ok { continue }
+		l := &toks[i].Logit
+		if s.Repeat != 0 && s.Repeat != 1 {
+			if *l > 0 {
+				*l /= s.Repeat
+			} else {
+				*l *= s.Repeat
+			}
+		}
+		*l -= float32(n)*s.Frequency + s.Presence
+	}
+	return toks
+}
+
+// Mirostat is Mirostat v2: it keeps only tokens whose surprise (-log2 p)
+// is at most mu, and after each pick moves mu by Eta towards the target
+// surprise Tau. mu starts at 2*Tau, as in llama.cpp. Put it last; the
+// chain's draw is then the Mirostat draw.
+type Mirostat struct {
+	Tau, Eta float32
+	mu       float32
+	started  bool
+	probs    map[int]float32 // of the last candidates handed out
+}
+
+func (s *Mirostat) Apply(toks []Token) []Token {
+	if !s.started { s.mu, s.started = 2*s.Tau, true }
+	if len(toks) == 0 { return toks }
+	sortDesc(toks)
+	softmax(toks)
+	n := 1
+	for n < len(toks) && -math.Log2(float64(toks[n].P)) <= float64(s.mu) { n++ }
+	toks = toks[:n]
+	softmax(toks)
+	s.probs = make(map[int]float32, n)
+	for _, t := range toks { s.probs[t.ID] = t.P }
+	return toks
+}
+
+// Accept updates mu from the surprise of a token drawn from the last
+// candidates; other tokens (e.g. the prompt) are ignored.
+func (s *Mirostat) Accept(id int) {
+	p, ok := s.probs[id]
+	if !ok { return }
+	s.probs = nil
+	s.mu -= s.Eta * (float32(-math.Log2(float64(p))) - s.Tau)
+}
+
+// Mu is the current surprise cap.
+func (s *Mirostat) Mu() float32 {
+	if !s.started { return 2 * s.Tau }
+	return s.mu
+}
@@ -0,0 +1,142 @@
+package sampling
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// halves has probabilities 1/2, 1/4, 1/8, 1/16, 1/16.
+var halves = []float32{lg(0.5), lg(0.25), lg(0.125), lg(0.0625), lg(0.0625)}
+
+func lg(p float64) float32 { return float32(math.Log(p)) }
+
+func candidates(logits []float32) []Token {
+	toks := make([]Token, len(logits))
+	for i, l := range logits { toks[i] = Token{ID: i, Logit: l} }
+	return toks
+}
+
+// kept applies s to logits and returns the surviving ids, sorted.
+func kept(s Stage, logits []float32) []int {
+	var ids []int
+	for _, t := range s.Apply(candidates(logits)) { ids = append(ids, t.ID) }
+	sort.Ints(ids)
+	return ids
+}
+
+func TestTruncation(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		s    Stage
+		want []int
+	}{
+		{"greedy", Greedy{}, []int{0}},
+		{"temperature 0", Temperature{0}, []int{0}},
+		{"top-k 2", TopK{2}, []int{0, 1}},
+		{"top-k 0", TopK{0}, []int{0, 1, 2, 3, 4}},
+		{"top-p 0.7", TopP{P: 0.7}, []int{0, 1}},
+		{"top-p 0.8", TopP{P: 0.8}, []int{0, 1, 2}},
+		{"top-p min-keep", TopP{P: 0.1, MinKeep: 3}, []int{0, 1, 2}},
+		{"min-p 0.2", MinP{P: 0.2}, []int{0, 1, 2}},
+		{"min-p 0.3", MinP{P: 0.3}, []int{0, 1}},
+		// entropy is 1.875 bits: the 2-bit token is the most typical, and
+		// the 1-bit one comes second
+		{"typical 0.2", Typical{P: 0.2}, []int{1}},
+		{"typical 0.5", Typical{P: 0.5}, []int{0, 1}},
+		{"bias", LogitBias{2: float32(math.Inf(-1))}, []int{0, 1, 3, 4}},
+	} {
+		if got := kept(c.s, halves); !slices.Equal(got, c.want) { t.Errorf("%s: kept %v, want %v", c.name, got, c.want) }
+	}
+}
+
+func TestPenalties(t *testing.T) {
+	logits := []float32{2, -2, 1}
+	p := &Penalties{Repeat: 2, Frequency: 0.5, Presence: 0.25}
+	for _, id := range []int{0, 0, 1} { p.Accept(id) }
+	got := p.Apply(candidates(logits))
+	want := []float32{2/2 - (2*0.5 + 0.25), -2*2 - (0.5 + 0.25), 1}
+	for i, tk := range got {
+		if tk.Logit != want[i] { t.Errorf("logit %d = %v, want %v", i, tk.Logit, want[i]) }
+	}
+	// only the last token counts with LastN 1
+	p = &Penalties{LastN: 1, Presence: 1}
+	for _, id := range []int{0, 0, 1} { p.Accept(id) }
+	got = p.Apply(candidates(logits))
+	if got[0].Logit != 2 || got[1].Logit != -3 { t.Errorf("LastN 1: %+v", got) }
+}
+
+func TestMirostat(t *testing.T) {
+	m := &Mirostat{Tau: 1.6, Eta: 0.1}
+	if m.Mu() != 3.2 { t.Fatalf("initial mu %v", m.Mu()) }
+	m.Accept(0) // not drawn from m: ignored
+	// surprises are 1, 2, 3, 4 and 4 bits; mu 3.2 keeps the first three
+	if got := kept(m, halves); !slices.Equal(got, []int{0, 1, 2}) { t.Fatalf("kept %v", got) }
+	m.Accept(1)
+	want := 3.2 - 0.1*(-math.Log2(0.25/0.875)-1.6)
+	if math.Abs(float64(m.Mu())-want) > 1e-5 { t.Fatalf("mu %v, want %v", m.Mu(), want) }
+	m.Accept(1) // already accepted
+	if math.Abs(float64(m.Mu())-want) > 1e-5 { t.Fatalf("mu moved twice") }
+}
+
+func TestChainDeterministic(t *testing.T) {
+	run := func(seed uint64) []int {
+		c := Options{Temperature: 0.9, TopK: 4, TopP: 0.95, RepeatPenalty: 1.1, Seed: seed}.Chain()
+		var out []int
+		for i := 0; i < 64; i++ {
+			id, err := c.Sample(halves)
+			if err != nil { t.Fatal(err) }
+			out = append(out, id)
+		}
+		return out
+	}
+	a, b := run(7), run(7)
+	if !slices.Equal(a, b) { t.Fatalf("same seed differs:\n%v\n%v", a, b) }
+	if slices.Equal(a, run(8)) { t.Fatalf("seeds 7 and 8 agree") }
+}
+
+// TestChainDistribution checks that the draw follows the softmax.
+func TestChainDistribution(t *testing.T) {
+	c := NewChain(1)
+	const n = 40000
+	counts := make([]int, len(halves))
+	for i := 0; i < n; i++ {
+		id, _ := c.Sample(halves)
+		counts[id]++
+	}
+	for i, p := range []float64{0.5, 0.25, 0.125, 0.0625, 0.0625} {
+		if f := float64(counts[i]) / n; math.Abs(f-p) > 0.01 { t.Errorf("token %d drawn %.4f of the time, want %.4f", i, f, p) }
+	}
+}
+
+func TestOptionsChain(t *testing.T) {
+	types := func(c *Chain) []string {
+		var out []string
+		for _, s := range c.Stages { out = append(out, reflect.TypeOf(s).String()) }
+		return out
+	}
+	for _, c := range []struct {
+		o    Options
+		want []string
+	}{
+		{Options{TopK: 40, TopP: 0.9}, []string{"sampling.Greedy"}},
+		{Options{Temperature: 0.8, TopK: 40, TypicalP: 0.9, TopP: 0.9, MinP: 0.05, RepeatPenalty: 1.1, LogitBias: map[int]float32{1: 1}},
+			[]string{"sampling.LogitBias", "*sampling.Penalties", "sampling.TopK", "sampling.Typical", "sampling.TopP", "sampling.MinP", "sampling.Temperature"}},
+		{Options{Temperature: 0.8, TopK: 40, Mirostat: 2, FrequencyPenalty: 0.1}, []string{"*sampling.Penalties", "sampling.Temperature", "*sampling.Mirostat"}},
+	} {
+		if got := types(c.o.Chain()); !slices.Equal(got, c.want) { t.Errorf("%+v: stages %v, want %v", c.o, got, c.want) }
+	}
+	// greedy ignores the seed and the truncation settings
+	if id, _ := (Options{TopK: 2, Seed: 3}).Chain().Sample([]float32{0, 3, 1}); id != 1 { t.Errorf("greedy picked %d", id) }
+}
+
+func TestSampleNoCandidates(t *testing.T) {
+	ban := float32(math.Inf(-1))
+	for _, o := range []Options{{}, {Temperature: 0.8, TopK: 2, TopP: 0.9, MinP: 0.1}, {Temperature: 0.8, Mirostat: 2}} {
+		o.LogitBias = map[int]float32{0: ban, 1: ban, 2: ban}
+		if id, err := o.Chain().Sample([]float32{0, 3, 1}); !errors.Is(err, ErrNoCandidates) { t.Errorf("%+v: Sample = %d, %v", o, id, err) }
+	}
+}
@@ -0,0 +1,105 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// bpe is a BPE model: merges are applied lowest rank first, leftmost on
+// ties, until no adjacent pair has a merge.
+type bpe struct {
+	vocab        map[string]int
+	ranks        map[[2]string]int
+	unk          int // -1 without an unk token
+	fuseUnk      bool
+	byteFallback bool // unknown symbols become <0xXX> byte tokens
+	ignoreMerges bool // a piece already in the vocabulary is taken whole
+}
+
+type bpeJSON struct {
+	Type         string            `json:"type"`
+	Vocab        map[string]int    `json:"vocab"`
+	Merges       []json.RawMessage `json:"merges"`
+	UnkToken     *string           `json:"unk_token"`
+	FuseUnk      bool              `json:"fuse_unk"`
+	ByteFallback bool              `json:"byte_fallback"`
+	IgnoreMerges bool              `json:"ignore_merges"`
+	Prefix       *string           `json:"continuing_subword_prefix"`
+	Suffix       *string           `json:"end_of_word_suffix"`
+}
+
+func parseBPE(raw json.RawMessage) (*bpe, error) {
+	var j bpeJSON
+	if err := json.Unmarshal(raw, &j); err != nil { return nil, fmt.Errorf("tokenizer model: %w", err) }
+	if j.Type != "BPE" { return nil, fmt.Errorf("unsupported tokenizer model %q (want BPE)", j.Type) }
+	if (j.Prefix != nil && *j.Prefix != "") || (j.Suffix != nil && *j.Suffix != "") { return nil, fmt.Errorf("BPE subword prefix/suffix is not supported") }
+	m := &bpe{vocab: j.Vocab, ranks: make(map[[2]string]int, len(j.Merges)), unk: -1, fuseUnk: j.FuseUnk, byteFallback: j.ByteFallback, ignoreMerges: j.IgnoreMerges}
+	if m.vocab == nil { m.vocab = map[string]int{} }
+	for i, raw := range j.Merges {
+		// "a b" in older files, ["a", "b"] in newer ones
+		var pair [2]string
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			a, b, ok := strings.Cut(s, " ")
+			if !ok { return nil, fmt.Errorf("merge %d: %q is not a pair", i, s) }
+			pair = [2]string{a, b}
+		} else if err := json.Unmarshal(raw, &pair); err != nil {
+			return nil, fmt.Errorf("merge %d: %w", i, err)
+		}
+		if _, ok := m.ranks[pair]; !ok { m.ranks[pair] = i }
+	}
+	if j.UnkToken != nil {
+		id, ok := m.vocab[*j.UnkToken]
+		if !ok { return nil, fmt.Errorf("unk token %q not in vocabulary", *j.UnkToken) }
+		m.unk = id
+	}
+	return m, nil
+}
+
+// encode appends the ids of one pre-tokenized piece to ids.
+func (m *bpe) encode(piece string, ids []int) []int {
+	if m.ignoreMerges {
+		if id, ok := m.vocab[piece]; ok { return append(ids, id) }
+	}
+	syms := make([]string, 0, utf8.RuneCountInString(piece))
+	for _, r := range piece { syms = append(syms, string(r)) }
+	for len(syms) > 1 {
+		best, at := -1, -1
+		for i := 0; i+1 < len(syms); i++ {
+			if r, ok := m.ranks[[2]string{syms[i], syms[i+1]}]; ok && (best < 0 || r < best) { best, at = r, i }
+		}
+		if at < 0 { break }
+		syms[at] += syms[at+1]
+		syms = append(syms[:at+1], syms[at+2:]...)
+	}
+	lastUnk := false
+	for _, s := range syms {
+		if id, ok := m.vocab[s]; ok {
+			ids = append(ids, id)
+			lastUnk = false
+			continue
+		}
+		if m.byteFallback && m.appendBytes(s, &ids) {
+			lastUnk = false
+			continue
+		}
+		if m.unk < 0 || (m.fuseUnk && lastUnk) { continue }
+		ids = append(ids, m.unk)
+		lastUnk = true
+	}
+	return ids
+}
+
+// appendBytes spells s as <0xXX> tokens; false if one is missing.
+func (m *bpe) appendBytes(s string, ids *[]int) bool {
+	out := make([]int, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		id, ok := m.vocab[fmt.Sprintf("<0x%02X>", s[i])]
+		if !ok { return false }
+		out = append(out, id)
+	}
+	*ids = append(*ids, out...)
+	return true
+}
@@ -0,0 +1,186 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// byteRune maps every byte to the printable rune GPT-2's byte-level BPE
+// spells it with: printable Latin-1 bytes stand for themselves, the rest
+// are shifted to 256 and up in byte order.
+var byteRune [256]rune
+
+var runeByte = map[rune]byte{}
+
+func init() {
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			byteRune[b] = rune(b)
+		} else {
+			byteRune[b] = rune(256 + n)
+			n++
+		}
+		runeByte[byteRune[b]] = byte(b)
+	}
+}
+
+func toByteLevel(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ { sb.WriteRune(byteRune[s[i]]) }
+	return sb.String()
+}
+
+// fromByteLevel undoes toByteLevel; runes outside the alphabet (added
+// tokens) keep their UTF-8 bytes. Invalid UTF-8 becomes U+FFFD.
+func fromByteLevel(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if c, ok := runeByte[r]; ok {
+			b = append(b, c)
+		} else {
+			b = utf8.AppendRune(b, r)
+		}
+	}
+	return strings.ToValidUTF8(string(b), "�")
+}
+
+// decoder rewrites the token strings on the way out; the result is joined.
+type decoder func(toks []string) []string
+
+func parseDecoder(raw json.RawMessage) ([]decoder, error) {
+	if isNull(raw) { return nil, nil }
+	var c component
+	if err := json.Unmarshal(raw, &c); err != nil { return nil, fmt.Errorf("decoder: %w", err) }
+	switch c.Type {
+	case "Sequence":
+		var out []decoder
+		for _, r := range c.Decoders {
+			d, err := parseDecoder(r)
+			if err != nil { return nil, err }
+			out = append(out, d...)
+		}
+		return out, nil
+	case "ByteLevel":
+		return []decoder{func(toks []string) []string { return []string{fromByteLevel(strings.Join(toks, ""))} }}, nil
+	case "Replace":
+		re, err := c.Pattern.compile()
+		if err != nil { return nil, fmt.Errorf("Replace decoder: %w", err) }
+		return []decoder{func(toks []string) []string {
+			for i, t := range toks {
+				if s, err := re.Replace(t, c.Content, -1, -1); err == nil { toks[i] = s }
+			}
+			return toks
+		}}, nil
+	case "ByteFallback":
+		return []decoder{byteFallback}, nil
+	case "Fuse":
+		return []decoder{func(toks []string) []string { return []string{strings.Join(toks, "")} }}, nil
+	case "Strip":
+		return []decoder{func(toks []string) []string {
+			for i, t := range toks {
+				for n := 0; n < c.Start && strings.HasPrefix(t, c.Content); n++ { t = t[len(c.Content):] }
+				for n := 0; n < c.Stop && strings.HasSuffix(t, c.Content); n++ { t = t[:len(t)-len(c.Content)] }
+				toks[i] = t
+			}
+			return toks
+		}}, nil
+	case "Metaspace":
+		repl := c.Replacement
+		if repl == "" { repl = metaspace }
+		strip := c.prependScheme() != "never"
+		return []decoder{func(toks []string) []string {
+			for i, t := range toks {
+				t = strings.ReplaceAll(t, repl, " ")
+				if i == 0 && strip { t = strings.TrimPrefix(t, " ") }
+				toks[i] = t
+			}
+			return toks
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported decoder %q", c.Type)
+}
+
+// byteFallback turns runs of <0xXX> tokens back into the UTF-8 they spell,
+// or one U+FFFD per byte if the run is not valid UTF-8.
+func byteFallback(toks []string) []string {
+	var out []string
+	var run []byte
+	flush := func() {
+		if len(run) == 0 { return }
+		if utf8.Valid(run) {
+			out = append(out, string(run))
+		} else {
+			for range run { out = append(out, "�") }
+		}
+		run = run[:0]
+	}
+	for _, t := range toks {
+		if len(t) == 6 && strings.HasPrefix(t, "<0x") && t[5] == '>' {
+			if b, err := strconv.ParseUint(t[3:5], 16, 8); err == nil {
+				run = append(run, byte(b))
+				continue
+			}
+		}
+		flush()
+		out = append(out, t)
+	}
+	flush()
+	return out
+}
+
+// parsePostProcessor returns the special ids placed before and after a
+// single sequence when encoding with addSpecial.
+func parsePostProcessor(raw json.RawMessage) (prefix, suffix []int, err error) {
+	if isNull(raw) { return nil, nil, nil }
+	var c struct {
+		component
+		Single []struct {
+			SpecialToken *struct{ ID string `json:"id"` } `json:"SpecialToken"`
+			Sequence     *struct{ ID string `json:"id"` } `json:"Sequence"`
+		} `json:"single"`
+		SpecialTokens map[string]struct{ IDs []int `json:"ids"` } `json:"special_tokens"`
+		Cls           []json.RawMessage `json:"cls"`
+		Sep           []json.RawMessage `json:"sep"`
+	}
+	if err := json.Unmarshal(raw, &c); err != nil { return nil, nil, fmt.Errorf("post_processor: %w", err) }
+	switch c.Type {
+	case "Sequence":
+		for _, r := range c.Processors {
+			p, s, err := parsePostProcessor(r)
+			if err != nil { return nil, nil, err }
+			prefix, suffix = append(prefix, p...), append(s, suffix...)
+		}
+		return prefix, suffix, nil
+	case "ByteLevel":
+		return nil, nil, nil
+	case "TemplateProcessing":
+		seen := false
+		for _, it := range c.Single {
+			if it.Sequence != nil {
+				seen = true
+				continue
+			}
+			if it.SpecialToken == nil { continue }
+			st, ok := c.SpecialTokens[it.SpecialToken.ID]
+			if !ok { return nil, nil, fmt.Errorf("template token %q not in special_tokens", it.SpecialToken.ID) }
+			if seen {
+				suffix = append(suffix, st.IDs...)
+			} else {
+				prefix = append(prefix, st.IDs...)
+			}
+		}
+		return prefix, suffix, nil
+	case "BertProcessing", "RobertaProcessing":
+		// cls and sep are [token, id]
+		var cls, sep int
+		if len(c.Cls) != 2 || len(c.Sep) != 2 { return nil, nil, fmt.Errorf("%s: cls/sep missing", c.Type) }
+		if err := json.Unmarshal(c.Cls[1], &cls); err != nil { return nil, nil, err }
+		if err := json.Unmarshal(c.Sep[1], &sep); err != nil { return nil, nil, err }
+		return []int{cls}, []int{sep}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported post_processor %q", c.Type)
+}
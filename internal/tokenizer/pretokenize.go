@@ -0,0 +1,252 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/dlclark/regexp2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// gpt2Pattern is the ByteLevel pre-tokenizer's split regex. Like most
+// tokenizer.json patterns it needs lookahead, which RE2 lacks, hence regexp2.
+const gpt2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+// metaspace is SentencePiece's visible space.
+const metaspace = "▁"
+
+type normalizer func(string) string
+
+// preTokenizer splits pieces further; first is set for the text before any
+// added token (Metaspace's prepend_scheme "first").
+type preTokenizer func(pieces []string, first bool) []string
+
+// component is the shape shared by normalizers, pre-tokenizers, decoders
+// and post-processors; each reads the fields it knows.
+type component struct {
+	Type           string            `json:"type"`
+	Normalizers    []json.RawMessage `json:"normalizers"`
+	Pretokenizers  []json.RawMessage `json:"pretokenizers"`
+	Decoders       []json.RawMessage `json:"decoders"`
+	Processors     []json.RawMessage `json:"processors"`
+	Pattern        pattern           `json:"pattern"`
+	Content        string            `json:"content"`
+	Prepend        string            `json:"prepend"`
+	Behavior       string            `json:"behavior"`
+	Invert         bool              `json:"invert"`
+	AddPrefixSpace *bool             `json:"add_prefix_space"`
+	UseRegex       *bool             `json:"use_regex"`
+	Replacement    string            `json:"replacement"`
+	PrependScheme  string            `json:"prepend_scheme"`
+	Split          *bool             `json:"split"`
+	Individual     bool              `json:"individual_digits"`
+	Left           bool              `json:"left"`
+	Right          bool              `json:"right"`
+	Start          int               `json:"start"`
+	Stop           int               `json:"stop"`
+}
+
+// pattern is {"String": ...} or {"Regex": ...}.
+type pattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+func (p pattern) compile() (*regexp2.Regexp, error) {
+	switch {
+	case p.Regex != nil:
+		return regexp2.Compile(*p.Regex, regexp2.None)
+	case p.String != nil:
+		return regexp2.Compile(regexp2.Escape(*p.String), regexp2.None)
+	}
+	return nil, fmt.Errorf("empty pattern")
+}
+
+// prependScheme is Metaspace's prepend_scheme, or the legacy
+// add_prefix_space flag it replaced.
+func (c component) prependScheme() string {
+	if c.PrependScheme != "" { return c.PrependScheme }
+	if c.AddPrefixSpace != nil && !*c.AddPrefixSpace { return "never" }
+	return "always"
+}
+
+func isNull(raw json.RawMessage) bool { return len(raw) == 0 || string(raw) == "null" }
+
+func parseNormalizer(raw json.RawMessage) ([]normalizer, error) {
+	if isNull(raw) { return nil, nil }
+	var c component
+	if err := json.Unmarshal(raw, &c); err != nil { return nil, fmt.Errorf("normalizer: %w", err) }
+	switch c.Type {
+	case "Sequence":
+		var out []normalizer
+		for _, r := range c.Normalizers {
+			n, err := parseNormalizer(r)
+			if err != nil { return nil, err }
+			out = append(out, n...)
+		}
+		return out, nil
+	case "NFC":
+		return []normalizer{norm.NFC.String}, nil
+	case "NFD":
+		return []normalizer{norm.NFD.String}, nil
+	case "NFKC":
+		return []normalizer{norm.NFKC.String}, nil
+	case "NFKD":
+		return []normalizer{norm.NFKD.String}, nil
+	case "Lowercase":
+		return []normalizer{strings.ToLower}, nil
+	case "Prepend":
+		return []normalizer{func(s string) string {
+			if s == "" { return s }
+			return c.Prepend + s
+		}}, nil
+	case "Replace":
+		re, err := c.Pattern.compile()
+		if err != nil { return nil, fmt.Errorf("Replace normalizer: %w", err) }
+		return []normalizer{func(s string) string {
+			out, err := re.Replace(s, c.Content, -1, -1)
+			if err != nil { return s }
+			return out
+		}}, nil
+	case "Strip":
+		return []normalizer{func(s string) string {
+			if c.Left { s = strings.TrimLeftFunc(s, unicode.IsSpace) }
+			if c.Right { s = strings.TrimRightFunc(s, unicode.IsSpace) }
+			return s
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported normalizer %q", c.Type)
+}
+
+func parsePreTokenizer(raw json.RawMessage) ([]preTokenizer, error) {
+	if isNull(raw) { return nil, nil }
+	var c component
+	if err := json.Unmarshal(raw, &c); err != nil { return nil, fmt.Errorf("pre_tokenizer: %w", err) }
+	switch c.Type {
+	case "Sequence":
+		var out []preTokenizer
+		for _, r := range c.Pretokenizers {
+			p, err := parsePreTokenizer(r)
+			if err != nil { return nil, err }
+			out = append(out, p...)
+		}
+		return out, nil
+	case "Split":
+		re, err := c.Pattern.compile()
+		if err != nil { return nil, fmt.Errorf("Split pre-tokenizer: %w", err) }
+		return []preTokenizer{splitter(re, c.Behavior, c.Invert)}, nil
+	case "ByteLevel":
+		var out []preTokenizer
+		if c.AddPrefixSpace != nil && *c.AddPrefixSpace {
+			out = append(out, eachPiece(func(p string, _ bool) []string {
+				if strings.HasPrefix(p, " ") { return []string{p} }
+				return []string{" " + p}
+			}))
+		}
+		if c.UseRegex == nil || *c.UseRegex { out = append(out, splitter(regexp2.MustCompile(gpt2Pattern, regexp2.None), "Isolated", false)) }
+		return append(out, eachPiece(func(p string, _ bool) []string { return []string{toByteLevel(p)} })), nil
+	case "Metaspace":
+		repl := c.Replacement
+		if repl == "" { repl = metaspace }
+		scheme := c.prependScheme()
+		split := c.Split == nil || *c.Split
+		return []preTokenizer{eachPiece(func(p string, first bool) []string {
+			p = strings.ReplaceAll(p, " ", repl)
+			if (scheme == "always" || (scheme == "first" && first)) && !strings.HasPrefix(p, repl) { p = repl + p }
+			if !split { return []string{p} }
+			return splitBefore(p, repl)
+		})}, nil
+	case "Digits":
+		re := regexp2.MustCompile(`\p{Nd}+`, regexp2.None)
+		if c.Individual { re = regexp2.MustCompile(`\p{Nd}`, regexp2.None) }
+		return []preTokenizer{splitter(re, "Isolated", false)}, nil
+	case "Whitespace":
+		return []preTokenizer{splitter(regexp2.MustCompile(`\w+|[^\w\s]+`, regexp2.None), "Removed", true)}, nil
+	case "WhitespaceSplit":
+		return []preTokenizer{splitter(regexp2.MustCompile(`\s+`, regexp2.None), "Removed", false)}, nil
+	case "Punctuation":
+		return []preTokenizer{splitter(regexp2.MustCompile(`\p{P}`, regexp2.None), "Isolated", false)}, nil
+	}
+	return nil, fmt.Errorf("unsupported pre_tokenizer %q", c.Type)
+}
+
+// eachPiece lifts a per-piece function to a preTokenizer; first holds only
+// for the first piece of the first segment.
+func eachPiece(f func(p string, first bool) []string) preTokenizer {
+	return func(pieces []string, first bool) []string {
+		var out []string
+		for i, p := range pieces { out = append(out, f(p, first && i == 0)...) }
+		return out
+	}
+}
+
+// splitBefore cuts s before every occurrence of sep after the start.
+func splitBefore(s, sep string) []string {
+	var out []string
+	for {
+		i := strings.Index(s[min(len(sep), len(s)):], sep)
+		if i < 0 { break }
+		i += min(len(sep), len(s))
+		out = append(out, s[:i])
+		s = s[i:]
+	}
+	return append(out, s)
+}
+
+// splitter implements the Split pre-tokenizer: matches of re (or, with
+// invert, the text between them) are the delimiters, and behavior says
+// what happens to them: Removed, Isolated, MergedWithPrevious,
+// MergedWithNext or Contiguous.
+func splitter(re *regexp2.Regexp, behavior string, invert bool) preTokenizer {
+	return eachPiece(func(p string, _ bool) []string {
+		type span struct {
+			s     string
+			delim bool
+		}
+		r := []rune(p)
+		var spans []span
+		prev := 0
+		m, _ := re.FindRunesMatch(r)
+		for m != nil {
+			if m.Length == 0 {
+				m, _ = re.FindNextMatch(m)
+				continue
+			}
+			if m.Index > prev { spans = append(spans, span{string(r[prev:m.Index]), invert}) }
+			spans = append(spans, span{string(r[m.Index : m.Index+m.Length]), !invert})
+			prev = m.Index + m.Length
+			m, _ = re.FindNextMatch(m)
+		}
+		if prev < len(r) { spans = append(spans, span{string(r[prev:]), invert}) }
+		var out []string
+		for i, sp := range spans {
+			switch behavior {
+			case "Removed":
+				if !sp.delim { out = append(out, sp.s) }
+			case "MergedWithPrevious":
+				if sp.delim && len(out) > 0 && !spans[i-1].delim {
+					out[len(out)-1] += sp.s
+				} else {
+					out = append(out, sp.s)
+				}
+			case "MergedWithNext":
+				if i > 0 && spans[i-1].delim && !sp.delim {
+					out[len(out)-1] += sp.s
+				} else {
+					out = append(out, sp.s)
+				}
+			case "Contiguous":
+				if sp.delim && i > 0 && spans[i-1].delim {
+					out[len(out)-1] += sp.s
+				} else {
+					out = append(out, sp.s)
+				}
+			default: // Isolated
+				out = append(out, sp.s)
+			}
+		}
+		return out
+	})
+}
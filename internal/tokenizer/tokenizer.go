@@ -0,0 +1,177 @@
+// Package tokenizer encodes and decodes text with a Hugging Face
+// tokenizer.json: BPE models in byte-level (GPT-2, Llama 3, Qwen2) and
+// SentencePiece (Llama 2, Mistral) style, added and special tokens,
+// normalizers, pre-tokenizer regexes, decoders and template post-processing.
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer is immutable after loading and safe for concurrent use.
+type Tokenizer struct {
+	model  *bpe
+	tokens []string // by id, model vocabulary plus added tokens
+	added  []addedToken
+	byID   map[int]*addedToken
+
+	normalize []normalizer
+	pre       []preTokenizer
+	decoders  []decoder
+	prefix    []int // post-processor ids around a single sequence
+	suffix    []int
+}
+
+type addedToken struct {
+	ID         int    `json:"id"`
+	Content    string `json:"content"`
+	Special    bool   `json:"special"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Normalized bool   `json:"normalized"`
+}
+
+type fileJSON struct {
+	AddedTokens   []addedToken    `json:"added_tokens"`
+	Normalizer    json.RawMessage `json:"normalizer"`
+	PreTokenizer  json.RawMessage `json:"pre_tokenizer"`
+	Model         json.RawMessage `json:"model"`
+	Decoder       json.RawMessage `json:"decoder"`
+	PostProcessor json.RawMessage `json:"post_processor"`
+}
+
+// Load reads a tokenizer.json file.
+func Load(path string) (*Tokenizer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	return Parse(b)
+}
+
+// Parse builds a tokenizer from the contents of a tokenizer.json.
+func Parse(data []byte) (*Tokenizer, error) {
+	var f fileJSON
+	if err := json.Unmarshal(data, &f); err != nil { return nil, fmt.Errorf("tokenizer.json: %w", err) }
+	m, err := parseBPE(f.Model)
+	if err != nil { return nil, err }
+	t := &Tokenizer{model: m, byID: map[int]*addedToken{}}
+	t.tokens = make([]string, len(m.vocab))
+	for tok, id := range m.vocab {
+		if id < 0 { return nil, fmt.Errorf("negative id for token %q", tok) }
+		for id >= len(t.tokens) { t.tokens = append(t.tokens, "") }
+		t.tokens[id] = tok
+	}
+	t.added = f.AddedTokens
+	for i := range t.added {
+		a := &t.added[i]
+		for a.ID >= len(t.tokens) { t.tokens = append(t.tokens, "") }
+		t.tokens[a.ID] = a.Content
+	}
+	// longest first, so "<|im_start|>" wins over "<|im"
+	sort.SliceStable(t.added, func(i, j int) bool { return len(t.added[i].Content) > len(t.added[j].Content) })
+	for i := range t.added { t.byID[t.added[i].ID] = &t.added[i] }
+	if t.normalize, err = parseNormalizer(f.Normalizer); err != nil { return nil, err }
+	if t.pre, err = parsePreTokenizer(f.PreTokenizer); err != nil { return nil, err }
+	if t.decoders, err = parseDecoder(f.Decoder); err != nil { return nil, err }
+	if t.prefix, t.suffix, err = parsePostProcessor(f.PostProcessor); err != nil { return nil, err }
+	return t, nil
+}
+
+// VocabSize is one past the largest token id.
+func (t *Tokenizer) VocabSize() int { return len(t.tokens) }
+
+// Token returns the vocabulary string of id, "" when out of range.
+func (t *Tokenizer) Token(id int) string {
+	if id < 0 || id >= len(t.tokens) { return "" }
+	return t.tokens[id]
+}
+
+// ID looks a token string up in the vocabulary and the added tokens.
+func (t *Tokenizer) ID(tok string) (int, bool) {
+	for _, a := range t.added {
+		if a.Content == tok { return a.ID, true }
+	}
+	id, ok := t.model.vocab[tok]
+	return id, ok
+}
+
+// IsSpecial reports whether id is an added token marked special.
+func (t *Tokenizer) IsSpecial(id int) bool {
+	a, ok := t.byID[id]
+	return ok && a.Special
+}
+
+// Encode tokenizes text. Added tokens are matched literally first; the rest
+// is normalized, pre-tokenized and run through BPE. addSpecial wraps the
+// result in the post-processor's tokens (e.g. a leading <s>).
+func (t *Tokenizer) Encode(text string, addSpecial bool) []int {
+	var ids []int
+	if addSpecial { ids = append(ids, t.prefix...) }
+	for i, seg := range t.splitAdded(text) {
+		if seg.added != nil {
+			ids = append(ids, seg.added.ID)
+			continue
+		}
+		s := seg.text
+		for _, n := range t.normalize { s = n(s) }
+		pieces := []string{s}
+		for _, p := range t.pre { pieces = p(pieces, i == 0) }
+		for _, p := range pieces {
+			if p != "" { ids = t.model.encode(p, ids) }
+		}
+	}
+	if addSpecial { ids = append(ids, t.suffix...) }
+	return ids
+}
+
+type segment struct {
+	text  string
+	added *addedToken
+}
+
+// splitAdded cuts text around added tokens, honouring lstrip/rstrip by
+// dropping the whitespace next to them.
+func (t *Tokenizer) splitAdded(text string) []segment {
+	var out []segment
+	start := 0
+	for i := 0; i < len(text); {
+		var hit *addedToken
+		for j := range t.added {
+			if strings.HasPrefix(text[i:], t.added[j].Content) && t.added[j].Content != "" {
+				hit = &t.added[j]
+				break
+			}
+		}
+		if hit == nil {
+			i++
+			continue
+		}
+		before := text[start:i]
+		if hit.LStrip { before = strings.TrimRightFunc(before, unicode.IsSpace) }
+		if before != "" { out = append(out, segment{text: before}) }
+		out = append(out, segment{added: hit})
+		i += len(hit.Content)
+		if hit.RStrip {
+			for i < len(text) && (text[i] == ' ' || text[i] == '\t' || text[i] == '\n' || text[i] == '\r') { i++ }
+		}
+		start = i
+	}
+	if start < len(text) { out = append(out, segment{text: text[start:]}) }
+	return out
+}
+
+// Decode turns ids back into text. skipSpecial drops special added tokens
+// (BOS, EOS, chat markers).
+func (t *Tokenizer) Decode(ids []int, skipSpecial bool) string {
+	toks := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if skipSpecial && t.IsSpecial(id) { continue }
+		toks = append(toks, t.Token(id))
+	}
+	for _, d := range t.decoders { toks = d(toks) }
+	return strings.Join(toks, "")
+}
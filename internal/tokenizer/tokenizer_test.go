@@ -0,0 +1,111 @@
+package tokenizer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The testdata files are cut-down tokenizer.json files in the exact shape
+// the Hugging Face exporter writes for each family: the real byte alphabet
+// and special tokens, and just enough merges to build a few words, which
+// keep their real ids.
+
+type golden struct {
+	text       string
+	addSpecial bool
+	ids        []int
+	decoded    string // Decode(ids, true); defaults to text
+}
+
+func checkGolden(t *testing.T, tk *Tokenizer, cases []golden) {
+	t.Helper()
+	for _, c := range cases {
+		got := tk.Encode(c.text, c.addSpecial)
+		if !slices.Equal(got, c.ids) { t.Errorf("Encode(%q) = %v, want %v", c.text, got, c.ids) }
+		want := c.decoded
+		if want == "" { want = c.text }
+		if s := tk.Decode(c.ids, true); s != want { t.Errorf("Decode(%v) = %q, want %q", c.ids, s, want) }
+	}
+}
+
+func load(t *testing.T, name string) *Tokenizer {
+	t.Helper()
+	tk, err := Load(filepath.Join("testdata", name))
+	if err != nil { t.Fatal(err) }
+	return tk
+}
+
+func TestGPT2(t *testing.T) {
+	tk := load(t, "gpt2.json")
+	checkGolden(t, tk, []golden{
+		{text: "Hello world!", ids: []int{15496, 995, 0}},
+		{text: " the", ids: []int{262}},
+		{text: "Hello\n\nworld", ids: []int{15496, 198, 198, 86, 273, 335}},
+		{text: "é", ids: []int{127, 102}},
+		{text: "Hello<|endoftext|>", ids: []int{15496, 50256}, decoded: "Hello"},
+	})
+	if s := tk.Decode([]int{15496, 50256}, false); s != "Hello<|endoftext|>" { t.Errorf("Decode without skip = %q", s) }
+	if !tk.IsSpecial(50256) || tk.IsSpecial(0) { t.Errorf("IsSpecial") }
+	if id, ok := tk.ID("Ġworld"); !ok || id != 995 { t.Errorf("ID(Ġworld) = %d %v", id, ok) }
+	if tk.VocabSize() != 50257 || tk.Token(995) != "Ġworld" || tk.Token(-1) != "" { t.Errorf("vocab lookups") }
+	// half a UTF-8 sequence decodes to U+FFFD instead of invalid bytes
+	if s := tk.Decode([]int{127}, true); s != "�" { t.Errorf("Decode(partial) = %q", s) }
+}
+
+func TestLlama2(t *testing.T) {
+	tk := load(t, "llama2.json")
+	checkGolden(t, tk, []golden{
+		{text: "Hello world", addSpecial: true, ids: []int{1, 15043, 3186}},
+		{text: "Hello world", ids: []int{15043, 3186}},
+		// \n is not in the vocabulary: byte fallback
+		{text: "Hello\n", addSpecial: true, ids: []int{1, 15043, 13}},
+		{text: "é", addSpecial: true, ids: []int{1, 29871, 198, 172}},
+		{text: "Hello</s>", addSpecial: true, ids: []int{1, 15043, 2}, decoded: "Hello"},
+	})
+}
+
+// TestMetaspace swaps Llama 2's normalizer for the Metaspace pre-tokenizer
+// newer SentencePiece conversions (Mistral, Llama 2 after tokenizers 0.14)
+// use, with the prefix space only before the first segment.
+func TestMetaspace(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "llama2.json"))
+	if err != nil { t.Fatal(err) }
+	s := string(b)
+	i, j := strings.Index(s, `"normalizer"`), strings.Index(s, `"post_processor"`)
+	s = s[:i] + `"normalizer": null, "pre_tokenizer": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "first", "split": false},` + s[j:]
+	i, j = strings.Index(s, `"decoder"`), strings.Index(s, `"model"`)
+	s = s[:i] + `"decoder": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "first", "split": false},` + s[j:]
+	tk, err := Parse([]byte(s))
+	if err != nil { t.Fatal(err) }
+	checkGolden(t, tk, []golden{
+		{text: "Hello world</s> world", addSpecial: true, ids: []int{1, 15043, 3186, 2, 3186}, decoded: "Hello world world"},
+		{text: "Hello</s>world", ids: []int{15043, 2, 29893, 272, 430}, decoded: "Helloworld"},
+	})
+}
+
+func TestQwen2(t *testing.T) {
+	tk := load(t, "qwen2.json")
+	checkGolden(t, tk, []golden{
+		{text: "Hello world", addSpecial: true, ids: []int{9707, 1879}},
+		// digits are split one by one, so neither "Ġ1" nor "12" applies
+		{text: " 123", ids: []int{220, 16, 17, 18}},
+		// NFC composes e + U+0301 into é before the byte-level merge
+		{text: "e\u0301", ids: []int{963}, decoded: "\u00e9"},
+		{text: "<|im_start|>user\nHi<|im_end|>", ids: []int{151644, 84, 82, 68, 81, 198, 39, 72, 151645}, decoded: "user\nHi"},
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		`{`,
+		`{"model": {"type": "Unigram", "vocab": []}}`,
+		`{"model": {"type": "BPE", "vocab": {"a": 0}, "merges": ["ab"]}}`,
+		`{"model": {"type": "BPE", "vocab": {"a": 0}, "merges": [], "unk_token": "<unk>"}}`,
+		`{"model": {"type": "BPE", "vocab": {}, "merges": []}, "pre_tokenizer": {"type": "Nope"}}`,
+	} {
+		if _, err := Parse([]byte(s)); err == nil { t.Errorf("Parse(%s) succeeded", s) }
+	}
+}